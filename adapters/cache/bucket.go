@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bucket is a token bucket holding at most Capacity tokens,
+// a token is added back every Interval.
+type Bucket struct {
+	Capacity int64
+	Interval time.Duration
+}
+
+// BucketState is the state of a bucket after a token was taken.
+type BucketState struct {
+	Allowed   bool
+	Remaining int64
+	// RetryAfter is the time until the next token is added, set when not allowed.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+// take refills a bucket holding tokens, last refilled at refilledAt,
+// and takes a token from it if there is one left. A zero refilledAt
+// is a full bucket. It returns the new tokens and refill time.
+func (b Bucket) take(tokens int64, refilledAt, now time.Time) (int64, time.Time, *BucketState) {
+	if refilledAt.IsZero() {
+		tokens, refilledAt = b.Capacity, now
+	}
+
+	refill := int64(now.Sub(refilledAt) / b.Interval)
+	if refill > 0 {
+		tokens += refill
+		refilledAt = refilledAt.Add(time.Duration(refill) * b.Interval)
+	}
+	if tokens >= b.Capacity {
+		tokens, refilledAt = b.Capacity, now
+	}
+
+	allowed := tokens > 0
+	if allowed {
+		tokens--
+	}
+
+	return tokens, refilledAt, b.state(allowed, tokens, refilledAt, now)
+}
+
+// state describes a bucket holding tokens, last refilled at refilledAt.
+func (b Bucket) state(allowed bool, tokens int64, refilledAt, now time.Time) *BucketState {
+	state := &BucketState{
+		Allowed:   allowed,
+		Remaining: tokens,
+		Reset:     refilledAt.Add(time.Duration(b.Capacity-tokens) * b.Interval).Sub(now),
+	}
+	if !allowed {
+		state.RetryAfter = refilledAt.Add(b.Interval).Sub(now)
+	}
+	return state
+}
+
+// encodeBucket encodes the tokens and refill time of a bucket as a cache value.
+func encodeBucket(tokens int64, refilledAt time.Time) string {
+	return strconv.FormatInt(tokens, 10) + " " + strconv.FormatInt(refilledAt.UnixNano(), 10)
+}
+
+// decodeBucket decodes a cache value written by encodeBucket,
+// a malformed value is decoded as a full bucket.
+func decodeBucket(value string) (int64, time.Time) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return 0, time.Time{}
+	}
+
+	tokens, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, time.Time{}
+	}
+	refilledAt, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}
+	}
+	return tokens, time.Unix(0, refilledAt)
+}
@@ -0,0 +1,56 @@
+// +build !integration
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucket_Take(t *testing.T) {
+	bucket := Bucket{Capacity: 3, Interval: time.Second}
+	now := time.Unix(1000, 0)
+
+	// a new bucket starts full.
+	tokens, refilledAt, state := bucket.take(0, time.Time{}, now)
+	assert.True(t, state.Allowed)
+	assert.Equal(t, int64(2), state.Remaining)
+	assert.Equal(t, time.Second, state.Reset)
+
+	tokens, refilledAt, _ = bucket.take(tokens, refilledAt, now)
+	tokens, refilledAt, state = bucket.take(tokens, refilledAt, now)
+	assert.True(t, state.Allowed)
+	assert.Equal(t, int64(0), state.Remaining)
+	assert.Equal(t, time.Second*3, state.Reset)
+
+	// empty until the next token is added.
+	now = now.Add(time.Millisecond * 400)
+	tokens, refilledAt, state = bucket.take(tokens, refilledAt, now)
+	assert.False(t, state.Allowed)
+	assert.Equal(t, time.Millisecond*600, state.RetryAfter)
+
+	// partial intervals are kept across refills.
+	now = now.Add(time.Millisecond * 1700)
+	tokens, refilledAt, state = bucket.take(tokens, refilledAt, now)
+	assert.True(t, state.Allowed)
+	assert.Equal(t, int64(1), state.Remaining)
+	assert.Equal(t, time.Unix(1002, 0), refilledAt)
+
+	// never more than the capacity.
+	now = now.Add(time.Hour)
+	_, _, state = bucket.take(tokens, refilledAt, now)
+	assert.Equal(t, int64(2), state.Remaining)
+}
+
+func TestBucket_Encoding(t *testing.T) {
+	refilledAt := time.Unix(1000, 42)
+
+	tokens, decoded := decodeBucket(encodeBucket(7, refilledAt))
+	assert.Equal(t, int64(7), tokens)
+	assert.True(t, refilledAt.Equal(decoded))
+
+	_, decoded = decodeBucket("garbage")
+	assert.True(t, decoded.IsZero())
+}
@@ -1,35 +1,60 @@
 package cache
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
 	"gicicm/config"
 	"gicicm/logger"
+	"gicicm/metrics"
 
 	"github.com/go-redis/redis"
 	"go.uber.org/zap"
 )
 
+// Cache is an adapter layer for the cache.
 type Cache interface {
-	Get(key string) (string, error)
-	Set(key string, value string, duration time.Duration) (string, error)
-	Del(key string) error
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value string, duration time.Duration) (string, error)
+	SetEvictable(ctx context.Context, key string, value string, duration time.Duration) (string, error)
+	Del(ctx context.Context, key string) error
+	Incr(ctx context.Context, key string, duration time.Duration) (int64, error)
+	AddMember(ctx context.Context, key string, member string, expiresAt time.Time) error
+	Members(ctx context.Context, key string) ([]string, error)
+	RemoveMembers(ctx context.Context, key string, members ...string) error
+	TakeToken(ctx context.Context, key string, bucket Bucket) (*BucketState, error)
+	Ping(ctx context.Context) error
+	Close() error
 }
 
+// ErrNotFound is returned by Get when a key does not exist or is expired.
+var ErrNotFound = errors.New("cache: key not found")
+
 type cache struct {
-	cacheConn redis.Cmdable
+	cacheConn *redis.Client
 }
 
-// NewCache returns an instance of newCache
+// NewCache returns an instance of the cache selected by the config.
 func NewCache(config *config.Config) Cache {
-	cacheConn := newCacheConnection(config)
-	return &cache{
-		cacheConn: cacheConn,
+	switch config.Cache.Driver {
+	case "memory":
+		return NewMemoryCache(config.Cache.MaxSize, time.Minute)
+	case "redis":
+		cacheConn := newCacheConnection(config)
+		return &cache{
+			cacheConn: cacheConn,
+		}
+	default:
+		logger.Log().Fatal("unknown cache driver", zap.String("driver", config.Cache.Driver))
+		return nil
 	}
 }
 
 // newCacheConnection initializes a cache connection
-func newCacheConnection(config *config.Config) redis.Cmdable {
+func newCacheConnection(config *config.Config) *redis.Client {
 	cacheConn := redis.NewClient(&redis.Options{
 		Addr:        config.Cache.Host,
 		Password:    "",
@@ -43,28 +68,193 @@ func newCacheConnection(config *config.Config) redis.Cmdable {
 }
 
 // Get gets a value from redis
-func (c *cache) Get(key string) (string, error) {
-	data, err := c.cacheConn.Get(key).Result()
+func (c *cache) Get(ctx context.Context, key string) (string, error) {
+	data, err := c.cacheConn.WithContext(ctx).Get(key).Result()
+	if err == redis.Nil {
+		metrics.CacheRequests.WithLabelValues("redis", metrics.ResultMiss).Inc()
+		return "", ErrNotFound
+	}
 	if err != nil {
-		logger.Log().Error("Error while fetching data from redis", zap.String("key", key), zap.Error(err))
+		metrics.CacheRequests.WithLabelValues("redis", metrics.ResultError).Inc()
+		logger.FromContext(ctx).Error("Error while fetching data from redis", zap.String("key", key), zap.Error(err))
+		return data, err
 	}
-	return data, err
+	metrics.CacheRequests.WithLabelValues("redis", metrics.ResultHit).Inc()
+	return data, nil
 }
 
 // Set sets a  value to redis
-func (c *cache) Set(key string, value string, duration time.Duration) (string, error) {
-	result, err := c.cacheConn.Set(key, value, duration).Result()
+func (c *cache) Set(ctx context.Context, key string, value string, duration time.Duration) (string, error) {
+	result, err := c.cacheConn.WithContext(ctx).Set(key, value, duration).Result()
 	if err != nil {
-		logger.Log().Error("Error while storing data to redis", zap.String("key", key), zap.Error(err))
+		logger.FromContext(ctx).Error("Error while storing data to redis", zap.String("key", key), zap.Error(err))
 	}
 	return result, err
 }
 
+// SetEvictable sets a value that may be evicted to redis. Redis applies its
+// maxmemory-policy to all the keys alike, it must be run with noeviction so
+// that the values set with Set are never evicted.
+func (c *cache) SetEvictable(ctx context.Context, key string, value string, duration time.Duration) (string, error) {
+	return c.Set(ctx, key, value, duration)
+}
+
 // Del Deletes a key from redis
-func (c *cache) Del(key string) error {
-	err := c.cacheConn.Del(key).Err()
+func (c *cache) Del(ctx context.Context, key string) error {
+	err := c.cacheConn.WithContext(ctx).Del(key).Err()
+	if err != nil {
+		logger.FromContext(ctx).Error("Error while deleting key", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// incrScript increments a counter and sets its expiry when it is created,
+// as a script so that a counter can never be left without an expiry.
+var incrScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// Incr increments a counter in redis, the duration
+// starts when the counter is created and is not extended.
+func (c *cache) Incr(ctx context.Context, key string, duration time.Duration) (int64, error) {
+	count, err := incrScript.Run(c.cacheConn.WithContext(ctx), []string{key}, duration.Milliseconds()).Int64()
+	if err != nil {
+		logger.FromContext(ctx).Error("Error while incrementing counter in redis", zap.String("key", key), zap.Error(err))
+		return 0, err
+	}
+	return count, nil
+}
+
+// addMemberScript adds a member to a sorted set scored by its expiry, drops the
+// expired members and expires the set with its last member, as a script so
+// that concurrent adds are not lost and the set never outlives its members.
+var addMemberScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[3])
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+local last = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+redis.call("PEXPIREAT", KEYS[1], last[2])
+return #last
+`)
+
+// AddMember adds a member to a set in redis until expiresAt,
+// adding an existing member updates its expiry.
+func (c *cache) AddMember(ctx context.Context, key string, member string, expiresAt time.Time) error {
+	err := addMemberScript.Run(c.cacheConn.WithContext(ctx), []string{key},
+		member, expiresAt.UnixNano()/int64(time.Millisecond), time.Now().UnixNano()/int64(time.Millisecond)).Err()
+	if err != nil {
+		logger.FromContext(ctx).Error("Error while adding member in redis", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Members returns the members of a set in redis that have not expired,
+// ordered by expiry.
+func (c *cache) Members(ctx context.Context, key string) ([]string, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	members, err := c.cacheConn.WithContext(ctx).ZRangeByScore(key, redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(now, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		logger.FromContext(ctx).Error("Error while fetching members from redis", zap.String("key", key), zap.Error(err))
+		return nil, err
+	}
+	return members, nil
+}
+
+// RemoveMembers removes members from a set in redis.
+func (c *cache) RemoveMembers(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+	err := c.cacheConn.WithContext(ctx).ZRem(key, values...).Err()
+	if err != nil {
+		logger.FromContext(ctx).Error("Error while removing members from redis", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// takeTokenScript refills a token bucket stored as a hash and takes a token,
+// as a script so that concurrent requests can not take the same token.
+// the bucket expires once it is full again. It mirrors Bucket.take
+// in milliseconds, the time is passed in so that it matches the memory cache.
+var takeTokenScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "refilled_at")
+local tokens = tonumber(bucket[1])
+local refilledAt = tonumber(bucket[2])
+if tokens == nil or refilledAt == nil then
+	tokens = capacity
+	refilledAt = now
+end
+
+local refill = math.floor((now - refilledAt) / interval)
+if refill > 0 then
+	tokens = tokens + refill
+	refilledAt = refilledAt + refill * interval
+end
+if tokens >= capacity then
+	tokens = capacity
+	refilledAt = now
+end
+
+local allowed = 0
+if tokens > 0 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tokens, "refilled_at", refilledAt)
+redis.call("PEXPIRE", KEYS[1], math.max(refilledAt + (capacity - tokens) * interval - now, 1))
+return {allowed, tokens, refilledAt}
+`)
+
+// TakeToken takes a token from a bucket in redis.
+func (c *cache) TakeToken(ctx context.Context, key string, bucket Bucket) (*BucketState, error) {
+	now := time.Now()
+	result, err := takeTokenScript.Run(c.cacheConn.WithContext(ctx), []string{key},
+		bucket.Capacity, bucket.Interval.Milliseconds(), now.UnixNano()/int64(time.Millisecond)).Result()
+	if err != nil {
+		logger.FromContext(ctx).Error("Error while taking token from redis", zap.String("key", key), zap.Error(err))
+		return nil, err
+	}
+
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 3 {
+		return nil, fmt.Errorf("unexpected token bucket result %v", result)
+	}
+	allowed, _ := values[0].(int64)
+	tokens, _ := values[1].(int64)
+	refilledAt, _ := values[2].(int64)
+
+	return bucket.state(allowed == 1, tokens, time.Unix(0, refilledAt*int64(time.Millisecond)), now), nil
+}
+
+// Ping checks the connection to redis.
+func (c *cache) Ping(ctx context.Context) error {
+	return c.cacheConn.WithContext(ctx).Ping().Err()
+}
+
+// Close closes the connections to redis.
+func (c *cache) Close() error {
+	err := c.cacheConn.Close()
 	if err != nil {
-		logger.Log().Error("Error while deleting key", zap.String("key", key), zap.Error(err))
+		logger.Log().Error("Error while closing redis connection", zap.Error(err))
 		return err
 	}
 	return nil
@@ -0,0 +1,406 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gicicm/metrics"
+)
+
+// Stats contains counters about the usage of the memory cache.
+type Stats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+	Size        int
+}
+
+// MemoryCache is an in process cache with ttl expiry and a least recently
+// used eviction policy for the entries stored with SetEvictable. All the other
+// entries are state that must not be lost, they are only removed once expired.
+type MemoryCache struct {
+	mu      sync.Mutex
+	maxSize int
+	items   map[string]*list.Element
+	// lru holds the evictable entries ordered from
+	// the most to the least recently used.
+	lru *list.List
+	// pinned holds the entries that are never evicted.
+	pinned *list.List
+	stats  Stats
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// entry is a single value stored in the memory cache.
+type entry struct {
+	key       string
+	value     string
+	expiresAt time.Time
+	evictable bool
+	// members holds the members of a set and their expiry.
+	members map[string]time.Time
+}
+
+// expired checks whether an entry is expired, entries
+// without an expiry never expire.
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// NewMemoryCache returns a new memory cache holding at most maxSize evictable
+// entries, a janitor removes expired entries every janitorInterval until Stop
+// is called. a maxSize of 0 means unlimited.
+func NewMemoryCache(maxSize int, janitorInterval time.Duration) *MemoryCache {
+	mc := &MemoryCache{
+		maxSize: maxSize,
+		items:   make(map[string]*list.Element),
+		lru:     list.New(),
+		pinned:  list.New(),
+		stop:    make(chan struct{}),
+	}
+
+	if janitorInterval > 0 {
+		go mc.janitor(janitorInterval)
+	}
+
+	return mc
+}
+
+// Get gets a value from the cache.
+func (mc *MemoryCache) Get(ctx context.Context, key string) (string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	element, ok := mc.items[key]
+	if !ok {
+		mc.stats.Misses++
+		metrics.CacheRequests.WithLabelValues("memory", metrics.ResultMiss).Inc()
+		return "", ErrNotFound
+	}
+
+	e := element.Value.(*entry)
+	if e.expired(time.Now()) {
+		mc.removeElement(element)
+		mc.stats.Expirations++
+		mc.stats.Misses++
+		metrics.CacheRequests.WithLabelValues("memory", metrics.ResultMiss).Inc()
+		return "", ErrNotFound
+	}
+
+	mc.touch(element)
+	mc.stats.Hits++
+	metrics.CacheRequests.WithLabelValues("memory", metrics.ResultHit).Inc()
+	return e.value, nil
+}
+
+// Set sets a value to the cache that is never evicted, a duration of 0 means no expiry.
+func (mc *MemoryCache) Set(ctx context.Context, key string, value string, duration time.Duration) (string, error) {
+	return mc.set(key, value, duration, false)
+}
+
+// SetEvictable sets a value to the cache that may be evicted
+// when the cache is full, a duration of 0 means no expiry.
+func (mc *MemoryCache) SetEvictable(ctx context.Context, key string, value string, duration time.Duration) (string, error) {
+	return mc.set(key, value, duration, true)
+}
+
+// set stores an entry, replacing the entry of the key.
+func (mc *MemoryCache) set(key string, value string, duration time.Duration, evictable bool) (string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	var expiresAt time.Time
+	if duration > 0 {
+		expiresAt = time.Now().Add(duration)
+	}
+
+	if element, ok := mc.items[key]; ok {
+		mc.removeElement(element)
+	}
+	mc.insert(&entry{
+		key:       key,
+		value:     value,
+		expiresAt: expiresAt,
+		evictable: evictable,
+	})
+
+	return "OK", nil
+}
+
+// Del deletes a key from the cache.
+func (mc *MemoryCache) Del(ctx context.Context, key string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if element, ok := mc.items[key]; ok {
+		mc.removeElement(element)
+	}
+	return nil
+}
+
+// Incr increments a counter, a missing or expired counter starts at 1
+// and expires after the duration, the expiry is not extended afterwards.
+func (mc *MemoryCache) Incr(ctx context.Context, key string, duration time.Duration) (int64, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if element, ok := mc.items[key]; ok {
+		e := element.Value.(*entry)
+		if !e.expired(time.Now()) {
+			count, err := strconv.ParseInt(e.value, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			count++
+			e.value = strconv.FormatInt(count, 10)
+			mc.touch(element)
+			return count, nil
+		}
+		mc.removeElement(element)
+		mc.stats.Expirations++
+	}
+
+	var expiresAt time.Time
+	if duration > 0 {
+		expiresAt = time.Now().Add(duration)
+	}
+
+	mc.insert(&entry{
+		key:       key,
+		value:     "1",
+		expiresAt: expiresAt,
+	})
+
+	return 1, nil
+}
+
+// AddMember adds a member to a set until expiresAt, adding an existing member
+// updates its expiry. The expired members are dropped and the set expires
+// with its last member.
+func (mc *MemoryCache) AddMember(ctx context.Context, key string, member string, expiresAt time.Time) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	e := mc.liveSet(key, time.Now())
+	if e == nil {
+		if element, ok := mc.items[key]; ok {
+			mc.removeElement(element)
+		}
+		e = &entry{key: key, members: make(map[string]time.Time)}
+		mc.insert(e)
+	}
+
+	e.members[member] = expiresAt
+	e.expiresAt = time.Time{}
+	for _, memberExpiresAt := range e.members {
+		if memberExpiresAt.After(e.expiresAt) {
+			e.expiresAt = memberExpiresAt
+		}
+	}
+	return nil
+}
+
+// Members returns the members of a set that have not expired, ordered by expiry.
+func (mc *MemoryCache) Members(ctx context.Context, key string) ([]string, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	e := mc.liveSet(key, time.Now())
+	if e == nil {
+		return nil, nil
+	}
+
+	members := make([]string, 0, len(e.members))
+	for member := range e.members {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := e.members[members[i]], e.members[members[j]]
+		if a.Equal(b) {
+			return members[i] < members[j]
+		}
+		return a.Before(b)
+	})
+	return members, nil
+}
+
+// RemoveMembers removes members from a set, an empty set is deleted.
+func (mc *MemoryCache) RemoveMembers(ctx context.Context, key string, members ...string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	e := mc.liveSet(key, time.Now())
+	if e == nil {
+		return nil
+	}
+
+	for _, member := range members {
+		delete(e.members, member)
+	}
+	if len(e.members) == 0 {
+		mc.removeElement(mc.items[key])
+	}
+	return nil
+}
+
+// liveSet returns the set of a key without its expired members, nil if the key
+// does not exist, is expired or is not a set. the lock must be held.
+func (mc *MemoryCache) liveSet(key string, now time.Time) *entry {
+	element, ok := mc.items[key]
+	if !ok {
+		return nil
+	}
+
+	e := element.Value.(*entry)
+	if e.expired(now) {
+		mc.removeElement(element)
+		mc.stats.Expirations++
+		return nil
+	}
+	if e.members == nil {
+		return nil
+	}
+
+	for member, expiresAt := range e.members {
+		if now.After(expiresAt) {
+			delete(e.members, member)
+		}
+	}
+	return e
+}
+
+// TakeToken takes a token from a bucket, the bucket
+// is removed once it is full again.
+func (mc *MemoryCache) TakeToken(ctx context.Context, key string, bucket Bucket) (*BucketState, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	now := time.Now()
+	var tokens int64
+	var refilledAt time.Time
+
+	element, ok := mc.items[key]
+	if ok && element.Value.(*entry).expired(now) {
+		mc.removeElement(element)
+		mc.stats.Expirations++
+		ok = false
+	}
+	if ok {
+		tokens, refilledAt = decodeBucket(element.Value.(*entry).value)
+	}
+
+	tokens, refilledAt, state := bucket.take(tokens, refilledAt, now)
+
+	if ok {
+		e := element.Value.(*entry)
+		e.value = encodeBucket(tokens, refilledAt)
+		e.expiresAt = now.Add(state.Reset)
+		mc.touch(element)
+		return state, nil
+	}
+
+	mc.insert(&entry{
+		key:       key,
+		value:     encodeBucket(tokens, refilledAt),
+		expiresAt: now.Add(state.Reset),
+	})
+
+	return state, nil
+}
+
+// Stats returns a snapshot of the cache counters.
+func (mc *MemoryCache) Stats() Stats {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	stats := mc.stats
+	stats.Size = len(mc.items)
+	return stats
+}
+
+// Stop stops the janitor.
+func (mc *MemoryCache) Stop() {
+	mc.once.Do(func() {
+		close(mc.stop)
+	})
+}
+
+// Ping always succeeds for the memory cache.
+func (mc *MemoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close stops the janitor, the cache stays usable.
+func (mc *MemoryCache) Close() error {
+	mc.Stop()
+	return nil
+}
+
+// janitor periodically removes the expired entries.
+func (mc *MemoryCache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mc.removeExpired()
+		case <-mc.stop:
+			return
+		}
+	}
+}
+
+// removeExpired removes all the expired entries.
+func (mc *MemoryCache) removeExpired() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	now := time.Now()
+	for _, element := range mc.items {
+		if element.Value.(*entry).expired(now) {
+			mc.removeElement(element)
+			mc.stats.Expirations++
+		}
+	}
+}
+
+// insert adds a new entry and evicts the least recently used
+// entries if there are too many, the lock must be held.
+func (mc *MemoryCache) insert(e *entry) {
+	if !e.evictable {
+		mc.items[e.key] = mc.pinned.PushFront(e)
+		return
+	}
+
+	mc.items[e.key] = mc.lru.PushFront(e)
+	for mc.maxSize > 0 && mc.lru.Len() > mc.maxSize {
+		mc.removeElement(mc.lru.Back())
+		mc.stats.Evictions++
+	}
+}
+
+// touch marks an entry as the most recently used, the lock must be held.
+func (mc *MemoryCache) touch(element *list.Element) {
+	if element.Value.(*entry).evictable {
+		mc.lru.MoveToFront(element)
+	}
+}
+
+// removeElement removes an element, the lock must be held.
+func (mc *MemoryCache) removeElement(element *list.Element) {
+	e := element.Value.(*entry)
+	if e.evictable {
+		mc.lru.Remove(element)
+	} else {
+		mc.pinned.Remove(element)
+	}
+	delete(mc.items, e.key)
+}
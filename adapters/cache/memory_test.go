@@ -0,0 +1,263 @@
+// +build !integration
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gicicm/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCache_GetSetDel(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(0, 0)
+
+	_, err := mc.Get(ctx, "key")
+	assert.Equal(t, ErrNotFound, err)
+
+	result, err := mc.Set(ctx, "key", "value", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", result)
+
+	val, err := mc.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	assert.NoError(t, mc.Del(ctx, "key"))
+	assert.NoError(t, mc.Del(ctx, "missing"))
+
+	_, err = mc.Get(ctx, "key")
+	assert.Equal(t, ErrNotFound, err)
+
+	stats := mc.Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(2), stats.Misses)
+	assert.Equal(t, 0, stats.Size)
+}
+
+func TestMemoryCache_Metrics(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(0, 0)
+
+	hits := testutil.ToFloat64(metrics.CacheRequests.WithLabelValues("memory", metrics.ResultHit))
+	misses := testutil.ToFloat64(metrics.CacheRequests.WithLabelValues("memory", metrics.ResultMiss))
+
+	_, _ = mc.Set(ctx, "key", "value", 0)
+	_, _ = mc.Get(ctx, "key")
+	_, _ = mc.Get(ctx, "missing")
+	_, _ = mc.Get(ctx, "missing")
+
+	assert.Equal(t, hits+1, testutil.ToFloat64(metrics.CacheRequests.WithLabelValues("memory", metrics.ResultHit)))
+	assert.Equal(t, misses+2, testutil.ToFloat64(metrics.CacheRequests.WithLabelValues("memory", metrics.ResultMiss)))
+}
+
+func TestMemoryCache_Expiry(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(0, 0)
+
+	_, _ = mc.Set(ctx, "short", "value", time.Millisecond)
+	_, _ = mc.Set(ctx, "forever", "value", 0)
+
+	time.Sleep(time.Millisecond * 5)
+
+	_, err := mc.Get(ctx, "short")
+	assert.Equal(t, ErrNotFound, err)
+
+	val, err := mc.Get(ctx, "forever")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	assert.Equal(t, uint64(1), mc.Stats().Expirations)
+}
+
+func TestMemoryCache_Incr(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(0, 0)
+
+	for i := int64(1); i <= 3; i++ {
+		count, err := mc.Incr(ctx, "counter", time.Millisecond*20)
+		assert.NoError(t, err)
+		assert.Equal(t, i, count)
+	}
+
+	val, err := mc.Get(ctx, "counter")
+	assert.NoError(t, err)
+	assert.Equal(t, "3", val)
+
+	// the expiry is not extended by increments, the counter starts over.
+	time.Sleep(time.Millisecond * 25)
+	count, err := mc.Incr(ctx, "counter", time.Millisecond*20)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	_, _ = mc.Set(ctx, "text", "value", 0)
+	_, err = mc.Incr(ctx, "text", 0)
+	assert.Error(t, err)
+}
+
+func TestMemoryCache_Members(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(0, 0)
+	now := time.Now()
+
+	assert.NoError(t, mc.AddMember(ctx, "set", "b", now.Add(time.Hour)))
+	assert.NoError(t, mc.AddMember(ctx, "set", "a", now.Add(time.Hour)))
+	assert.NoError(t, mc.AddMember(ctx, "set", "short", now.Add(time.Millisecond*20)))
+	// adding a member again updates its expiry.
+	assert.NoError(t, mc.AddMember(ctx, "set", "b", now.Add(time.Hour*2)))
+
+	members, err := mc.Members(ctx, "set")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"short", "a", "b"}, members)
+
+	// expired members are dropped.
+	time.Sleep(time.Millisecond * 25)
+	members, err = mc.Members(ctx, "set")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, members)
+
+	assert.NoError(t, mc.RemoveMembers(ctx, "set", "a", "missing"))
+	members, err = mc.Members(ctx, "set")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, members)
+
+	// the set is deleted with its last member.
+	assert.NoError(t, mc.RemoveMembers(ctx, "set", "b"))
+	assert.Equal(t, 0, mc.Stats().Size)
+
+	members, err = mc.Members(ctx, "missing")
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+}
+
+func TestMemoryCache_TakeToken(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(0, 0)
+	bucket := Bucket{Capacity: 2, Interval: time.Millisecond * 20}
+
+	for i := int64(1); i >= 0; i-- {
+		state, err := mc.TakeToken(ctx, "bucket", bucket)
+		assert.NoError(t, err)
+		assert.True(t, state.Allowed)
+		assert.Equal(t, i, state.Remaining)
+	}
+
+	state, err := mc.TakeToken(ctx, "bucket", bucket)
+	assert.NoError(t, err)
+	assert.False(t, state.Allowed)
+	assert.True(t, state.RetryAfter > 0)
+
+	// other keys have their own bucket.
+	state, err = mc.TakeToken(ctx, "other", bucket)
+	assert.NoError(t, err)
+	assert.True(t, state.Allowed)
+
+	// a token is added back after the interval.
+	time.Sleep(time.Millisecond * 25)
+	state, err = mc.TakeToken(ctx, "bucket", bucket)
+	assert.NoError(t, err)
+	assert.True(t, state.Allowed)
+	assert.Equal(t, int64(0), state.Remaining)
+}
+
+func TestMemoryCache_Janitor(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(0, time.Millisecond)
+	defer mc.Stop()
+
+	_, _ = mc.Set(ctx, "short", "value", time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return mc.Stats().Size == 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, uint64(1), mc.Stats().Expirations)
+}
+
+func TestMemoryCache_LRUEviction(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(2, 0)
+
+	_, _ = mc.SetEvictable(ctx, "a", "1", 0)
+	_, _ = mc.SetEvictable(ctx, "b", "2", 0)
+
+	// a becomes the most recently used, so b is evicted.
+	_, _ = mc.Get(ctx, "a")
+	_, _ = mc.SetEvictable(ctx, "c", "3", 0)
+
+	_, err := mc.Get(ctx, "b")
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = mc.Get(ctx, "a")
+	assert.NoError(t, err)
+	_, err = mc.Get(ctx, "c")
+	assert.NoError(t, err)
+
+	stats := mc.Stats()
+	assert.Equal(t, uint64(1), stats.Evictions)
+	assert.Equal(t, 2, stats.Size)
+}
+
+func TestMemoryCache_NoEviction(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(2, 0)
+
+	_, _ = mc.Set(ctx, "jti:revoked", "revoked", time.Minute)
+	_, _ = mc.Incr(ctx, "failures:account:a@test.com", time.Minute)
+	_, _ = mc.TakeToken(ctx, "ratelimit:login:192.0.2.1", Bucket{Capacity: 5, Interval: time.Second})
+
+	// flooding the cache only evicts the evictable entries.
+	for i := 0; i < 10; i++ {
+		_, _ = mc.SetEvictable(ctx, fmt.Sprintf("user:%d", i), "user", 0)
+		_, _ = mc.Incr(ctx, fmt.Sprintf("failures:account:%d", i), time.Minute)
+	}
+
+	val, err := mc.Get(ctx, "jti:revoked")
+	assert.NoError(t, err)
+	assert.Equal(t, "revoked", val)
+	val, err = mc.Get(ctx, "failures:account:a@test.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", val)
+	_, err = mc.Get(ctx, "ratelimit:login:192.0.2.1")
+	assert.NoError(t, err)
+
+	// a key moves between the two kinds when it is set again.
+	_, _ = mc.SetEvictable(ctx, "jti:revoked", "revoked", time.Minute)
+	_, _ = mc.SetEvictable(ctx, "user:a", "user", 0)
+	_, _ = mc.SetEvictable(ctx, "user:b", "user", 0)
+	_, err = mc.Get(ctx, "jti:revoked")
+	assert.Equal(t, ErrNotFound, err)
+
+	stats := mc.Stats()
+	assert.Equal(t, uint64(11), stats.Evictions)
+	assert.Equal(t, 14, stats.Size)
+}
+
+func TestMemoryCache_Concurrency(t *testing.T) {
+	ctx := context.Background()
+	mc := NewMemoryCache(50, time.Millisecond)
+	defer mc.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("%d:%d", i, j)
+				_, _ = mc.SetEvictable(ctx, key, "value", time.Millisecond)
+				_, _ = mc.Get(ctx, key)
+				_ = mc.Del(ctx, key)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, mc.Stats().Size, 50)
+}
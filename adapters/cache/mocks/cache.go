@@ -2,6 +2,8 @@
 
 package mocks
 
+import cache "gicicm/adapters/cache"
+import context "context"
 import mock "github.com/stretchr/testify/mock"
 import time "time"
 
@@ -10,13 +12,13 @@ type Cache struct {
 	mock.Mock
 }
 
-// Del provides a mock function with given fields: key
-func (_m *Cache) Del(key string) error {
-	ret := _m.Called(key)
+// AddMember provides a mock function with given fields: ctx, key, member, expiresAt
+func (_m *Cache) AddMember(ctx context.Context, key string, member string, expiresAt time.Time) error {
+	ret := _m.Called(ctx, key, member, expiresAt)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(key)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time) error); ok {
+		r0 = rf(ctx, key, member, expiresAt)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -24,20 +26,171 @@ func (_m *Cache) Del(key string) error {
 	return r0
 }
 
-// Get provides a mock function with given fields: key
-func (_m *Cache) Get(key string) (string, error) {
-	ret := _m.Called(key)
+// Close provides a mock function with given fields:
+func (_m *Cache) Close() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Del provides a mock function with given fields: ctx, key
+func (_m *Cache) Del(ctx context.Context, key string) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Get provides a mock function with given fields: ctx, key
+func (_m *Cache) Get(ctx context.Context, key string) (string, error) {
+	ret := _m.Called(ctx, key)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Incr provides a mock function with given fields: ctx, key, duration
+func (_m *Cache) Incr(ctx context.Context, key string, duration time.Duration) (int64, error) {
+	ret := _m.Called(ctx, key, duration)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration) int64); ok {
+		r0 = rf(ctx, key, duration)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Duration) error); ok {
+		r1 = rf(ctx, key, duration)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Members provides a mock function with given fields: ctx, key
+func (_m *Cache) Members(ctx context.Context, key string) ([]string, error) {
+	ret := _m.Called(ctx, key)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context, string) []string); ok {
+		r0 = rf(ctx, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// TakeToken provides a mock function with given fields: ctx, key, bucket
+func (_m *Cache) TakeToken(ctx context.Context, key string, bucket cache.Bucket) (*cache.BucketState, error) {
+	ret := _m.Called(ctx, key, bucket)
+
+	var r0 *cache.BucketState
+	if rf, ok := ret.Get(0).(func(context.Context, string, cache.Bucket) *cache.BucketState); ok {
+		r0 = rf(ctx, key, bucket)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*cache.BucketState)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, cache.Bucket) error); ok {
+		r1 = rf(ctx, key, bucket)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Ping provides a mock function with given fields: ctx
+func (_m *Cache) Ping(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RemoveMembers provides a mock function with given fields: ctx, key, members
+func (_m *Cache) RemoveMembers(ctx context.Context, key string, members ...string) error {
+	_va := make([]interface{}, len(members))
+	for _i := range members {
+		_va[_i] = members[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, key)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, ...string) error); ok {
+		r0 = rf(ctx, key, members...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetEvictable provides a mock function with given fields: ctx, key, value, duration
+func (_m *Cache) SetEvictable(ctx context.Context, key string, value string, duration time.Duration) (string, error) {
+	ret := _m.Called(ctx, key, value, duration)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(string) string); ok {
-		r0 = rf(key)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Duration) string); ok {
+		r0 = rf(ctx, key, value, duration)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string) error); ok {
-		r1 = rf(key)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, time.Duration) error); ok {
+		r1 = rf(ctx, key, value, duration)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -45,20 +198,20 @@ func (_m *Cache) Get(key string) (string, error) {
 	return r0, r1
 }
 
-// Set provides a mock function with given fields: key, value, duration
-func (_m *Cache) Set(key string, value string, duration time.Duration) (string, error) {
-	ret := _m.Called(key, value, duration)
+// Set provides a mock function with given fields: ctx, key, value, duration
+func (_m *Cache) Set(ctx context.Context, key string, value string, duration time.Duration) (string, error) {
+	ret := _m.Called(ctx, key, value, duration)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(string, string, time.Duration) string); ok {
-		r0 = rf(key, value, duration)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Duration) string); ok {
+		r0 = rf(ctx, key, value, duration)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string, time.Duration) error); ok {
-		r1 = rf(key, value, duration)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, time.Duration) error); ok {
+		r1 = rf(ctx, key, value, duration)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -1,13 +1,16 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"gicicm/config"
 	"gicicm/logger"
 
-	_ "github.com/lib/pq" //dialect to be used
+	_ "github.com/lib/pq"           //dialect to be used
+	_ "github.com/mattn/go-sqlite3" //dialect to be used
 	"go.uber.org/zap"
 )
 
@@ -17,14 +20,62 @@ type Database interface{}
 // NewDatabaseAdapter - returns a new instance of the database adapter.
 func NewDatabaseAdapter(c *config.Config) *sql.DB {
 
+	var dbconn *sql.DB
+
+	switch c.Database.DBType {
+	case "postgres":
+		dbconn = newPostgresAdapter(c)
+	case "sqlite3":
+		dbconn = newSQLiteAdapter(c)
+	default:
+		logger.Log().Fatal("Unknown database type", zap.String("type", c.Database.DBType))
+		return nil
+	}
+
+	// sql.Open does not connect, ping to surface problems early.
+	// the database may still be starting so this is not fatal,
+	// the readiness probe reports it until it is reachable.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err := dbconn.PingContext(ctx)
+	if err != nil {
+		logger.Log().Error("Unable to ping database", zap.String("type", c.Database.DBType), zap.Error(err))
+	}
+
+	return dbconn
+}
+
+// newPostgresAdapter returns a new postgres connection pool.
+func newPostgresAdapter(c *config.Config) *sql.DB {
+
 	connectionString := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		c.Database.Host, c.Database.Port, c.Database.User, c.Database.Pass, c.Database.DBName)
 
 	dbconn, err := sql.Open(c.Database.DBType, connectionString)
 
 	if err != nil {
-		logger.Log().Fatal("Unable to connect to database", zap.String("connectionString", connectionString), zap.Error(err))
+		logger.Log().Fatal("Unable to connect to database", zap.String("host", c.Database.Host), zap.String("database", c.Database.DBName), zap.Error(err))
 	}
 
 	return dbconn
 }
+
+// newSQLiteAdapter returns a new sqlite connection to the file DB_NAME.
+func newSQLiteAdapter(c *config.Config) *sql.DB {
+
+	// foreign keys are off by default in sqlite.
+	connectionString := fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000", c.Database.DBName)
+
+	dbconn, err := sql.Open(c.Database.DBType, connectionString)
+
+	if err != nil {
+		logger.Log().Fatal("Unable to connect to database", zap.String("database", c.Database.DBName), zap.Error(err))
+	}
+
+	// sqlite allows a single writer, sharing one connection
+	// avoids busy errors and keeps in memory databases alive.
+	dbconn.SetMaxOpenConns(1)
+
+	return dbconn
+}
@@ -0,0 +1,88 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"gicicm/config"
+	"gicicm/logger"
+
+	"go.uber.org/zap"
+)
+
+// Notifier delivers messages to users.
+type Notifier interface {
+	SendPasswordReset(ctx context.Context, email, token string) error
+}
+
+// NewNotifier returns the notifier selected by the config. There is no
+// notifier that writes to the log as the logger masks the tokens.
+func NewNotifier(config *config.Config) Notifier {
+	switch config.Notifier.Driver {
+	case "file":
+		return NewFileNotifier(config.Notifier.FilePath)
+	default:
+		logger.Log().Fatal("unknown notifier driver", zap.String("driver", config.Notifier.Driver))
+		return nil
+	}
+}
+
+// fileNotifier appends notifications as json lines to a file.
+type fileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+// notification is a single line written by the file notifier.
+type notification struct {
+	Type      string    `json:"type"`
+	Email     string    `json:"email"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewFileNotifier returns a notifier that appends to the file at path.
+func NewFileNotifier(path string) Notifier {
+	return &fileNotifier{
+		path: path,
+	}
+}
+
+// SendPasswordReset appends the password reset token to the file.
+func (fn *fileNotifier) SendPasswordReset(ctx context.Context, email, token string) error {
+	return fn.write(ctx, &notification{
+		Type:      "password_reset",
+		Email:     email,
+		Token:     token,
+		CreatedAt: time.Now(),
+	})
+}
+
+// write appends a notification to the file.
+func (fn *fileNotifier) write(ctx context.Context, n *notification) error {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+
+	bytes, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(fn.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while opening notification file", zap.String("path", fn.path), zap.Error(err))
+		return err
+	}
+
+	_, err = file.Write(append(bytes, '\n'))
+	if err != nil {
+		logger.FromContext(ctx).Error("error while writing notification", zap.String("path", fn.path), zap.Error(err))
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
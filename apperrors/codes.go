@@ -0,0 +1,57 @@
+package apperrors
+
+import "gicicm/common"
+
+// Domain errors, the codes are part of the API and must not change.
+var (
+	ErrInternal = New(Internal, "internal_error", common.InternalServerError)
+
+	ErrBadRequest  = New(Validation, "bad_request", common.BadRequestError)
+	ErrValidation  = New(Validation, "validation_failed", "request validation failed")
+	ErrInvalidRole = New(Validation, "invalid_role", common.InvalidRoleError)
+	// ErrInvalidCursor is returned for a malformed cursor or one issued for another sort.
+	ErrInvalidCursor     = New(Validation, "invalid_cursor", common.InvalidCursorError)
+	ErrInvalidResetToken = New(Validation, "invalid_reset_token", common.InvalidResetTokenError)
+	ErrMFANotEnrolled    = New(Validation, "mfa_not_enrolled", common.MFANotEnrolledError)
+	ErrInvalidOIDCState  = New(Validation, "invalid_oidc_state", common.InvalidOIDCStateError)
+
+	ErrRouteNotFound        = New(NotFound, "route_not_found", "route not found")
+	ErrAccountNotFound      = New(NotFound, "account_not_found", common.AccountNotFoundError)
+	ErrSessionNotFound      = New(NotFound, "session_not_found", common.SessionNotFoundError)
+	ErrAPIKeyNotFound       = New(NotFound, "api_key_not_found", common.APIKeyNotFoundError)
+	ErrProviderNotFound     = New(NotFound, "identity_provider_not_found", common.IdentityProviderNotFoundError)
+	ErrClientNotFound       = New(NotFound, "client_not_found", common.ClientNotFoundError)
+	ErrAccountAlreadyExists = New(Conflict, "account_already_exists", common.AccountAlreadyExistsError)
+	ErrMFAAlreadyEnabled    = New(Conflict, "mfa_already_enabled", common.MFAAlreadyEnabledError)
+	// ErrIdentityAlreadyLinked is returned when an identity is linked to another user.
+	ErrIdentityAlreadyLinked = New(Conflict, "identity_already_linked", common.IdentityAlreadyLinkedError)
+
+	ErrInvalidCredentials  = New(Unauthorized, "invalid_credentials", common.InvalidCredentialsError)
+	ErrInvalidToken        = New(Unauthorized, "invalid_token", common.InvalidTokenError)
+	ErrInvalidRefreshToken = New(Unauthorized, "invalid_refresh_token", common.InvalidRefreshTokenError)
+	ErrInvalidMFAToken     = New(Unauthorized, "invalid_mfa_token", common.InvalidMFATokenError)
+	ErrInvalidMFACode      = New(Unauthorized, "invalid_mfa_code", common.InvalidMFACodeError)
+	ErrInvalidAPIKey       = New(Unauthorized, "invalid_api_key", common.InvalidAPIKeyError)
+	ErrOIDCLoginFailed     = New(Unauthorized, "oidc_login_failed", common.OIDCLoginFailedError)
+
+	ErrPermissionDenied    = New(Forbidden, "permission_denied", common.UnAuthorizedError)
+	ErrAccessTokenRequired = New(Forbidden, "access_token_required", common.AccessTokenRequiredError)
+	// ErrIdentityNotLinked is returned for an unknown identity that can not be provisioned.
+	ErrIdentityNotLinked = New(Forbidden, "identity_not_linked", common.IdentityNotLinkedError)
+
+	ErrAccountLocked        = New(Locked, "account_locked", common.AccountLockedError)
+	ErrTooManyLoginAttempts = New(RateLimited, "too_many_login_attempts", common.TooManyLoginAttemptsError)
+	ErrRateLimited          = New(RateLimited, "rate_limited", common.RateLimitedError)
+)
+
+// OAuth errors, the codes are the error codes of RFC 6749.
+var (
+	ErrInvalidOAuthRequest     = New(Validation, "invalid_request", common.InvalidOAuthRequestError)
+	ErrInvalidGrant            = New(Validation, "invalid_grant", common.InvalidGrantError)
+	ErrUnauthorizedClient      = New(Validation, "unauthorized_client", common.UnauthorizedClientError)
+	ErrUnsupportedGrantType    = New(Validation, "unsupported_grant_type", common.UnsupportedGrantTypeError)
+	ErrInvalidScope            = New(Validation, "invalid_scope", common.InvalidScopeError)
+	ErrUnsupportedResponseType = New(Validation, "unsupported_response_type", common.UnsupportedResponseTypeError)
+	ErrInvalidClient           = New(Unauthorized, "invalid_client", common.InvalidClientError)
+	ErrAccessDenied            = New(Forbidden, "access_denied", common.AccessDeniedError)
+)
@@ -0,0 +1,119 @@
+package apperrors
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Kinds classify errors, the HTTP layer maps each kind to a status.
+// use errors.Is(err, apperrors.NotFound) to check the kind of an error.
+var (
+	NotFound     = errors.New("not found")
+	Conflict     = errors.New("conflict")
+	Unauthorized = errors.New("unauthorized")
+	Forbidden    = errors.New("forbidden")
+	Validation   = errors.New("validation failed")
+	Locked       = errors.New("locked")
+	RateLimited  = errors.New("rate limited")
+	Internal     = errors.New("internal error")
+)
+
+// FieldError describes why a single field of a request is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a domain error with a stable machine readable code
+// and a message that is safe to return to clients.
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+	Fields  []FieldError
+	// RetryAfter tells clients when to try again, for Locked and RateLimited errors.
+	RetryAfter time.Duration
+	// Err is the underlying cause, it is logged but never returned to clients.
+	Err error
+}
+
+// New returns a new error of the given kind.
+func New(kind error, code, message string) *Error {
+	return &Error{
+		Kind:    kind,
+		Code:    code,
+		Message: message,
+	}
+}
+
+// Error returns the message followed by the cause, if any.
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err.Error())
+	}
+	return e.Message
+}
+
+// Unwrap returns the cause.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether e is of the target kind or has the target's code,
+// so both errors.Is(err, apperrors.NotFound) and
+// errors.Is(err, apperrors.ErrAccountNotFound) hold for a wrapped copy.
+func (e *Error) Is(target error) bool {
+	if target == e.Kind {
+		return true
+	}
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns a copy of e with the cause set.
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+// WithFields returns a copy of e with the field details set.
+func (e *Error) WithFields(fields ...FieldError) *Error {
+	wrapped := *e
+	wrapped.Fields = fields
+	return &wrapped
+}
+
+// WithRetryAfter returns a copy of e telling clients when to try again.
+func (e *Error) WithRetryAfter(retryAfter time.Duration) *Error {
+	wrapped := *e
+	wrapped.RetryAfter = retryAfter
+	return &wrapped
+}
+
+// WithMessage returns a copy of e with a more specific message.
+func (e *Error) WithMessage(message string) *Error {
+	wrapped := *e
+	wrapped.Message = message
+	return &wrapped
+}
+
+// NewValidation returns a validation error with details
+// for every invalid field, or nil when there are none.
+func NewValidation(fields ...FieldError) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return ErrValidation.WithFields(fields...)
+}
+
+// As returns the *Error in the chain of err, errors that are
+// not domain errors are reported as internal errors wrapping err.
+func As(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return ErrInternal.Wrap(err)
+}
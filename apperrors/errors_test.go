@@ -0,0 +1,56 @@
+// +build !integration
+
+package apperrors
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestError_Is(t *testing.T) {
+	err := fmt.Errorf("fetching user: %w", ErrAccountNotFound.Wrap(sql.ErrNoRows))
+
+	// by code, by kind and by cause.
+	assert.True(t, errors.Is(err, ErrAccountNotFound))
+	assert.True(t, errors.Is(err, NotFound))
+	assert.True(t, errors.Is(err, sql.ErrNoRows))
+
+	assert.False(t, errors.Is(err, ErrAccountAlreadyExists))
+	assert.False(t, errors.Is(err, Conflict))
+
+	assert.Equal(t, "account does not exist: sql: no rows in result set", ErrAccountNotFound.Wrap(sql.ErrNoRows).Error())
+	assert.Equal(t, "account does not exist", ErrAccountNotFound.Error())
+}
+
+func TestError_As(t *testing.T) {
+	err := fmt.Errorf("creating user: %w", ErrAccountAlreadyExists.Wrap(errors.New("duplicate key")))
+
+	var appErr *Error
+	assert.True(t, errors.As(err, &appErr))
+	assert.Equal(t, "account_already_exists", appErr.Code)
+	assert.Equal(t, Conflict, appErr.Kind)
+
+	assert.Equal(t, "account_already_exists", As(err).Code)
+
+	// unknown errors are internal errors and keep the cause.
+	cause := errors.New(`pq: relation "users" does not exist`)
+	internal := As(cause)
+	assert.Equal(t, "internal_error", internal.Code)
+	assert.Equal(t, "internal server error", internal.Message)
+	assert.True(t, errors.Is(internal, cause))
+}
+
+func TestNewValidation(t *testing.T) {
+	assert.NoError(t, NewValidation())
+
+	err := NewValidation(FieldError{Field: "email", Message: "invalid email"})
+	assert.True(t, errors.Is(err, Validation))
+	assert.Equal(t, []FieldError{{Field: "email", Message: "invalid email"}}, As(err).Fields)
+
+	// the shared sentinel is not modified.
+	assert.Empty(t, ErrValidation.Fields)
+}
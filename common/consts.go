@@ -2,12 +2,46 @@ package common
 
 // common errors
 const (
-	AccountAlreadyExistsError = "account already exists"
-	InternalServerError       = "internal server error"
-	BadRequestError           = "invalid input format"
-	PasswordValidationError   = "invalid password, should have more than 8 characters, atleast 1 symbol, 1 uppercase character and a number"
-	EmailValidationError      = "invalid email"
-	AccountNotFoundError      = "account does not exist"
-	InvalidCredentialsError   = "invalid credentials"
-	UnAuthorizedError         = "not permitted to perform this operation"
+	AccountAlreadyExistsError     = "account already exists"
+	InternalServerError           = "internal server error"
+	BadRequestError               = "invalid input format"
+	PasswordValidationError       = "invalid password, should have more than 8 characters, atleast 1 symbol, 1 uppercase character and a number"
+	EmailValidationError          = "invalid email"
+	AccountNotFoundError          = "account does not exist"
+	InvalidCredentialsError       = "invalid credentials"
+	UnAuthorizedError             = "not permitted to perform this operation"
+	InvalidRefreshTokenError      = "invalid refresh token"
+	InvalidRoleError              = "invalid role"
+	InvalidResetTokenError        = "invalid or expired reset token"
+	InvalidCursorError            = "invalid cursor"
+	InvalidTokenError             = "invalid auth token"
+	AccountLockedError            = "account is temporarily locked after too many failed logins"
+	TooManyLoginAttemptsError     = "too many failed logins, try again later"
+	RateLimitedError              = "too many requests, try again later"
+	MFANotEnrolledError           = "mfa enrolment has not been started"
+	MFAAlreadyEnabledError        = "mfa is already enabled"
+	InvalidMFATokenError          = "invalid or expired mfa token"
+	InvalidMFACodeError           = "invalid mfa code"
+	SessionNotFoundError          = "session does not exist"
+	APIKeyNotFoundError           = "api key does not exist"
+	InvalidAPIKeyError            = "invalid or expired api key"
+	AccessTokenRequiredError      = "this operation requires the access token of a login, api keys and tokens of OAuth clients are not accepted"
+	IdentityProviderNotFoundError = "identity provider does not exist"
+	InvalidOIDCStateError         = "invalid or expired login state"
+	OIDCLoginFailedError          = "login with the identity provider failed"
+	IdentityNotLinkedError        = "the identity is not linked to an account, link it from your account first"
+	IdentityAlreadyLinkedError    = "the identity is already linked to another account"
+	ClientNotFoundError           = "client does not exist"
+)
+
+// OAuth errors, returned as the error_description of RFC 6749 error responses.
+const (
+	InvalidOAuthRequestError     = "the request is missing a required parameter or is malformed"
+	InvalidClientError           = "client authentication failed"
+	InvalidGrantError            = "the authorization grant is invalid, expired, revoked or was issued to another client"
+	UnauthorizedClientError      = "the client is not allowed to use this grant type"
+	UnsupportedGrantTypeError    = "unsupported grant type"
+	InvalidScopeError            = "the requested scope is invalid or exceeds the scope granted"
+	AccessDeniedError            = "the user denied the request"
+	UnsupportedResponseTypeError = "unsupported response type"
 )
@@ -1,8 +1,13 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"gicicm/logger"
 )
@@ -13,42 +18,192 @@ type DbConfig struct {
 	Port   string // DB_PORT
 	User   string // DB_USER
 	Pass   string // DB_PASS
-	DBName string // DB_NAME
-	DBType string // DB_TYPE
+	DBName string // DB_NAME, the file name for sqlite
+	DBType string // DB_TYPE, postgres or sqlite3
+
+	MigrateOnStart bool // MIGRATE_ON_START
 }
 
 // CacheConfig contains the cache configuration details.
 type CacheConfig struct {
-	Host string // CACHE_HOST
+	Driver  string // CACHE_DRIVER, redis or memory
+	Host    string // CACHE_HOST, required for redis
+	MaxSize int    // CACHE_MAX_SIZE, for memory
+}
+
+// ServerConfig contains the http server configuration details.
+type ServerConfig struct {
+	Addr string // SERVER_ADDR
+	// ShutdownDelay is how long the server keeps serving
+	// after turning not ready, so that load balancers can react.
+	ShutdownDelay time.Duration // SHUTDOWN_DELAY
+	// DrainTimeout is how long in flight requests get to finish.
+	DrainTimeout time.Duration // SHUTDOWN_TIMEOUT
+	// TrustedProxies are the load balancers allowed to set X-Forwarded-For,
+	// the header is ignored on connections from any other address.
+	TrustedProxies []*net.IPNet // TRUSTED_PROXIES, comma separated ips or cidrs
+}
+
+// AuthConfig contains the token configuration details.
+type AuthConfig struct {
+	AccessTokenTTL   time.Duration // ACCESS_TOKEN_TTL
+	RefreshTokenTTL  time.Duration // REFRESH_TOKEN_TTL
+	PasswordResetTTL time.Duration // PASSWORD_RESET_TTL
+	MFAChallengeTTL  time.Duration // MFA_CHALLENGE_TTL
+	// MFAIssuer is the account issuer shown by authenticator apps.
+	MFAIssuer string // MFA_ISSUER
+	// MFASecretKey encrypts the TOTP secrets in the database, they are stored in clear without it.
+	MFASecretKey []byte // MFA_SECRET_KEY, a base64 encoded 32 byte key
+	// Issuer and Audience are set in the iss and aud claims of the
+	// access tokens and tokens with other values are rejected.
+	Issuer    string        // TOKEN_ISSUER
+	Audience  string        // TOKEN_AUDIENCE
+	ClockSkew time.Duration // TOKEN_CLOCK_SKEW, tolerated when checking exp, nbf and iat
+	// SessionTouchInterval throttles the last_seen updates of a session.
+	SessionTouchInterval time.Duration // SESSION_TOUCH_INTERVAL
+	// APIKeyTouchInterval throttles the last_used_at updates of an api key.
+	APIKeyTouchInterval time.Duration // API_KEY_TOUCH_INTERVAL
+	// OIDCStateTTL is how long a user has to complete a login with an OpenID provider.
+	OIDCStateTTL time.Duration // OIDC_STATE_TTL
+	// AuthorizationCodeTTL is how long an OAuth client has to exchange an authorization code.
+	AuthorizationCodeTTL time.Duration // AUTHORIZATION_CODE_TTL
+	// AdminEmails are granted the admin role at startup, granting roles needs an admin.
+	AdminEmails []string // ADMIN_EMAILS, comma separated
+}
+
+// LockoutConfig contains the brute force protection details for login.
+type LockoutConfig struct {
+	MaxFailures   int           // LOGIN_MAX_FAILURES, per account
+	MaxIPFailures int           // LOGIN_MAX_IP_FAILURES, per client ip
+	FailureWindow time.Duration // LOGIN_FAILURE_WINDOW
+	// Duration is doubled for every consecutive lockout of an account up to MaxDuration.
+	Duration    time.Duration // LOGIN_LOCKOUT_DURATION
+	MaxDuration time.Duration // LOGIN_LOCKOUT_MAX_DURATION
+}
+
+// RateLimit allows Requests per Period with bursts of up to Requests.
+// zero Requests disables the limit.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// RateLimitConfig contains the rate limit policies, each is
+// set as requests/period, e.g. RATE_LIMIT_LOGIN=10/1m.
+type RateLimitConfig struct {
+	Signup  RateLimit // RATE_LIMIT_SIGNUP, per client ip
+	Login   RateLimit // RATE_LIMIT_LOGIN, per client ip
+	Auth    RateLimit // RATE_LIMIT_AUTH, the other unauthenticated auth endpoints per client ip
+	Default RateLimit // RATE_LIMIT_DEFAULT, the authenticated endpoints per user
+}
+
+// NotifierConfig contains the notifier configuration details.
+type NotifierConfig struct {
+	Driver   string // NOTIFIER_DRIVER, file
+	FilePath string // NOTIFIER_FILE
 }
 
 // Config contains configuration details for gicicm to start
 type Config struct {
-	Database   DbConfig
-	Cache      CacheConfig
-	SigningKey string
+	Server    ServerConfig
+	Database  DbConfig
+	Cache     CacheConfig
+	Auth      AuthConfig
+	Lockout   LockoutConfig
+	RateLimit RateLimitConfig
+	Notifier  NotifierConfig
+	// SigningKey is a shared HS256 secret, used when there is no SigningKeysFile.
+	SigningKey      string // SIGNING_KEY
+	SigningKeysFile string // SIGNING_KEYS_FILE
+	// OIDCProvidersFile lists the OpenID providers users can log in with.
+	OIDCProvidersFile string // OIDC_PROVIDERS_FILE
 }
 
 // GetConfig returns an instance of config
 func GetConfig() *Config {
 
+	serverConf := ServerConfig{
+		Addr:          getEnv("SERVER_ADDR", "0.0.0.0:8000"),
+		ShutdownDelay: getDurationEnv("SHUTDOWN_DELAY", 0),
+		DrainTimeout:  getDurationEnv("SHUTDOWN_TIMEOUT", time.Second*15),
+
+		TrustedProxies: getNetworksEnv("TRUSTED_PROXIES"),
+	}
+
 	dbConf := DbConfig{
-		Host:   mustGetEnv("DB_HOST"),
-		Port:   mustGetEnv("DB_PORT"),
-		User:   mustGetEnv("DB_USER"),
-		Pass:   mustGetEnv("DB_PASS"),
 		DBName: mustGetEnv("DB_NAME"),
 		DBType: mustGetEnv("DB_TYPE"),
+
+		MigrateOnStart: getBoolEnv("MIGRATE_ON_START", false),
+	}
+
+	// sqlite only needs the file name in DB_NAME.
+	if dbConf.DBType != "sqlite3" {
+		dbConf.Host = mustGetEnv("DB_HOST")
+		dbConf.Port = mustGetEnv("DB_PORT")
+		dbConf.User = mustGetEnv("DB_USER")
+		dbConf.Pass = mustGetEnv("DB_PASS")
 	}
 
 	cacheConf := CacheConfig{
-		Host: mustGetEnv("CACHE_HOST"),
+		Driver:  getEnv("CACHE_DRIVER", "redis"),
+		MaxSize: getIntEnv("CACHE_MAX_SIZE", 100000),
+	}
+
+	if cacheConf.Driver == "redis" {
+		cacheConf.Host = mustGetEnv("CACHE_HOST")
+	}
+
+	authConf := AuthConfig{
+		AccessTokenTTL:   getDurationEnv("ACCESS_TOKEN_TTL", time.Minute*15),
+		RefreshTokenTTL:  getDurationEnv("REFRESH_TOKEN_TTL", time.Hour*24*30),
+		PasswordResetTTL: getDurationEnv("PASSWORD_RESET_TTL", time.Minute*30),
+		MFAChallengeTTL:  getDurationEnv("MFA_CHALLENGE_TTL", time.Minute*5),
+		MFAIssuer:        getEnv("MFA_ISSUER", "gicicm"),
+		MFASecretKey:     getKeyEnv("MFA_SECRET_KEY", 32),
+		Issuer:           getEnv("TOKEN_ISSUER", "icm"),
+		Audience:         getEnv("TOKEN_AUDIENCE", "gicicm"),
+		ClockSkew:        getDurationEnv("TOKEN_CLOCK_SKEW", time.Second*30),
+
+		SessionTouchInterval: getDurationEnv("SESSION_TOUCH_INTERVAL", time.Minute),
+		APIKeyTouchInterval:  getDurationEnv("API_KEY_TOUCH_INTERVAL", time.Minute),
+		OIDCStateTTL:         getDurationEnv("OIDC_STATE_TTL", time.Minute*10),
+		AuthorizationCodeTTL: getDurationEnv("AUTHORIZATION_CODE_TTL", time.Minute),
+		AdminEmails:          getListEnv("ADMIN_EMAILS"),
+	}
+
+	lockoutConf := LockoutConfig{
+		MaxFailures:   getIntEnv("LOGIN_MAX_FAILURES", 5),
+		MaxIPFailures: getIntEnv("LOGIN_MAX_IP_FAILURES", 20),
+		FailureWindow: getDurationEnv("LOGIN_FAILURE_WINDOW", time.Minute*15),
+		Duration:      getDurationEnv("LOGIN_LOCKOUT_DURATION", time.Minute),
+		MaxDuration:   getDurationEnv("LOGIN_LOCKOUT_MAX_DURATION", time.Hour),
+	}
+
+	rateLimitConf := RateLimitConfig{
+		Signup:  getRateLimitEnv("RATE_LIMIT_SIGNUP", RateLimit{Requests: 5, Period: time.Hour}),
+		Login:   getRateLimitEnv("RATE_LIMIT_LOGIN", RateLimit{Requests: 10, Period: time.Minute}),
+		Auth:    getRateLimitEnv("RATE_LIMIT_AUTH", RateLimit{Requests: 20, Period: time.Minute}),
+		Default: getRateLimitEnv("RATE_LIMIT_DEFAULT", RateLimit{Requests: 120, Period: time.Minute}),
+	}
+
+	notifierConf := NotifierConfig{
+		Driver:   getEnv("NOTIFIER_DRIVER", "file"),
+		FilePath: getEnv("NOTIFIER_FILE", "notifications.log"),
 	}
 
 	return &Config{
-		Database:   dbConf,
-		Cache:      cacheConf,
-		SigningKey: mustGetEnv("SIGNING_KEY"),
+		Server:          serverConf,
+		Database:        dbConf,
+		Cache:           cacheConf,
+		Auth:            authConf,
+		Lockout:         lockoutConf,
+		RateLimit:       rateLimitConf,
+		Notifier:        notifierConf,
+		SigningKey:      getEnv("SIGNING_KEY", ""),
+		SigningKeysFile: getEnv("SIGNING_KEYS_FILE", ""),
+
+		OIDCProvidersFile: getEnv("OIDC_PROVIDERS_FILE", ""),
 	}
 }
 
@@ -61,3 +216,157 @@ func mustGetEnv(env string) string {
 	}
 	return value
 }
+
+// getEnv returns the value of an env variable
+// returns the fallback if not set.
+func getEnv(env string, fallback string) string {
+	value := os.Getenv(env)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// getIntEnv returns the value of an env variable parsed as an int
+// returns the fallback if not set, panics if set but invalid.
+func getIntEnv(env string, fallback int) int {
+	value := os.Getenv(env)
+	if value == "" {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Log().Panic(fmt.Sprintf("The environment variable %s is not a valid int.", env))
+	}
+	return i
+}
+
+// getBoolEnv returns the value of an env variable parsed as a bool
+// returns the fallback if not set, panics if set but invalid.
+func getBoolEnv(env string, fallback bool) bool {
+	value := os.Getenv(env)
+	if value == "" {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Log().Panic(fmt.Sprintf("The environment variable %s is not a valid bool.", env))
+	}
+	return b
+}
+
+// getDurationEnv returns the value of an env variable parsed as a duration
+// returns the fallback if not set, panics if set but invalid.
+func getDurationEnv(env string, fallback time.Duration) time.Duration {
+	value := os.Getenv(env)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Log().Panic(fmt.Sprintf("The environment variable %s is not a valid duration.", env))
+	}
+	return duration
+}
+
+// getRateLimitEnv returns the value of an env variable parsed as requests/period
+// returns the fallback if not set, panics if set but invalid.
+func getRateLimitEnv(env string, fallback RateLimit) RateLimit {
+	value := os.Getenv(env)
+	if value == "" {
+		return fallback
+	}
+
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		logger.Log().Panic(fmt.Sprintf("The environment variable %s is not a valid rate limit.", env))
+	}
+
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests < 0 {
+		logger.Log().Panic(fmt.Sprintf("The environment variable %s is not a valid rate limit.", env))
+	}
+
+	period, err := time.ParseDuration(parts[1])
+	if err != nil || period <= 0 {
+		logger.Log().Panic(fmt.Sprintf("The environment variable %s is not a valid rate limit.", env))
+	}
+
+	// a token is refilled every period/requests, the buckets in redis
+	// count in milliseconds so it must be at least one.
+	if requests > 0 && period/time.Duration(requests) < time.Millisecond {
+		logger.Log().Panic(fmt.Sprintf("The environment variable %s is not a valid rate limit.", env))
+	}
+
+	return RateLimit{Requests: requests, Period: period}
+}
+
+// getKeyEnv returns the value of an env variable decoded from base64
+// returns nil if not set, panics if set but not a key of the given size.
+func getKeyEnv(env string, size int) []byte {
+	value := os.Getenv(env)
+	if value == "" {
+		return nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != size {
+		logger.Log().Panic(fmt.Sprintf("The environment variable %s is not a valid %d byte key.", env, size))
+	}
+	return key
+}
+
+// getListEnv returns the value of an env variable split on commas
+// returns nil if not set, empty items are skipped.
+func getListEnv(env string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(env), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getNetworksEnv returns the value of an env variable parsed as comma separated ips or cidrs
+// returns nil if not set, panics if set but invalid.
+func getNetworksEnv(env string) []*net.IPNet {
+	value := os.Getenv(env)
+	if value == "" {
+		return nil
+	}
+
+	var networks []*net.IPNet
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		// a single ip is a network of one address.
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				logger.Log().Panic(fmt.Sprintf("The environment variable %s is not a valid list of networks.", env))
+			}
+			bits := net.IPv6len * 8
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = net.IPv4len * 8
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(part)
+		if err != nil {
+			logger.Log().Panic(fmt.Sprintf("The environment variable %s is not a valid list of networks.", env))
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
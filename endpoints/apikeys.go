@@ -0,0 +1,127 @@
+package endpoints
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// messages for invalid api key requests.
+const (
+	apiKeyNameValidationError      = "name must not be empty or longer than 64 characters"
+	apiKeyScopesValidationError    = "at least one scope is required"
+	apiKeyScopeValidationError     = "unknown scope "
+	apiKeyScopeNotGrantedError     = "scope is not granted to the user "
+	apiKeyExpiresAtValidationError = "must be in the future"
+)
+
+// CreateAPIKey is an endpoint that creates an api key for the current user,
+// a key can only be scoped to permissions the user has.
+func (ctrl *Controller) CreateAPIKey(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	request := new(models.CreateAPIKeyRequest)
+	err = c.ShouldBindJSON(request)
+	if err != nil {
+		abort(c, apperrors.ErrBadRequest.Wrap(err))
+		return
+	}
+
+	// input validation.
+	var fields []apperrors.FieldError
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > 64 {
+		fields = append(fields, apperrors.FieldError{Field: "name", Message: apiKeyNameValidationError})
+	}
+
+	if len(request.Scopes) == 0 {
+		fields = append(fields, apperrors.FieldError{Field: "scopes", Message: apiKeyScopesValidationError})
+	}
+	// duplicate scopes are dropped.
+	var scopes []string
+	seen := make(map[string]bool, len(request.Scopes))
+	for _, scope := range request.Scopes {
+		switch {
+		case !isPermissionValid(scope):
+			fields = append(fields, apperrors.FieldError{Field: "scopes", Message: apiKeyScopeValidationError + scope})
+		case !hasPermission(metadata.Roles, scope):
+			fields = append(fields, apperrors.FieldError{Field: "scopes", Message: apiKeyScopeNotGrantedError + scope})
+		case !seen[scope]:
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	request.Scopes = scopes
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		fields = append(fields, apperrors.FieldError{Field: "expires_at", Message: apiKeyExpiresAtValidationError})
+	}
+
+	err = apperrors.NewValidation(fields...)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	key, err := ctrl.authProvider.CreateAPIKey(ctx, metadata.Email, request)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+// ListAPIKeys is an endpoint that lists the api keys of the current user.
+func (ctrl *Controller) ListAPIKeys(c *gin.Context) {
+	ctx := c.Request.Context()
+	response := make(map[string]interface{})
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	keys, err := ctrl.authProvider.ListAPIKeys(ctx, metadata.Email)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["api_keys"] = keys
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeAPIKey is an endpoint that revokes an api key of the current user.
+func (ctrl *Controller) RevokeAPIKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	response := make(map[string]interface{})
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	err = ctrl.authProvider.RevokeAPIKey(ctx, metadata.Email, c.Param("prefix"))
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["result"] = "Successfully Revoked"
+	c.JSON(http.StatusOK, response)
+}
@@ -1,6 +1,8 @@
 package endpoints
 
 import (
+	"errors"
+	"gicicm/apperrors"
 	"gicicm/common"
 	"net/http"
 	"strings"
@@ -17,98 +19,235 @@ import (
 func (ctrl *Controller) Login(c *gin.Context) {
 	ctx := c.Request.Context()
 
-	response := make(map[string]interface{})
 	request := new(models.LoginRequest)
 
-	err := c.BindJSON(request)
+	err := c.ShouldBindJSON(request)
 	if err != nil {
-		logger.Log().Error("error while binding request body to user", zap.Error(err))
-		response["error"] = common.BadRequestError
-		c.JSON(http.StatusBadRequest, response)
-		c.Abort()
+		abort(c, apperrors.ErrBadRequest.Wrap(err))
 		return
 	}
 
-	token, err := ctrl.authProvider.Login(ctx, request)
+	ctx = logger.With(ctx, zap.String("email", request.Email))
+	c.Request = c.Request.WithContext(ctx)
+	request.ClientIP = clientIP(c)
+	request.UserAgent = c.Request.UserAgent()
+
+	tokens, err := ctrl.authProvider.Login(ctx, request)
 	if err != nil {
-		if err.Error() == common.InvalidCredentialsError {
-			logger.Log().Info("Invalid credentials", zap.Any("request", request), zap.Error(err))
-			response["error"] = common.InvalidCredentialsError
-			c.JSON(http.StatusUnauthorized, response)
-			c.Abort()
-			return
-		}
-		response["error"] = common.InternalServerError
-		c.JSON(http.StatusInternalServerError, response)
-		c.Abort()
+		abort(c, err)
 		return
 	}
 
-	c.JSON(200, token)
+	c.JSON(http.StatusOK, tokens)
 }
 
-// Verify is an endpoint that verifies a user for further operations.
+// Refresh is an endpoint that exchanges a refresh token
+// for a new access token and a rotated refresh token.
+func (ctrl *Controller) Refresh(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	request := new(models.RefreshRequest)
+
+	err := c.ShouldBindJSON(request)
+	if err != nil {
+		abort(c, apperrors.ErrBadRequest.Wrap(err))
+		return
+	}
+
+	if request.RefreshToken == "" {
+		abort(c, apperrors.NewValidation(apperrors.FieldError{Field: "refresh_token", Message: "is required"}))
+		return
+	}
+
+	tokens, err := ctrl.authProvider.Refresh(ctx, request.RefreshToken)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// apiKeyScheme is the authorization scheme of api keys.
+const apiKeyScheme = "ApiKey "
+
+// Verify is an endpoint that verifies a user for further operations,
+// it accepts a bearer access token or an api key.
 func (ctrl *Controller) Verify(c *gin.Context) {
 
 	ctx := c.Request.Context()
 
-	response := make(map[string]interface{})
-
 	// fetch auth token from headers
 	authToken := c.Request.Header.Get("Authorization")
 	if authToken == "" {
-		logger.Log().Info("Invalid credentials, no token", zap.Any("authToken", authToken))
-		response["error"] = "invalid auth token"
-		c.JSON(http.StatusUnauthorized, response)
-		c.Abort()
+		abort(c, apperrors.ErrInvalidToken.Wrap(errors.New("no token")))
 		return
 	}
+
+	if strings.HasPrefix(authToken, apiKeyScheme) {
+		ctrl.verifyAPIKey(c, strings.TrimPrefix(authToken, apiKeyScheme))
+		return
+	}
+
 	// remove bearer part from header and parse token to get claims
 	authToken = strings.Replace(authToken, "Bearer ", "", 1)
 
-	if ctrl.authProvider.IsTokenRevoked(ctx, authToken) {
-		logger.Log().Info("Invalid credentials", zap.Any("authToken", authToken))
-		response["error"] = "invalid auth token"
-		c.JSON(http.StatusUnauthorized, response)
-		c.Abort()
+	claims, err := ctrl.authProvider.ParseToken(ctx, authToken)
+	if err != nil {
+		abort(c, err)
 		return
 	}
 
-	parsedToken, err := ctrl.authProvider.ParseToken(ctx, authToken)
+	// every line logged for the rest of the request carries the user.
+	ctx = logger.With(ctx, zap.String("email", claims.Email))
+	c.Request = c.Request.WithContext(ctx)
+
+	ctrl.authProvider.TouchSession(ctx, claims)
+
+	// set claim in context for later use.
+	c.Set("roles", claims.Roles)
+	c.Set("email", claims.Email)
+	c.Set("claims", claims)
+	c.Next()
+}
+
+// verifyAPIKey verifies a user by an api key, the request
+// is limited to the permissions in the scopes of the key.
+func (ctrl *Controller) verifyAPIKey(c *gin.Context, apiKey string) {
+	ctx := c.Request.Context()
+
+	key, roles, err := ctrl.authProvider.AuthenticateAPIKey(ctx, apiKey)
 	if err != nil {
-		logger.Log().Info("Invalid credentials", zap.Any("authToken", authToken))
-		response["error"] = "invalid auth token"
-		c.JSON(http.StatusUnauthorized, response)
-		c.Abort()
+		abort(c, err)
 		return
 	}
 
-	// set claim in context for later use.
-	c.Set("isAdmin", parsedToken["isAdmin"])
-	c.Set("email", parsedToken["email"])
-	c.Set("token", authToken)
+	// every line logged for the rest of the request carries the user and the key.
+	ctx = logger.With(ctx, zap.String("email", key.Email), zap.String("api_key", key.Prefix))
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Set("roles", roles)
+	c.Set("email", key.Email)
+	c.Set("api_key", key)
 	c.Next()
 }
 
 // Logout is an endpoint that logs a user out.
 func (ctrl *Controller) Logout(c *gin.Context) {
 	ctx := c.Request.Context()
-	response := make(map[string]interface{})
 
 	metadata, err := parseContextMetaData(c)
 	if err != nil {
-		logger.Log().Error("error parsing metadata", zap.Error(err))
-		response["error"] = common.InternalServerError
-		c.JSON(http.StatusInternalServerError, response)
-		c.Abort()
+		abort(c, err)
 		return
 	}
 
-	err = ctrl.authProvider.Logout(ctx, metadata.Token, metadata.Email)
+	// the refresh token is optional, when present
+	// its family is revoked along with the access token.
+	request := new(models.RefreshRequest)
+	if c.Request.ContentLength > 0 {
+		err = c.ShouldBindJSON(request)
+		if err != nil {
+			abort(c, apperrors.ErrBadRequest.Wrap(err))
+			return
+		}
+	}
+
+	err = ctrl.authProvider.Logout(ctx, metadata.Claims, request.RefreshToken)
 	if err != nil {
-		response["error"] = common.InternalServerError
-		c.JSON(http.StatusInternalServerError, response)
-		c.Abort()
+		abort(c, err)
 		return
 	}
 }
+
+// LogoutAll is an endpoint that logs a user out of all its sessions.
+func (ctrl *Controller) LogoutAll(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	err = ctrl.authProvider.LogoutAll(ctx, metadata.Email)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+}
+
+// ForgotPassword is an endpoint that sends a password reset token to a user.
+// it always responds with 202 so that accounts can not be discovered.
+func (ctrl *Controller) ForgotPassword(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	response := make(map[string]interface{})
+	request := new(models.ForgotPasswordRequest)
+
+	err := c.ShouldBindJSON(request)
+	if err != nil {
+		abort(c, apperrors.ErrBadRequest.Wrap(err))
+		return
+	}
+
+	if !models.ValidEmail(request.Email) {
+		abort(c, apperrors.NewValidation(apperrors.FieldError{Field: "email", Message: common.EmailValidationError}))
+		return
+	}
+
+	err = ctrl.authProvider.ForgotPassword(ctx, request.Email)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["result"] = "If the account exists a password reset token has been sent"
+	c.JSON(http.StatusAccepted, response)
+}
+
+// ResetPassword is an endpoint that sets a new password using a password reset token.
+func (ctrl *Controller) ResetPassword(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	response := make(map[string]interface{})
+	request := new(models.ResetPasswordRequest)
+
+	err := c.ShouldBindJSON(request)
+	if err != nil {
+		abort(c, apperrors.ErrBadRequest.Wrap(err))
+		return
+	}
+
+	var fields []apperrors.FieldError
+
+	if request.Token == "" {
+		fields = append(fields, apperrors.FieldError{Field: "token", Message: "is required"})
+	}
+
+	if !isPasswordValid(request.Password) {
+		fields = append(fields, apperrors.FieldError{Field: "password", Message: common.PasswordValidationError})
+	}
+
+	err = apperrors.NewValidation(fields...)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	err = ctrl.authProvider.ResetPassword(ctx, request.Token, request.Password)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["result"] = "Successfully Reset"
+	c.JSON(http.StatusOK, response)
+}
+
+// JWKS is an endpoint that publishes the public keys of the access tokens,
+// so that other services can verify them without sharing a secret.
+func (ctrl *Controller) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ctrl.authProvider.JWKS())
+}
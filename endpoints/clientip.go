@@ -0,0 +1,69 @@
+package endpoints
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ForwardedForHeader lists the addresses a request went through, appended to by each proxy.
+const ForwardedForHeader = "X-Forwarded-For"
+
+// ClientAddress is a middleware that resolves the address of the client once for the
+// rate limits, the lockout and the logs. X-Forwarded-For is only read when the connection
+// comes from one of the trusted proxies, any client can set the header otherwise.
+func ClientAddress(trustedProxies []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("client_ip", resolveClientIP(c.Request.RemoteAddr, c.GetHeader(ForwardedForHeader), trustedProxies))
+		c.Next()
+	}
+}
+
+// clientIP returns the address resolved by the ClientAddress middleware,
+// empty when it is unknown.
+func clientIP(c *gin.Context) string {
+	return c.GetString("client_ip")
+}
+
+// resolveClientIP returns the address of the peer, or when the peer is a trusted proxy the
+// right most address of X-Forwarded-For that was not added by a trusted proxy, the left part
+// of the header is set by the client and cannot be trusted.
+func resolveClientIP(remoteAddr string, forwardedFor string, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(remoteAddr))
+	if err != nil {
+		return ""
+	}
+	peer := net.ParseIP(host)
+	if peer == nil {
+		return ""
+	}
+
+	if forwardedFor == "" || !trusted(peer, trustedProxies) {
+		return peer.String()
+	}
+
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// a malformed entry, the last valid hop is the best known client.
+			break
+		}
+		peer = hop
+		if !trusted(hop, trustedProxies) {
+			break
+		}
+	}
+	return peer.String()
+}
+
+// trusted returns true if the ip belongs to one of the networks.
+func trusted(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package endpoints
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gicicm/apperrors"
+	"gicicm/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRedirectURIsLength is the length of the redirect_uris column,
+// the uris are stored separated by spaces.
+const maxRedirectURIsLength = 2000
+
+// messages for invalid client registrations.
+const (
+	clientNameValidationError         = "name must not be empty or longer than 64 characters"
+	clientRedirectURIValidationError  = "must be an absolute uri without a fragment "
+	clientRedirectURIsValidationError = "at least one redirect uri is required for authorization_code, at most 2000 characters in total"
+	clientGrantTypesValidationError   = "at least one grant type is required"
+	clientGrantTypeValidationError    = "unknown grant type "
+	clientPublicGrantValidationError  = "public clients can not use client_credentials"
+	clientRefreshGrantValidationError = "refresh_token requires authorization_code"
+	clientScopeValidationError        = "unknown scope "
+	clientScopeNotGrantedError        = "scope is not granted to the user "
+)
+
+// grantTypes are the grant types clients can be registered for.
+var grantTypes = []string{models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials}
+
+// CreateClient is an endpoint that registers an OAuth client, a client
+// can only be granted scopes that are permissions of the current user.
+func (ctrl *Controller) CreateClient(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	request := new(models.CreateClientRequest)
+	err = c.ShouldBindJSON(request)
+	if err != nil {
+		abort(c, apperrors.ErrBadRequest.Wrap(err))
+		return
+	}
+
+	err = validateClientRequest(request, metadata.Roles)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	client, err := ctrl.authProvider.CreateClient(ctx, metadata.Email, request)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, client)
+}
+
+// ListClients is an endpoint that lists the registered OAuth clients.
+func (ctrl *Controller) ListClients(c *gin.Context) {
+	ctx := c.Request.Context()
+	response := make(map[string]interface{})
+
+	clients, err := ctrl.authProvider.ListClients(ctx)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["clients"] = clients
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteClient is an endpoint that deletes an OAuth client.
+func (ctrl *Controller) DeleteClient(c *gin.Context) {
+	ctx := c.Request.Context()
+	response := make(map[string]interface{})
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	err = ctrl.authProvider.DeleteClient(ctx, metadata.Email, c.Param("client_id"))
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["result"] = "Successfully Deleted"
+	c.JSON(http.StatusOK, response)
+}
+
+// validateClientRequest validates a client registration
+// and drops duplicate grant types and scopes.
+func validateClientRequest(request *models.CreateClientRequest, roles []string) error {
+	var fields []apperrors.FieldError
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || len(request.Name) > 64 {
+		fields = append(fields, apperrors.FieldError{Field: "name", Message: clientNameValidationError})
+	}
+
+	for _, uri := range request.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" || strings.ContainsAny(uri, " \t\n") {
+			fields = append(fields, apperrors.FieldError{Field: "redirect_uris", Message: clientRedirectURIValidationError + uri})
+		}
+	}
+
+	request.GrantTypes = unique(request.GrantTypes)
+	if len(request.GrantTypes) == 0 {
+		fields = append(fields, apperrors.FieldError{Field: "grant_types", Message: clientGrantTypesValidationError})
+	}
+	for _, grantType := range request.GrantTypes {
+		if !contains(grantTypes, grantType) {
+			fields = append(fields, apperrors.FieldError{Field: "grant_types", Message: clientGrantTypeValidationError + grantType})
+		}
+	}
+
+	authorizationCode := contains(request.GrantTypes, models.GrantAuthorizationCode)
+	if authorizationCode && len(request.RedirectURIs) == 0 ||
+		len(strings.Join(request.RedirectURIs, " ")) > maxRedirectURIsLength {
+		fields = append(fields, apperrors.FieldError{Field: "redirect_uris", Message: clientRedirectURIsValidationError})
+	}
+	if request.Public && contains(request.GrantTypes, models.GrantClientCredentials) {
+		fields = append(fields, apperrors.FieldError{Field: "grant_types", Message: clientPublicGrantValidationError})
+	}
+	if !authorizationCode && contains(request.GrantTypes, models.GrantRefreshToken) {
+		fields = append(fields, apperrors.FieldError{Field: "grant_types", Message: clientRefreshGrantValidationError})
+	}
+
+	request.Scopes = unique(request.Scopes)
+	for _, scope := range request.Scopes {
+		switch {
+		case !isPermissionValid(scope):
+			fields = append(fields, apperrors.FieldError{Field: "scopes", Message: clientScopeValidationError + scope})
+		case !hasPermission(roles, scope):
+			fields = append(fields, apperrors.FieldError{Field: "scopes", Message: clientScopeNotGrantedError + scope})
+		}
+	}
+
+	return apperrors.NewValidation(fields...)
+}
+
+// unique returns values without duplicates, in order.
+func unique(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if !contains(result, v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
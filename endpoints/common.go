@@ -10,11 +10,11 @@ import (
 func parseContextMetaData(c *gin.Context) (*models.RequestMetaData, error) {
 	metadata := new(models.RequestMetaData)
 
-	isAdmin, ok := c.Keys["isAdmin"].(bool)
+	roles, ok := c.Keys["roles"].([]string)
 	if !ok {
 		return nil, errors.New("cannot get metadata from request")
 	} else {
-		metadata.IsAdmin = isAdmin
+		metadata.Roles = roles
 	}
 
 	email, ok := c.Keys["email"].(string)
@@ -24,11 +24,11 @@ func parseContextMetaData(c *gin.Context) (*models.RequestMetaData, error) {
 		metadata.Email = email
 	}
 
-	token, ok := c.Keys["token"].(string)
-	if !ok {
+	// requests are authenticated either by an access token or by an api key.
+	metadata.Claims, _ = c.Keys["claims"].(*models.Claims)
+	metadata.APIKey, _ = c.Keys["api_key"].(*models.APIKey)
+	if metadata.Claims == nil && metadata.APIKey == nil {
 		return nil, errors.New("cannot get metadata from request")
-	} else {
-		metadata.Token = token
 	}
 
 	return metadata, nil
@@ -1,6 +1,9 @@
 package endpoints
 
 import (
+	"net"
+
+	"gicicm/health"
 	"gicicm/providers"
 	"github.com/gin-gonic/gin"
 )
@@ -10,42 +13,106 @@ import (
 type Controller struct {
 	authProvider providers.AuthProvider
 	userProvider providers.UserProvider
+	checker      *health.Checker
 }
 
 // NewController returns a new instance of the controller.
 func NewController(
 	authProvider providers.AuthProvider,
-	userProvider providers.UserProvider) *gin.Engine {
+	userProvider providers.UserProvider,
+	checker *health.Checker,
+	limiter *RateLimiter,
+	trustedProxies []*net.IPNet) *gin.Engine {
 
 	controller := &Controller{
 		authProvider: authProvider,
 		userProvider: userProvider,
+		checker:      checker,
 	}
 
 	// new router
 	router := gin.New()
+	// the client address is resolved by ClientAddress, gin would trust
+	// X-Forwarded-For and X-Real-Ip from anyone.
+	router.ForwardedByClientIP = false
 
-	router.Use(gin.Logger())
+	router.Use(ClientAddress(trustedProxies))
+	router.Use(RequestLogger)
 	router.Use(gin.Recovery())
+	router.Use(Instrument)
+	router.Use(ErrorHandler)
+	router.NoRoute(NoRoute)
+
+	// probes
+	router.GET("/healthz", controller.Live)
+	router.GET("/readyz", controller.Ready)
+
+	// metrics
+	router.GET("/metrics", Metrics())
+
+	// public keys of the access tokens
+	router.GET("/.well-known/jwks.json", controller.JWKS)
 
 	// root path
 	gicicmRoot := router.Group("/gicicm")
 
 	// Unauthenticated endpoints
-	gicicmRoot.POST("auth/signup", controller.CreateUser)
+	gicicmRoot.POST("auth/signup", limiter.Limit(PolicySignup, ByClientIP), controller.CreateUser)
 
 	// auth
-	gicicmRoot.POST("auth/login", controller.Login)
+	gicicmRoot.POST("auth/login", limiter.Limit(PolicyLogin, ByClientIP), controller.Login)
+	gicicmRoot.POST("auth/refresh", limiter.Limit(PolicyAuth, ByClientIP), controller.Refresh)
+	gicicmRoot.POST("auth/password/forgot", limiter.Limit(PolicyAuth, ByClientIP), controller.ForgotPassword)
+	gicicmRoot.POST("auth/password/reset", limiter.Limit(PolicyAuth, ByClientIP), controller.ResetPassword)
+	gicicmRoot.POST("auth/mfa/verify", limiter.Limit(PolicyLogin, ByClientIP), controller.VerifyMFA)
+
+	// login with an OpenID provider
+	gicicmRoot.GET("auth/oidc/:provider/login", limiter.Limit(PolicyLogin, ByClientIP), controller.OIDCLogin)
+	gicicmRoot.GET("auth/oidc/:provider/callback", limiter.Limit(PolicyLogin, ByClientIP), controller.OIDCCallback)
+
+	// OAuth endpoints of the clients, the clients authenticate themselves.
+	gicicmRoot.POST("oauth/token", limiter.Limit(PolicyAuth, ByClientIP), controller.Token)
+	gicicmRoot.POST("oauth/introspect", limiter.Limit(PolicyDefault, ByClientIP), controller.Introspect)
+	gicicmRoot.POST("oauth/revoke", limiter.Limit(PolicyDefault, ByClientIP), controller.RevokeToken)
 
 	// auth middleware
-	// all endpoint below this are authenticated.
-	gicicmRoot.Use(controller.Verify)
+	// all endpoint below this are authenticated. the client ip is limited before
+	// the credentials are checked so that they can not be guessed unthrottled.
+	gicicmRoot.Use(limiter.Limit(PolicyDefault, ByClientIP), controller.Verify, limiter.Limit(PolicyDefault, ByUser))
+
+	// account operations need a login, api keys are rejected.
+	gicicmRoot.POST("auth/logout", RequireAccessToken, controller.Logout)
+	gicicmRoot.POST("auth/logout-all", RequireAccessToken, controller.LogoutAll)
+	gicicmRoot.GET("auth/sessions", RequireAccessToken, controller.ListSessions)
+	gicicmRoot.DELETE("auth/sessions/:id", RequireAccessToken, controller.RevokeSession)
+	gicicmRoot.POST("auth/mfa/enroll", RequireAccessToken, controller.EnrollMFA)
+	gicicmRoot.POST("auth/mfa/enable", RequireAccessToken, controller.EnableMFA)
+	gicicmRoot.POST("auth/oidc/:provider/link", RequireAccessToken, controller.LinkIdentity)
+
+	// OAuth authorization of the signed in user
+	gicicmRoot.GET("oauth/authorize", RequireAccessToken, controller.Authorize)
+	gicicmRoot.POST("oauth/authorize", RequireAccessToken, controller.Consent)
 
-	gicicmRoot.POST("auth/logout", controller.Logout)
+	// OAuth clients
+	gicicmRoot.POST("oauth/clients", RequirePermission(PermissionClientsManage), controller.CreateClient)
+	gicicmRoot.GET("oauth/clients", RequirePermission(PermissionClientsManage), controller.ListClients)
+	gicicmRoot.DELETE("oauth/clients/:client_id", RequirePermission(PermissionClientsManage), controller.DeleteClient)
+
+	// api keys
+	gicicmRoot.POST("/api-keys", RequireAccessToken, controller.CreateAPIKey)
+	gicicmRoot.GET("/api-keys", RequireAccessToken, controller.ListAPIKeys)
+	gicicmRoot.DELETE("/api-keys/:prefix", RequireAccessToken, controller.RevokeAPIKey)
 
 	// users
-	gicicmRoot.GET("/users", controller.ListUsers)
-	gicicmRoot.DELETE("/users/:email", controller.DeleteUser)
+	gicicmRoot.GET("/users", RequirePermission(PermissionUsersList), controller.ListUsers)
+	gicicmRoot.DELETE("/users/:email", RequirePermission(PermissionUsersDelete), controller.DeleteUser)
+	gicicmRoot.PATCH("/users/:email", controller.UpdateUser)
+	gicicmRoot.DELETE("/users/:email/lock", RequirePermission(PermissionUsersUnlock), controller.UnlockUser)
+	gicicmRoot.DELETE("/users/:email/sessions", RequirePermission(PermissionSessionsRevoke), controller.RevokeUserSessions)
+
+	// roles
+	gicicmRoot.PUT("/users/:email/roles/:role", RequirePermission(PermissionRolesManage), controller.GrantRole)
+	gicicmRoot.DELETE("/users/:email/roles/:role", RequirePermission(PermissionRolesManage), controller.RevokeRole)
 
 	return router
 }
@@ -4,32 +4,95 @@ package endpoints
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"gicicm/adapters/cache"
 	"gicicm/adapters/db"
+	"gicicm/apperrors"
+	"gicicm/common"
 	"gicicm/config"
+	"gicicm/health"
+	"gicicm/migrations"
+	"gicicm/models"
+	"gicicm/oidc"
+	"gicicm/oidc/oidctest"
 	"gicicm/providers"
+	"gicicm/signing"
 	"gicicm/stores"
+	"gicicm/totp"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"log"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 var router *gin.Engine
 
-func TestMain(m *testing.M) {
-	// wait for 10 seconds for docker containers to come up.
-	//fmt.Println("Waiting for docker containers to start...")
-	time.Sleep(time.Second * 10)
+var readiness *health.Readiness
+
+// database is the database of the tests, tests use it to check what is stored.
+var database *sql.DB
+
+// signingKeys sign the access tokens, tests use them to forge tokens.
+var signingKeys *signing.KeySet
+
+// identityProvider is a stub OpenID provider registered as "stub".
+var identityProvider *oidctest.Server
+
+// oidcBrowserCookie is the state cookie of the last OIDC login or link started.
+var oidcBrowserCookie *http.Cookie
+
+// oidcRedirectURL is the callback registered with the stub provider.
+const oidcRedirectURL = "http://gicicm.test/gicicm/auth/oidc/stub/callback"
+
+// notifications captures the password reset tokens sent during the tests.
+var notifications = &captureNotifier{tokens: make(map[string]string)}
+
+// captureNotifier is a notifier that keeps the last token sent to every email.
+type captureNotifier struct {
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func (cn *captureNotifier) SendPasswordReset(ctx context.Context, email, token string) error {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	cn.tokens[email] = token
+	return nil
+}
+
+func (cn *captureNotifier) token(email string) string {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	return cn.tokens[email]
+}
 
+func TestMain(m *testing.M) {
 	config := config.Config{
+		Server: config.ServerConfig{
+			TrustedProxies: []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+		},
 		Database: config.DbConfig{
 			Host:   "localhost",
 			Port:   "5432",
@@ -39,32 +102,369 @@ func TestMain(m *testing.M) {
 			DBType: "postgres",
 		},
 		Cache: config.CacheConfig{
-			Host: "localhost:6379",
+			Driver:  "memory",
+			MaxSize: 1000,
+		},
+		Auth: config.AuthConfig{
+			AccessTokenTTL:   time.Minute * 15,
+			RefreshTokenTTL:  time.Hour,
+			PasswordResetTTL: time.Minute,
+			MFAChallengeTTL:  time.Minute,
+			MFAIssuer:        "gicicm",
+			MFASecretKey:     []byte("0123456789abcdef0123456789abcdef"),
+			Issuer:           "icm",
+			Audience:         "gicicm",
+			ClockSkew:        time.Second * 30,
+			// last_seen and last_used_at are updated on every request.
+			SessionTouchInterval: 0,
+			APIKeyTouchInterval:  0,
+			OIDCStateTTL:         time.Minute,
 		},
-		SigningKey: "secret",
+		Lockout: config.LockoutConfig{
+			MaxFailures:   3,
+			MaxIPFailures: 10,
+			FailureWindow: time.Minute,
+			Duration:      time.Minute,
+			MaxDuration:   time.Minute * 10,
+		},
+		RateLimit: config.RateLimitConfig{
+			Signup:  config.RateLimit{Requests: 3, Period: time.Hour},
+			Login:   config.RateLimit{Requests: 20, Period: time.Minute},
+			Auth:    config.RateLimit{Requests: 20, Period: time.Minute},
+			Default: config.RateLimit{Requests: 1000, Period: time.Minute},
+		},
+	}
+
+	// DB_TYPE=sqlite3 runs the tests against a seeded sqlite
+	// file instead of the postgres docker container.
+	var dir string
+	if os.Getenv("DB_TYPE") == "sqlite3" {
+		var err error
+		dir, err = ioutil.TempDir("", "gicicm")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		config.Database.DBType = "sqlite3"
+		config.Database.DBName = filepath.Join(dir, "icm.db")
+	} else {
+		// wait for 10 seconds for docker containers to come up.
+		//fmt.Println("Waiting for docker containers to start...")
+		time.Sleep(time.Second * 10)
 	}
 
 	// Init adapters
 	cache := cache.NewCache(&config)
-	database := db.NewDatabaseAdapter(&config)
+	database = db.NewDatabaseAdapter(&config)
+
+	err := seedHelper(database, config.Database.DBType)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Init stores
-	userStore := stores.NewUserRepository(database, cache)
+	var userStore stores.UserRepository
+	var auditStore stores.AuditRepository
+	var mfaStore stores.MFARepository
+	var apiKeyStore stores.APIKeyRepository
+	var identityStore stores.IdentityRepository
+	var clientStore stores.ClientRepository
+	if config.Database.DBType == "sqlite3" {
+		userStore = stores.NewSQLiteUserRepository(database, cache)
+		auditStore = stores.NewSQLiteAuditRepository(database)
+		mfaStore = stores.NewSQLiteMFARepository(database)
+		apiKeyStore = stores.NewSQLiteAPIKeyRepository(database)
+		identityStore = stores.NewSQLiteIdentityRepository(database)
+		clientStore = stores.NewSQLiteClientRepository(database)
+	} else {
+		userStore = stores.NewUserRepository(database, cache)
+		auditStore = stores.NewAuditRepository(database)
+		mfaStore = stores.NewMFARepository(database)
+		apiKeyStore = stores.NewAPIKeyRepository(database)
+		identityStore = stores.NewIdentityRepository(database)
+		clientStore = stores.NewClientRepository(database)
+	}
 	authStore := stores.NewAuthRepository(cache)
 
+	// tokens are signed with RS256 and verified with the published key.
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	signingKeys, err = signing.NewKeySet(signing.NewRSAKey("test-rs256", rsaKey))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// users log in with a stub provider that provisions unknown users.
+	identityProvider = oidctest.NewServer("gicicm", "idp-secret")
+	identityProviders, err := oidc.NewRegistry(identityProvider.Client(), config.Auth.ClockSkew, oidc.ProviderConfig{
+		Name:          "stub",
+		Issuer:        identityProvider.Issuer(),
+		ClientID:      identityProvider.ClientID,
+		ClientSecret:  identityProvider.ClientSecret,
+		RedirectURL:   oidcRedirectURL,
+		AutoProvision: true,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Init providers
-	authProvider := providers.NewAuthProvider(userStore, authStore, &config)
-	userProvider := providers.NewUserProvider(userStore)
+	authProvider := providers.NewAuthProvider(userStore, authStore, auditStore, mfaStore, apiKeyStore, identityStore,
+		clientStore, signingKeys, identityProviders, notifications, &config)
+	userProvider := providers.NewUserProvider(userStore, authStore)
 
 	// Init controller
-	router = NewController(authProvider, userProvider)
+	readiness = health.NewReadiness()
+	readiness.SetReady(true)
+	checker := health.NewChecker(readiness, time.Second)
+	checker.Register("database", database.PingContext)
+	checker.Register("cache", func(ctx context.Context) error {
+		return cache.Ping(ctx)
+	})
+
+	router = NewController(authProvider, userProvider, checker, NewRateLimiter(cache, config.RateLimit), config.Server.TrustedProxies)
 
-	err := createUserHelper()
+	err = createUserHelper()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	m.Run()
+	code := m.Run()
+	identityProvider.Close()
+	_ = database.Close()
+	if dir != "" {
+		_ = os.RemoveAll(dir)
+	}
+	os.Exit(code)
+}
+
+func TestController_JWKS(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	router.ServeHTTP(res, req)
+
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "public, max-age=300", res.Header().Get("Cache-Control"))
+
+	jwks := new(signing.JWKS)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), jwks))
+	require.Len(t, jwks.Keys, 1)
+	jwk := jwks.Keys[0]
+	assert.Equal(t, "RSA", jwk.KeyType)
+	assert.Equal(t, "RS256", jwk.Algorithm)
+	assert.Equal(t, "test-rs256", jwk.KeyID)
+
+	// an access token can be verified with the published key alone.
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	require.NoError(t, err)
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	require.NoError(t, err)
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	token, err := jwt.Parse(loginHelper("clayton@gmail.com", "Hello@123123"), func(token *jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "RS256", token.Method.Alg())
+	assert.Equal(t, "test-rs256", token.Header["kid"])
+
+	// a token signed with the public key as an HMAC secret is rejected.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, token.Claims)
+	forged.Header["kid"] = "test-rs256"
+	forgedToken, err := forged.SignedString(x509.MarshalPKCS1PublicKey(publicKey))
+	require.NoError(t, err)
+
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/gicicm/users", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", forgedToken))
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestController_Claims(t *testing.T) {
+	verify := func(token string) int {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/gicicm/users", nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		router.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	first := loginHelper("clayton@gmail.com", "Hello@123123")
+	second := loginHelper("clayton@gmail.com", "Hello@123123")
+
+	parse := func(token string) *models.Claims {
+		claims := new(models.Claims)
+		_, err := signingKeys.Parse(token, claims)
+		require.NoError(t, err)
+		return claims
+	}
+
+	claims := parse(first)
+	assert.Equal(t, "icm", claims.Issuer)
+	assert.Equal(t, "gicicm", claims.Audience)
+	assert.Equal(t, "clayton@gmail.com", claims.Subject)
+	assert.Equal(t, "clayton@gmail.com", claims.Email)
+	assert.NotZero(t, claims.IssuedAt)
+	assert.NotZero(t, claims.NotBefore)
+	assert.NotEmpty(t, claims.Id)
+	assert.NotEqual(t, claims.Id, parse(second).Id)
+
+	// tokens with other registered claims are rejected.
+	forge := func(change func(c *models.Claims)) string {
+		forged := parse(first)
+		change(forged)
+		token, err := signingKeys.Sign(forged)
+		require.NoError(t, err)
+		return token
+	}
+
+	assert.Equal(t, http.StatusOK, verify(forge(func(c *models.Claims) {})))
+	assert.Equal(t, http.StatusUnauthorized, verify(forge(func(c *models.Claims) { c.Issuer = "other" })))
+	assert.Equal(t, http.StatusUnauthorized, verify(forge(func(c *models.Claims) { c.Audience = "other" })))
+	assert.Equal(t, http.StatusUnauthorized, verify(forge(func(c *models.Claims) { c.Id = "" })))
+	assert.Equal(t, http.StatusUnauthorized, verify(forge(func(c *models.Claims) {
+		c.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	})))
+	// a token that expired within the clock skew is accepted.
+	assert.Equal(t, http.StatusOK, verify(forge(func(c *models.Claims) {
+		c.ExpiresAt = time.Now().Add(-time.Second * 10).Unix()
+	})))
+
+	// logging out revokes only the presented token.
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/gicicm/auth/logout", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", first))
+	router.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, verify(first))
+	assert.Equal(t, http.StatusOK, verify(second))
+	// the revocation follows the jti, not the encoded token.
+	assert.Equal(t, http.StatusUnauthorized, verify(forge(func(c *models.Claims) {})))
+}
+
+func TestController_Health(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(res, req)
+
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"status":"up"}`, res.Body.String())
+
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(res, req)
+
+	report := new(health.Report)
+	assert.NoError(t, json.Unmarshal(res.Body.Bytes(), report))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, health.StatusReady, report.Status)
+	assert.Equal(t, health.StatusUp, report.Checks["database"].Status)
+	assert.Equal(t, health.StatusUp, report.Checks["cache"].Status)
+	// only the status of the checks is shown to the unauthenticated callers.
+	assert.Equal(t, `{"status":"ready","checks":{"cache":{"status":"up"},"database":{"status":"up"}}}`, res.Body.String())
+
+	// not ready as soon as shutdown begins.
+	readiness.SetReady(false)
+	defer readiness.SetReady(true)
+
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(res, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+	assert.Equal(t, `{"status":"shutting down"}`, res.Body.String())
+}
+
+func TestController_Metrics(t *testing.T) {
+	_ = loginHelper("clayton@gmail.com", "Hello@123123")
+	_ = loginHelper("clayton@gmail.com", "wrong")
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(res, req)
+
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	body := res.Body.String()
+	assert.Contains(t, body, `gicicm_http_request_duration_seconds_count{method="POST",route="/gicicm/auth/login",status="200"}`)
+	assert.Contains(t, body, `gicicm_http_request_duration_seconds_count{method="POST",route="/gicicm/auth/login",status="401"}`)
+	assert.Contains(t, body, `gicicm_auth_logins_total{result="success"}`)
+	assert.Contains(t, body, `gicicm_auth_logins_total{result="failure"}`)
+	assert.Contains(t, body, `gicicm_cache_requests_total{driver="memory",result="hit"}`)
+	assert.Contains(t, body, `gicicm_db_query_duration_seconds_count{query="fetch_user"}`)
+
+	// the raw path of unknown routes is not used as a label.
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/does/not/exist", nil)
+	router.ServeHTTP(res, req)
+
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	router.ServeHTTP(res, req)
+
+	assert.NotContains(t, res.Body.String(), "/does/not/exist")
+	assert.Contains(t, res.Body.String(), `route="unmatched",status="404"`)
+}
+
+func TestController_RequestID(t *testing.T) {
+	// propagated from the client.
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	router.ServeHTTP(res, req)
+
+	assert.Equal(t, "abc-123", res.Header().Get(RequestIDHeader))
+
+	// assigned when missing.
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(res, req)
+
+	assert.Len(t, res.Header().Get(RequestIDHeader), 32)
+
+	// replaced when it is not a valid id.
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	req.Header.Set(RequestIDHeader, "abc\n{\"level\":\"ERROR\"}")
+	router.ServeHTTP(res, req)
+
+	assert.Len(t, res.Header().Get(RequestIDHeader), 32)
+}
+
+func TestController_Problem(t *testing.T) {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/gicicm/nothing/here", nil)
+	router.ServeHTTP(res, req)
+
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	assert.Equal(t, "application/problem+json", res.Header().Get("Content-Type"))
+	assert.Equal(t, problemHelper(404, apperrors.ErrRouteNotFound, "/gicicm/nothing/here"), res.Body.String())
+
+	// every invalid field is reported.
+	token := loginHelper("clayton@gmail.com", "Hello@123123")
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/gicicm/users?limit=1000&sort=password", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	router.ServeHTTP(res, req)
+
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, problemHelper(400, apperrors.ErrValidation, "/gicicm/users",
+		apperrors.FieldError{Field: "limit", Message: "must be a number between 1 and 200"},
+		apperrors.FieldError{Field: "sort", Message: "must be one of id, name or email"},
+	), res.Body.String())
+
+	// tokens are rejected with a problem too.
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/gicicm/users", nil)
+	req.Header.Add("Authorization", "Bearer garbage")
+	router.ServeHTTP(res, req)
+
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, problemHelper(401, apperrors.ErrInvalidToken, "/gicicm/users"), res.Body.String())
 }
 
 func TestController_Login(t *testing.T) {
@@ -90,7 +490,7 @@ func TestController_Login(t *testing.T) {
 						"password":"hello123d"
 						}`,
 			expectedStatusCode: 401,
-			expectedMessage:    `{"error":"invalid credentials"}`,
+			expectedMessage:    `{"type":"urn:gicicm:error:invalid_credentials","title":"Unauthorized","status":401,"detail":"invalid credentials","instance":"/gicicm/auth/login","code":"invalid_credentials"}`,
 		},
 	}
 
@@ -119,7 +519,7 @@ func TestController_Login(t *testing.T) {
 
 func TestController_ListUsers(t *testing.T) {
 	token := loginHelper("clayton@gmail.com", "Hello@123123")
-	expectedResponse := `[{"id":"1","email":"delete@me.com","name":"user to be deleted"},{"id":"2","email":"clayton@test.com","name":"superadmin"},{"id":"3","email":"testtwo@mail.com","name":"test user 2"},{"id":"4","email":"test@mail.com","name":"test user 1"},{"id":"5","email":"clayton@gmail.com","name":"clayton gonsalves"}]`
+	expectedResponse := `{"users":[{"id":"1","email":"delete@me.com","name":"user to be deleted"},{"id":"2","email":"clayton@test.com","name":"superadmin"},{"id":"3","email":"testtwo@mail.com","name":"test user 2"},{"id":"4","email":"test@mail.com","name":"test user 1"},{"id":"5","email":"clayton@gmail.com","name":"clayton gonsalves"}],"total_estimate":5}`
 
 	res := httptest.NewRecorder()
 
@@ -136,6 +536,62 @@ func TestController_ListUsers(t *testing.T) {
 	assert.Equal(t, expectedResponse, string(got))
 }
 
+func TestController_ListUsers_Pagination(t *testing.T) {
+	token := loginHelper("clayton@gmail.com", "Hello@123123")
+
+	listHelper := func(query string) (int, *models.UserPage) {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/gicicm/users?"+query, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		router.ServeHTTP(res, req)
+
+		page := new(models.UserPage)
+		_ = json.Unmarshal(res.Body.Bytes(), page)
+		return res.Code, page
+	}
+
+	// walk all the pages sorted by email descending.
+	var emails []string
+	query := "limit=2&sort=-email"
+	for {
+		code, page := listHelper(query)
+		assert.Equal(t, http.StatusOK, code)
+		for _, user := range page.Users {
+			emails = append(emails, user.Email)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query = "limit=2&sort=-email&cursor=" + page.NextCursor
+	}
+	assert.Equal(t, []string{"testtwo@mail.com", "test@mail.com", "delete@me.com", "clayton@test.com", "clayton@gmail.com"}, emails)
+
+	code, page := listHelper("email_prefix=clayton@&name=GONS")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int64(1), page.TotalEstimate)
+	assert.Equal(t, "clayton@gmail.com", page.Users[0].Email)
+
+	code, _ = listHelper("limit=0")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = listHelper("sort=password")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = listHelper("cursor=garbage")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// a well formed cursor with an id that can not be a user id is rejected before the query.
+	cursor := base64.RawURLEncoding.EncodeToString([]byte(`{"s":"id","d":false,"v":"x","i":"x"}`))
+	code, _ = listHelper("sort=id&cursor=" + cursor)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// the total is only estimated for the first page.
+	_, page = listHelper("limit=2")
+	assert.Equal(t, int64(5), page.TotalEstimate)
+	_, page = listHelper("limit=2&cursor=" + page.NextCursor)
+	assert.Zero(t, page.TotalEstimate)
+}
+
 func TestController_CreateUser(t *testing.T) {
 	tests := []struct {
 		name               string
@@ -161,7 +617,7 @@ func TestController_CreateUser(t *testing.T) {
 						"password":"hellO@123"
 						}`,
 			expectedStatusCode: 400,
-			expectedMessage:    `{"error":"invalid input format"}`,
+			expectedMessage:    problemHelper(400, apperrors.ErrValidation, "/gicicm/auth/signup", apperrors.FieldError{Field: "name", Message: nameValidationError}),
 		},
 		{
 			name: "Invalid password",
@@ -171,7 +627,7 @@ func TestController_CreateUser(t *testing.T) {
 						"password":"h2"
 						}`,
 			expectedStatusCode: 400,
-			expectedMessage:    `{"error":"invalid password, should have more than 8 characters, atleast 1 symbol, 1 uppercase character and a number"}`,
+			expectedMessage:    problemHelper(400, apperrors.ErrValidation, "/gicicm/auth/signup", apperrors.FieldError{Field: "password", Message: common.PasswordValidationError}, apperrors.FieldError{Field: "name", Message: nameValidationError}),
 		},
 		{
 			name: "Invalid email",
@@ -181,7 +637,17 @@ func TestController_CreateUser(t *testing.T) {
 						"password":"Hello@123123"
 						}`,
 			expectedStatusCode: 400,
-			expectedMessage:    `{"error":"invalid email"}`,
+			expectedMessage:    problemHelper(400, apperrors.ErrValidation, "/gicicm/auth/signup", apperrors.FieldError{Field: "email", Message: common.EmailValidationError}, apperrors.FieldError{Field: "name", Message: nameValidationError}),
+		},
+		{
+			name: "Email longer than the email column",
+			reqBody: ` {
+						"email":"a-very-long-address-of-forty-one@mail.com",
+						"name":"long email",
+						"password":"Hello@123123"
+						}`,
+			expectedStatusCode: 400,
+			expectedMessage:    problemHelper(400, apperrors.ErrValidation, "/gicicm/auth/signup", apperrors.FieldError{Field: "email", Message: common.EmailValidationError}),
 		},
 		{
 			name: "Account already exists",
@@ -191,7 +657,7 @@ func TestController_CreateUser(t *testing.T) {
 						"password":"Hello@123123"
 						}`,
 			expectedStatusCode: 409,
-			expectedMessage:    `{"error":"account already exists"}`,
+			expectedMessage:    problemHelper(409, apperrors.ErrAccountAlreadyExists, "/gicicm/auth/signup"),
 		},
 	}
 
@@ -235,7 +701,7 @@ func TestController_DeleteUser(t *testing.T) {
 			email:              "clayton@test.com",
 			password:           "hello123",
 			expectedStatusCode: 404,
-			expectedMessage:    `{"error":"account does not exist"}`,
+			expectedMessage:    problemHelper(404, apperrors.ErrAccountNotFound, "/gicicm/users/delete@me.com"),
 		},
 		{
 			name:               "admin user, resource exists",
@@ -243,7 +709,7 @@ func TestController_DeleteUser(t *testing.T) {
 			email:              "clayton@gmail.com",
 			password:           "Hello@123123",
 			expectedStatusCode: 403,
-			expectedMessage:    `{"error":"not permitted to perform this operation"}`,
+			expectedMessage:    problemHelper(403, apperrors.ErrPermissionDenied, "/gicicm/users/delete@me.com"),
 		},
 	}
 
@@ -297,24 +763,1358 @@ func TestController_Logout(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, getRes.Code)
 }
 
-func loginHelper(email, password string) string {
-	reqBody := fmt.Sprintf(
-		`{
-			"email":"%s",
-			"password":"%s"
-		}`,
-		email, password)
+func TestController_LogoutAll(t *testing.T) {
+	email := "sessions@mail.com"
+	password := "Hello@123123"
+	require.Equal(t, http.StatusCreated, signupHelper(email, password).Code)
 
-	res := httptest.NewRecorder()
-	req, _ := http.NewRequest(
-		"POST",
-		"/gicicm/auth/login",
-		bytes.NewReader([]byte(reqBody)))
+	first := loginTokensHelper(email, password)
+	second := loginTokensHelper(email, password)
+	assert.Equal(t, http.StatusOK, sessionsHelper("POST", "/gicicm/auth/logout-all", first.AccessToken).Code)
+
+	// every session of the user is revoked.
+	for _, tokens := range []*models.TokenPair{first, second} {
+		assert.Equal(t, http.StatusUnauthorized, sessionsHelper("GET", "/gicicm/users", tokens.AccessToken).Code)
+		assert.Equal(t, http.StatusUnauthorized, refreshHelper(tokens.RefreshToken).Code)
+	}
+
+	// new logins are not affected.
+	tokens := loginTokensHelper(email, password)
+	assert.Equal(t, http.StatusOK, sessionsHelper("GET", "/gicicm/users", tokens.AccessToken).Code)
+
+	// only admins can revoke the sessions of others.
+	path := fmt.Sprintf("/gicicm/users/%s/sessions", email)
+	res := sessionsHelper("DELETE", path, loginHelper("clayton@gmail.com", "Hello@123123"))
+	assert.Equal(t, problemHelper(403, apperrors.ErrPermissionDenied, path), res.Body.String())
+
+	admin := loginHelper("clayton@test.com", "hello123")
+	res = sessionsHelper("DELETE", path, admin)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"result":"Successfully Revoked"}`, res.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, sessionsHelper("GET", "/gicicm/users", tokens.AccessToken).Code)
+
+	res = sessionsHelper("DELETE", "/gicicm/users/nobody@mail.com/sessions", admin)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+
+	// changing the password revokes the sessions.
+	tokens = loginTokensHelper(email, password)
+	res = httptest.NewRecorder()
+	req, _ := http.NewRequest("PATCH", fmt.Sprintf("/gicicm/users/%s", email), strings.NewReader(`{"password":"Hello@456456"}`))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", tokens.AccessToken))
 	router.ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, http.StatusUnauthorized, sessionsHelper("GET", "/gicicm/users", tokens.AccessToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, refreshHelper(tokens.RefreshToken).Code)
 
-	got, _ := ioutil.ReadAll(res.Body)
+	// deleting the user revokes the sessions.
+	tokens = loginTokensHelper(email, "Hello@456456")
+	assert.Equal(t, http.StatusOK, sessionsHelper("DELETE", fmt.Sprintf("/gicicm/users/%s", email), admin).Code)
+	assert.Equal(t, http.StatusUnauthorized, sessionsHelper("GET", "/gicicm/users", tokens.AccessToken).Code)
+
+	// the generation is deleted with the user, an account created again with
+	// the email does not accept the tokens of the deleted one.
+	email = "recreated@mail.com"
+	require.Equal(t, http.StatusCreated, signupHelper(email, password).Code)
+	tokens = loginTokensHelper(email, password)
+	assert.Equal(t, http.StatusOK, sessionsHelper("DELETE", fmt.Sprintf("/gicicm/users/%s", email), admin).Code)
+	require.Equal(t, http.StatusCreated, signupHelper(email, password).Code)
+	assert.Equal(t, http.StatusUnauthorized, sessionsHelper("GET", "/gicicm/users", tokens.AccessToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, refreshHelper(tokens.RefreshToken).Code)
+	assert.Equal(t, http.StatusOK, sessionsHelper("GET", "/gicicm/users", loginHelper(email, password)).Code)
+}
+
+func TestController_Sessions(t *testing.T) {
+	email := "devices@mail.com"
+	password := "Hello@123123"
+	require.Equal(t, http.StatusCreated, signupHelper(email, password).Code)
+
+	login := func(userAgent string) *models.TokenPair {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/gicicm/auth/login",
+			strings.NewReader(fmt.Sprintf(`{"email":"%s","password":"%s"}`, email, password)))
+		req.Header.Set("User-Agent", userAgent)
+		req.RemoteAddr = "192.0.2.50:4321"
+		router.ServeHTTP(res, req)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		tokens := new(models.TokenPair)
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), tokens))
+		return tokens
+	}
+	list := func(token string) []*models.Session {
+		res := sessionsHelper("GET", "/gicicm/auth/sessions", token)
+		require.Equal(t, http.StatusOK, res.Code)
+
+		var response struct {
+			Sessions []*models.Session `json:"sessions"`
+		}
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &response))
+		return response.Sessions
+	}
+
+	laptop := login("laptop/1.0")
+	phone := login("phone/1.0")
+
+	sessions := list(laptop.AccessToken)
+	require.Len(t, sessions, 2)
+	// the current session was seen last.
+	current := sessions[0]
+	assert.True(t, current.Current)
+	assert.Equal(t, "laptop/1.0", current.UserAgent)
+	assert.Equal(t, "192.0.2.50", current.IP)
+	assert.False(t, sessions[1].Current)
+	assert.Equal(t, "phone/1.0", sessions[1].UserAgent)
+	phoneID := sessions[1].ID
+
+	// last_seen moves with the requests of the session.
+	sessions = list(laptop.AccessToken)
+	assert.True(t, sessions[0].LastSeen.After(current.LastSeen))
+	assert.Equal(t, current.CreatedAt, sessions[0].CreatedAt)
+
+	// a refresh continues the session.
+	res := refreshHelper(laptop.RefreshToken)
+	require.Equal(t, http.StatusOK, res.Code)
+	refreshed := new(models.TokenPair)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), refreshed))
+	sessions = list(refreshed.AccessToken)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, current.ID, sessions[0].ID)
+
+	// sessions of other users can not be revoked.
+	path := fmt.Sprintf("/gicicm/auth/sessions/%s", phoneID)
+	res = sessionsHelper("DELETE", path, loginHelper("clayton@gmail.com", "Hello@123123"))
+	assert.Equal(t, problemHelper(404, apperrors.ErrSessionNotFound, path), res.Body.String())
+
+	res = sessionsHelper("DELETE", path, refreshed.AccessToken)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"result":"Successfully Revoked"}`, res.Body.String())
+
+	// the tokens of the revoked session stop working.
+	assert.Equal(t, http.StatusUnauthorized, sessionsHelper("GET", "/gicicm/auth/sessions", phone.AccessToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, refreshHelper(phone.RefreshToken).Code)
+
+	sessions = list(refreshed.AccessToken)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, current.ID, sessions[0].ID)
+
+	res = sessionsHelper("DELETE", path, refreshed.AccessToken)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+
+	// logging out of all sessions clears the list.
+	require.Equal(t, http.StatusOK, sessionsHelper("POST", "/gicicm/auth/logout-all", refreshed.AccessToken).Code)
+	assert.Empty(t, list(login("laptop/1.0").AccessToken)[1:])
+}
+
+func TestController_APIKeys(t *testing.T) {
+	admin := loginHelper("clayton@test.com", "hello123")
+
+	create := func(token, reqBody string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/gicicm/api-keys", strings.NewReader(reqBody))
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+		router.ServeHTTP(res, req)
+		return res
+	}
+	withKey := func(method, path, key string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Add("Authorization", fmt.Sprintf("ApiKey %s", key))
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	res := create(admin, `{"name":"ci","scopes":["users:list","users:list"]}`)
+	require.Equal(t, http.StatusCreated, res.Code)
+	created := new(models.CreatedAPIKey)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), created))
+	assert.True(t, strings.HasPrefix(created.Key, "gic_"+created.Prefix+"_"))
+	assert.Equal(t, "ci", created.Name)
+	assert.Equal(t, []string{"users:list"}, created.Scopes)
+	assert.Nil(t, created.ExpiresAt)
+
+	// the key only grants its scopes.
+	assert.Equal(t, http.StatusOK, withKey("GET", "/gicicm/users", created.Key).Code)
+	res = withKey("DELETE", "/gicicm/users/nobody@mail.com", created.Key)
+	assert.Equal(t, problemHelper(403, apperrors.ErrPermissionDenied, "/gicicm/users/nobody@mail.com"), res.Body.String())
+	res = withKey("PATCH", "/gicicm/users/clayton@test.com", created.Key)
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	// account operations need an access token.
+	res = withKey("GET", "/gicicm/api-keys", created.Key)
+	assert.Equal(t, problemHelper(403, apperrors.ErrAccessTokenRequired, "/gicicm/api-keys"), res.Body.String())
+	assert.Equal(t, http.StatusForbidden, withKey("POST", "/gicicm/auth/logout", created.Key).Code)
+
+	// unknown and tampered keys are rejected.
+	res = withKey("GET", "/gicicm/users", "gic_000000000000_unknown")
+	assert.Equal(t, problemHelper(401, apperrors.ErrInvalidAPIKey, "/gicicm/users"), res.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, withKey("GET", "/gicicm/users", created.Key+"x").Code)
+	assert.Equal(t, http.StatusUnauthorized, withKey("GET", "/gicicm/users", "not-a-key").Code)
+
+	// the key is listed without its secret and with its last use.
+	res = sessionsHelper("GET", "/gicicm/api-keys", admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.NotContains(t, res.Body.String(), created.Key[len("gic_"+created.Prefix+"_"):])
+	var listed struct {
+		APIKeys []*models.APIKey `json:"api_keys"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &listed))
+	require.Len(t, listed.APIKeys, 1)
+	assert.Equal(t, created.Prefix, listed.APIKeys[0].Prefix)
+	assert.NotNil(t, listed.APIKeys[0].LastUsedAt)
+
+	// keys are scoped to permissions of the user and expire in the future.
+	user := loginHelper("clayton@gmail.com", "Hello@123123")
+	res = create(user, `{"name":"ci","scopes":["users:delete","unknown"]}`)
+	assert.Equal(t, problemHelper(400, apperrors.ErrValidation, "/gicicm/api-keys",
+		apperrors.FieldError{Field: "scopes", Message: apiKeyScopeNotGrantedError + "users:delete"},
+		apperrors.FieldError{Field: "scopes", Message: apiKeyScopeValidationError + "unknown"}), res.Body.String())
+	res = create(user, `{"name":" ","scopes":[],"expires_at":"2020-01-01T00:00:00Z"}`)
+	assert.Equal(t, problemHelper(400, apperrors.ErrValidation, "/gicicm/api-keys",
+		apperrors.FieldError{Field: "name", Message: apiKeyNameValidationError},
+		apperrors.FieldError{Field: "scopes", Message: apiKeyScopesValidationError},
+		apperrors.FieldError{Field: "expires_at", Message: apiKeyExpiresAtValidationError}), res.Body.String())
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	res = create(user, fmt.Sprintf(`{"name":"cron","scopes":["users:list"],"expires_at":"%s"}`, expiresAt))
+	require.Equal(t, http.StatusCreated, res.Code)
+	userKey := new(models.CreatedAPIKey)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), userKey))
+	assert.Equal(t, expiresAt, userKey.ExpiresAt.Format(time.RFC3339))
+
+	// keys are only revoked by their owner.
+	path := fmt.Sprintf("/gicicm/api-keys/%s", created.Prefix)
+	res = sessionsHelper("DELETE", path, user)
+	assert.Equal(t, problemHelper(404, apperrors.ErrAPIKeyNotFound, path), res.Body.String())
 
-	return strings.Trim(string(got), "\"")
+	res = sessionsHelper("DELETE", path, admin)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"result":"Successfully Revoked"}`, res.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, withKey("GET", "/gicicm/users", created.Key).Code)
+	assert.Equal(t, http.StatusOK, withKey("GET", "/gicicm/users", userKey.Key).Code)
+}
+
+func TestController_Refresh(t *testing.T) {
+	tokens := loginTokensHelper("clayton@gmail.com", "Hello@123123")
+
+	// first use rotates the refresh token.
+	res := refreshHelper(tokens.RefreshToken)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	rotated := new(models.TokenPair)
+	assert.NoError(t, json.Unmarshal(res.Body.Bytes(), rotated))
+	assert.NotEmpty(t, rotated.AccessToken)
+	assert.NotEqual(t, tokens.RefreshToken, rotated.RefreshToken)
+
+	// replaying the old refresh token is rejected...
+	res = refreshHelper(tokens.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, problemHelper(401, apperrors.ErrInvalidRefreshToken, "/gicicm/auth/refresh"), res.Body.String())
+
+	// ...and revokes the rest of the family.
+	res = refreshHelper(rotated.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	// unknown refresh tokens are rejected.
+	res = refreshHelper("unknown")
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestController_Refresh_Concurrent(t *testing.T) {
+	tokens := loginTokensHelper("clayton@gmail.com", "Hello@123123")
+
+	// concurrent presentations of the same token rotate it at most once.
+	const presentations = 8
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, presentations)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = refreshHelper(tokens.RefreshToken)
+		}(i)
+	}
+	wg.Wait()
+
+	var rotated []*models.TokenPair
+	for _, res := range results {
+		if res.Code == http.StatusOK {
+			pair := new(models.TokenPair)
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), pair))
+			rotated = append(rotated, pair)
+		}
+	}
+	require.Len(t, rotated, 1)
+
+	// the other presentations were detected as reuse and revoked the family.
+	assert.Equal(t, http.StatusUnauthorized, refreshHelper(rotated[0].RefreshToken).Code)
+}
+
+func TestController_Roles(t *testing.T) {
+	tests := []struct {
+		name               string
+		method             string
+		email              string
+		password           string
+		reqPath            string
+		expectedStatusCode int
+		expectedMessage    string
+	}{
+		{
+			name:               "admin grants role",
+			method:             "PUT",
+			reqPath:            "/gicicm/users/testtwo@mail.com/roles/admin",
+			email:              "clayton@test.com",
+			password:           "hello123",
+			expectedStatusCode: 200,
+			expectedMessage:    `{"result":"Successfully Granted"}`,
+		},
+		{
+			name:               "admin revokes role",
+			method:             "DELETE",
+			reqPath:            "/gicicm/users/testtwo@mail.com/roles/admin",
+			email:              "clayton@test.com",
+			password:           "hello123",
+			expectedStatusCode: 200,
+			expectedMessage:    `{"result":"Successfully Revoked"}`,
+		},
+		{
+			name:               "admin grants unknown role",
+			method:             "PUT",
+			reqPath:            "/gicicm/users/testtwo@mail.com/roles/superuser",
+			email:              "clayton@test.com",
+			password:           "hello123",
+			expectedStatusCode: 400,
+			expectedMessage:    problemHelper(400, apperrors.ErrInvalidRole, "/gicicm/users/testtwo@mail.com/roles/superuser"),
+		},
+		{
+			name:               "admin grants role to unknown user",
+			method:             "PUT",
+			reqPath:            "/gicicm/users/nobody@mail.com/roles/admin",
+			email:              "clayton@test.com",
+			password:           "hello123",
+			expectedStatusCode: 404,
+			expectedMessage:    problemHelper(404, apperrors.ErrAccountNotFound, "/gicicm/users/nobody@mail.com/roles/admin"),
+		},
+		{
+			name:               "non admin grants role",
+			method:             "PUT",
+			reqPath:            "/gicicm/users/clayton@gmail.com/roles/admin",
+			email:              "clayton@gmail.com",
+			password:           "Hello@123123",
+			expectedStatusCode: 403,
+			expectedMessage:    problemHelper(403, apperrors.ErrPermissionDenied, "/gicicm/users/clayton@gmail.com/roles/admin"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := loginHelper(tt.email, tt.password)
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.reqPath, nil)
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+			router.ServeHTTP(res, req)
+
+			assert.Equal(t, tt.expectedStatusCode, res.Code)
+			assert.Equal(t, tt.expectedMessage, res.Body.String())
+		})
+	}
+}
+
+func TestController_RevokeRole_RevokesSessions(t *testing.T) {
+	admin := loginHelper("clayton@test.com", "hello123")
+	require.Equal(t, http.StatusCreated, signupHelper("demoted@mail.com", "Hello@123123").Code)
+	require.Equal(t, http.StatusOK, sessionsHelper("PUT", "/gicicm/users/demoted@mail.com/roles/admin", admin).Code)
+
+	tokens := loginTokensHelper("demoted@mail.com", "Hello@123123")
+	require.Equal(t, http.StatusOK, sessionsHelper("GET", "/gicicm/users", tokens.AccessToken).Code)
+
+	// the tokens issued with the role are not accepted once it is revoked.
+	require.Equal(t, http.StatusOK, sessionsHelper("DELETE", "/gicicm/users/demoted@mail.com/roles/admin", admin).Code)
+	assert.Equal(t, http.StatusUnauthorized, sessionsHelper("GET", "/gicicm/users", tokens.AccessToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, refreshHelper(tokens.RefreshToken).Code)
+}
+
+func TestController_UpdateUser(t *testing.T) {
+	tests := []struct {
+		name               string
+		email              string
+		password           string
+		reqParam           string
+		reqBody            string
+		expectedStatusCode int
+		expectedMessage    string
+	}{
+		{
+			name:               "user updates self",
+			reqParam:           "clayton@gmail.com",
+			reqBody:            `{"name":"clayton gonsalves"}`,
+			email:              "clayton@gmail.com",
+			password:           "Hello@123123",
+			expectedStatusCode: 200,
+			expectedMessage:    `{"result":"Successfully Updated"}`,
+		},
+		{
+			name:               "user updates someone else",
+			reqParam:           "testtwo@mail.com",
+			reqBody:            `{"name":"hacked"}`,
+			email:              "clayton@gmail.com",
+			password:           "Hello@123123",
+			expectedStatusCode: 403,
+			expectedMessage:    problemHelper(403, apperrors.ErrPermissionDenied, "/gicicm/users/testtwo@mail.com"),
+		},
+		{
+			name:               "admin updates someone else",
+			reqParam:           "testtwo@mail.com",
+			reqBody:            `{"name":"test user 2","password":"Hello@123123"}`,
+			email:              "clayton@test.com",
+			password:           "hello123",
+			expectedStatusCode: 200,
+			expectedMessage:    `{"result":"Successfully Updated"}`,
+		},
+		{
+			name:               "invalid password",
+			reqParam:           "clayton@gmail.com",
+			reqBody:            `{"password":"h2"}`,
+			email:              "clayton@gmail.com",
+			password:           "Hello@123123",
+			expectedStatusCode: 400,
+			expectedMessage:    problemHelper(400, apperrors.ErrValidation, "/gicicm/users/clayton@gmail.com", apperrors.FieldError{Field: "password", Message: common.PasswordValidationError}),
+		},
+		{
+			name:               "empty update",
+			reqParam:           "clayton@gmail.com",
+			reqBody:            `{}`,
+			email:              "clayton@gmail.com",
+			password:           "Hello@123123",
+			expectedStatusCode: 400,
+			expectedMessage:    problemHelper(400, apperrors.ErrBadRequest, "/gicicm/users/clayton@gmail.com"),
+		},
+		{
+			name:               "admin updates unknown user",
+			reqParam:           "nobody@mail.com",
+			reqBody:            `{"name":"nobody"}`,
+			email:              "clayton@test.com",
+			password:           "hello123",
+			expectedStatusCode: 404,
+			expectedMessage:    problemHelper(404, apperrors.ErrAccountNotFound, "/gicicm/users/nobody@mail.com"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := loginHelper(tt.email, tt.password)
+			res := httptest.NewRecorder()
+			req, _ := http.NewRequest(
+				"PATCH",
+				fmt.Sprintf("/gicicm/users/%s", tt.reqParam),
+				bytes.NewReader([]byte(tt.reqBody)))
+			req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+			router.ServeHTTP(res, req)
+
+			assert.Equal(t, tt.expectedStatusCode, res.Code)
+			assert.Equal(t, tt.expectedMessage, res.Body.String())
+		})
+	}
+
+	// the new password is used on the next login.
+	assert.NotEmpty(t, loginHelper("testtwo@mail.com", "Hello@123123"))
+}
+
+func TestController_PasswordReset(t *testing.T) {
+	email := "test@mail.com"
+	oldTokens := loginTokensHelper("clayton@test.com", "hello123")
+
+	// unknown accounts are not revealed.
+	res := passwordHelper("forgot", `{"email":"nobody@mail.com"}`)
+	assert.Equal(t, http.StatusAccepted, res.Code)
+	assert.Empty(t, notifications.token("nobody@mail.com"))
+
+	res = passwordHelper("forgot", fmt.Sprintf(`{"email":"%s"}`, email))
+	assert.Equal(t, http.StatusAccepted, res.Code)
+	resetToken := notifications.token(email)
+	assert.NotEmpty(t, resetToken)
+
+	res = passwordHelper("reset", fmt.Sprintf(`{"token":"%s","password":"h2"}`, resetToken))
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	res = passwordHelper("reset", fmt.Sprintf(`{"token":"%s","password":"Hello@123123"}`, resetToken))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"result":"Successfully Reset"}`, res.Body.String())
+
+	// the token can only be used once.
+	res = passwordHelper("reset", fmt.Sprintf(`{"token":"%s","password":"Hello@123123"}`, resetToken))
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, problemHelper(400, apperrors.ErrInvalidResetToken, "/gicicm/auth/password/reset"), res.Body.String())
+
+	assert.NotEmpty(t, loginHelper(email, "Hello@123123"))
+
+	// sessions of other users are untouched.
+	res = refreshHelper(oldTokens.RefreshToken)
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestController_Lockout(t *testing.T) {
+	email := "test@mail.com"
+	adminToken := loginHelper("clayton@test.com", "hello123")
+	userToken := loginHelper("clayton@gmail.com", "Hello@123123")
+
+	// the account is locked on the third failure.
+	for i := 0; i < 2; i++ {
+		res := loginResponseHelper(email, "wrong", "")
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	}
+	res := loginResponseHelper(email, "wrong", "")
+	assert.Equal(t, http.StatusLocked, res.Code)
+	assert.Equal(t, "60", res.Header().Get("Retry-After"))
+
+	problem := new(models.Problem)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), problem))
+	assert.Equal(t, apperrors.ErrAccountLocked.Code, problem.Code)
+	assert.Equal(t, int64(60), problem.RetryAfter)
+
+	// the right password does not get through either.
+	res = loginResponseHelper(email, "Hello@123123", "")
+	assert.Equal(t, http.StatusLocked, res.Code)
+
+	// only admins can unlock.
+	res = unlockHelper(email, userToken)
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	res = unlockHelper(email, adminToken)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `{"result":"Successfully Unlocked"}`, res.Body.String())
+
+	res = loginResponseHelper(email, "Hello@123123", "")
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	// failures from a single ip are counted across accounts.
+	for i := 0; i < 9; i++ {
+		res = loginResponseHelper(fmt.Sprintf("nobody%d@mail.com", i), "wrong", "192.0.2.10:4000")
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	}
+	res = loginResponseHelper("nobody@mail.com", "wrong", "192.0.2.10:4000")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "60", res.Header().Get("Retry-After"))
+
+	res = loginResponseHelper(email, "Hello@123123", "192.0.2.10:4000")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+
+	// a spoofed X-Forwarded-For does not get a blocked client through.
+	res = httptest.NewRecorder()
+	req, _ := http.NewRequest(
+		"POST",
+		"/gicicm/auth/login",
+		bytes.NewReader([]byte(fmt.Sprintf(`{"email":"%s","password":"%s"}`, email, "Hello@123123"))))
+	req.RemoteAddr = "192.0.2.10:4000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.10")
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+
+	// other clients are not affected.
+	res = loginResponseHelper(email, "Hello@123123", "192.0.2.11:4000")
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestController_RateLimit(t *testing.T) {
+	signup := func(remoteAddr string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest(
+			"POST",
+			"/gicicm/auth/signup",
+			bytes.NewReader([]byte(`{"email":"limited@mail.com"}`)))
+		req.RemoteAddr = remoteAddr
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	// every request takes a token, whatever its outcome.
+	for i := 2; i >= 0; i-- {
+		res := signup("192.0.2.20:4000")
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+		assert.Equal(t, "3", res.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(i), res.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	res := signup("192.0.2.20:4000")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "0", res.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "3600", res.Header().Get("X-RateLimit-Reset"))
+	assert.Equal(t, "1200", res.Header().Get("Retry-After"))
+
+	problem := new(models.Problem)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), problem))
+	assert.Equal(t, apperrors.ErrRateLimited.Code, problem.Code)
+
+	// other clients have their own limit.
+	res = signup("192.0.2.21:4000")
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, "2", res.Header().Get("X-RateLimit-Remaining"))
+
+	// authenticated requests are limited per user.
+	token := loginHelper("clayton@gmail.com", "Hello@123123")
+	res = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/gicicm/users?limit=1", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "1000", res.Header().Get("X-RateLimit-Limit"))
+
+	// invalid credentials are limited per client ip before they are checked.
+	for i := 999; i >= 998; i-- {
+		res = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/gicicm/users?limit=1", nil)
+		req.RemoteAddr = "192.0.2.22:4000"
+		req.Header.Add("Authorization", "Bearer guessed")
+		router.ServeHTTP(res, req)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Equal(t, strconv.Itoa(i), res.Header().Get("X-RateLimit-Remaining"))
+	}
+}
+
+func TestController_RateLimit_ForwardedFor(t *testing.T) {
+	signup := func(remoteAddr string, forwardedFor string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest(
+			"POST",
+			"/gicicm/auth/signup",
+			bytes.NewReader([]byte(`{"email":"forwarded@mail.com"}`)))
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.Header.Set("X-Real-Ip", forwardedFor)
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	// the headers are ignored when the peer is not a trusted proxy,
+	// spoofing them does not reset the bucket.
+	for i := 0; i < 3; i++ {
+		res := signup("192.0.2.30:4000", fmt.Sprintf("198.51.100.%d", i))
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	}
+	res := signup("192.0.2.30:4000", "198.51.100.99")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+
+	// behind a trusted proxy the client is the address appended by the proxy,
+	// the entries set by the client are ignored.
+	for i := 0; i < 3; i++ {
+		res = signup("10.0.0.1:4000", fmt.Sprintf("198.51.100.%d, 203.0.113.5", i))
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	}
+	res = signup("10.0.0.1:4000", "198.51.100.99, 203.0.113.5")
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+
+	// other clients behind the proxy have their own limit.
+	res = signup("10.0.0.1:4000", "203.0.113.6")
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+}
+
+func TestController_MFA(t *testing.T) {
+	email := "mfa@mail.com"
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(
+		"POST",
+		"/gicicm/auth/signup",
+		bytes.NewReader([]byte(fmt.Sprintf(`{"email":"%s","name":"mfa user","password":"Hello@123123"}`, email))))
+	router.ServeHTTP(res, req)
+	require.Equal(t, http.StatusCreated, res.Code)
+
+	token := loginHelper(email, "Hello@123123")
+
+	res = mfaHelper("enable", token, `{"code":"123456"}`)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, problemHelper(400, apperrors.ErrMFANotEnrolled, "/gicicm/auth/mfa/enable"), res.Body.String())
+
+	res = mfaHelper("enroll", token, "")
+	require.Equal(t, http.StatusOK, res.Code)
+	enrollment := new(models.MFAEnrollment)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), enrollment))
+	assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/gicicm:mfa@mail.com?"))
+	assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+
+	// the secret is encrypted in the database.
+	var stored string
+	require.NoError(t, database.QueryRow(
+		"SELECT m.secret FROM user_mfa m JOIN users u ON u.id=m.user_id WHERE u.email='mfa@mail.com'").Scan(&stored))
+	assert.True(t, strings.HasPrefix(stored, "v1:"))
+	assert.NotContains(t, stored, enrollment.Secret)
+
+	// a code of another time step is rejected.
+	code, _ := totp.Code(enrollment.Secret, totp.Counter(time.Now())-10)
+	res = mfaHelper("enable", token, fmt.Sprintf(`{"code":"%s"}`, code))
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	now := totp.Counter(time.Now())
+	code, _ = totp.Code(enrollment.Secret, now)
+	res = mfaHelper("enable", token, fmt.Sprintf(`{"code":"%s"}`, code))
+	require.Equal(t, http.StatusOK, res.Code)
+	recovery := new(models.RecoveryCodes)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), recovery))
+	assert.Len(t, recovery.RecoveryCodes, 10)
+
+	res = mfaHelper("enroll", token, "")
+	assert.Equal(t, http.StatusConflict, res.Code)
+
+	// login now asks for a second factor.
+	res = loginResponseHelper(email, "Hello@123123", "")
+	require.Equal(t, http.StatusOK, res.Code)
+	challenge := new(models.MFAChallenge)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), challenge))
+	assert.Equal(t, models.MFAStatusPending, challenge.Status)
+	assert.Equal(t, int64(60), challenge.ExpiresIn)
+	assert.NotContains(t, res.Body.String(), "access_token")
+
+	res = mfaHelper("verify", "", fmt.Sprintf(`{"mfa_token":"%s"}`, challenge.MFAToken))
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+
+	// the code used for the enrolment can not be replayed.
+	res = mfaHelper("verify", "", fmt.Sprintf(`{"mfa_token":"%s","code":"%s"}`, challenge.MFAToken, code))
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, problemHelper(401, apperrors.ErrInvalidMFACode, "/gicicm/auth/mfa/verify"), res.Body.String())
+
+	code, _ = totp.Code(enrollment.Secret, now+1)
+	res = mfaHelper("verify", "", fmt.Sprintf(`{"mfa_token":"%s","code":"%s"}`, challenge.MFAToken, code))
+	require.Equal(t, http.StatusOK, res.Code)
+	tokens := new(models.TokenPair)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), tokens))
+	assert.NotEmpty(t, tokens.AccessToken)
+
+	// a challenge completes a single login.
+	res = mfaHelper("verify", "", fmt.Sprintf(`{"mfa_token":"%s","code":"%s"}`, challenge.MFAToken, code))
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	assert.Equal(t, problemHelper(401, apperrors.ErrInvalidMFAToken, "/gicicm/auth/mfa/verify"), res.Body.String())
+
+	// recovery codes can be used once, in any case and without the dash.
+	recoveryCode := strings.ToUpper(strings.Replace(recovery.RecoveryCodes[0], "-", "", 1))
+	for _, expected := range []int{http.StatusOK, http.StatusUnauthorized} {
+		res = loginResponseHelper(email, "Hello@123123", "")
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), challenge))
+
+		res = mfaHelper("verify", "", fmt.Sprintf(`{"mfa_token":"%s","recovery_code":"%s"}`, challenge.MFAToken, recoveryCode))
+		assert.Equal(t, expected, res.Code)
+	}
+
+	// wrong codes are counted against the account, the wrong recovery code above was the
+	// first failure and new challenges do not reset the count.
+	for _, expected := range []int{http.StatusUnauthorized, http.StatusLocked} {
+		res = loginResponseHelper(email, "Hello@123123", "")
+		require.Equal(t, http.StatusOK, res.Code)
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), challenge))
+
+		res = mfaHelper("verify", "", fmt.Sprintf(`{"mfa_token":"%s","recovery_code":"wrong"}`, challenge.MFAToken))
+		assert.Equal(t, expected, res.Code)
+	}
+
+	res = loginResponseHelper(email, "Hello@123123", "")
+	assert.Equal(t, http.StatusLocked, res.Code)
+}
+
+func TestController_OIDC(t *testing.T) {
+	defer func() {
+		identityProvider.Modify = nil
+	}()
+
+	res := oidcHelper("GET", "/gicicm/auth/oidc/unknown/login", "")
+	assert.Equal(t, problemHelper(404, apperrors.ErrProviderNotFound, "/gicicm/auth/oidc/unknown/login"), res.Body.String())
+
+	// the first login provisions a user with the verified email of the identity.
+	identityProvider.SetIdentity(oidctest.Identity{Subject: "oidc-1", Email: "oidc@mail.com", EmailVerified: true, Name: "oidc user"})
+	res = oidcLoginHelper(t, oidcAuthorizeHelper(t))
+	require.Equal(t, http.StatusOK, res.Code)
+	claims := oidcClaimsHelper(t, res)
+	assert.Equal(t, "oidc@mail.com", claims.Email)
+	assert.Equal(t, []string{models.RoleUser}, claims.Roles)
+
+	// the next logins find the linked user.
+	callback := oidcCallbackHelper(t, oidcAuthorizeHelper(t))
+	res = oidcHelper("GET", callback, "")
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "oidc@mail.com", oidcClaimsHelper(t, res).Email)
+
+	// a state completes a single login.
+	res = oidcHelper("GET", callback, "")
+	path := strings.Split(callback, "?")[0]
+	assert.Equal(t, problemHelper(400, apperrors.ErrInvalidOIDCState, path), res.Body.String())
+
+	// a state is only accepted from the browser it was issued to, the callback
+	// of a login started by someone else does not log the user in.
+	callback = oidcCallbackHelper(t, oidcAuthorizeHelper(t))
+	require.NotNil(t, oidcBrowserCookie)
+	assert.True(t, oidcBrowserCookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, oidcBrowserCookie.SameSite)
+	assert.Equal(t, "/gicicm/auth/oidc", oidcBrowserCookie.Path)
+	oidcBrowserCookie = nil
+	res = oidcHelper("GET", callback, "")
+	assert.Equal(t, problemHelper(400, apperrors.ErrInvalidOIDCState, path), res.Body.String())
+
+	callback = oidcCallbackHelper(t, oidcAuthorizeHelper(t))
+	oidcAuthorizeHelper(t)
+	res = oidcHelper("GET", callback, "")
+	assert.Equal(t, problemHelper(400, apperrors.ErrInvalidOIDCState, path), res.Body.String())
+
+	res = oidcHelper("GET", path, "")
+	assert.Equal(t, problemHelper(400, apperrors.ErrValidation, path,
+		apperrors.FieldError{Field: "code", Message: "is required"},
+		apperrors.FieldError{Field: "state", Message: "is required"}), res.Body.String())
+
+	res = oidcHelper("GET", path+"?error=access_denied", "")
+	assert.Equal(t, problemHelper(401, apperrors.ErrOIDCLoginFailed, path), res.Body.String())
+
+	// ID tokens issued for another client or request are rejected.
+	for _, modify := range []func(token *oidc.IDToken){
+		func(token *oidc.IDToken) { token.Audience = oidc.Audience{"other"} },
+		func(token *oidc.IDToken) { token.Nonce = "other" },
+		func(token *oidc.IDToken) { token.ExpiresAt = time.Now().Add(-time.Hour).Unix() },
+	} {
+		identityProvider.Modify = modify
+		res = oidcLoginHelper(t, oidcAuthorizeHelper(t))
+		assert.Equal(t, problemHelper(401, apperrors.ErrOIDCLoginFailed, path), res.Body.String())
+	}
+	identityProvider.Modify = nil
+
+	// existing users are never linked by email and unverified emails are not provisioned.
+	require.Equal(t, http.StatusCreated, signupHelper("link@mail.com", "Hello@123123").Code)
+	identityProvider.SetIdentity(oidctest.Identity{Subject: "oidc-2", Email: "link@mail.com", EmailVerified: true})
+	res = oidcLoginHelper(t, oidcAuthorizeHelper(t))
+	assert.Equal(t, problemHelper(403, apperrors.ErrIdentityNotLinked, path), res.Body.String())
+
+	identityProvider.SetIdentity(oidctest.Identity{Subject: "oidc-3", Email: "unverified@mail.com"})
+	res = oidcLoginHelper(t, oidcAuthorizeHelper(t))
+	assert.Equal(t, problemHelper(403, apperrors.ErrIdentityNotLinked, path), res.Body.String())
+
+	// emails signup would reject are not provisioned.
+	for _, email := range []string{strings.Repeat("a", 40) + "@mail.com", "not-an-email"} {
+		identityProvider.SetIdentity(oidctest.Identity{Subject: "oidc-5", Email: email, EmailVerified: true})
+		res = oidcLoginHelper(t, oidcAuthorizeHelper(t))
+		assert.Equal(t, problemHelper(403, apperrors.ErrIdentityNotLinked, path), res.Body.String())
+	}
+
+	// a signed in user links the identity and is logged in by the callback.
+	token := loginHelper("link@mail.com", "Hello@123123")
+	res = oidcHelper("POST", "/gicicm/auth/oidc/stub/link", token)
+	require.Equal(t, http.StatusOK, res.Code)
+	authorization := new(models.OIDCAuthorization)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), authorization))
+
+	identityProvider.SetIdentity(oidctest.Identity{Subject: "oidc-2", Email: "other@mail.com"})
+	res = oidcHelper("GET", oidcCallbackHelper(t, authorization.AuthorizationURL), "")
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "link@mail.com", oidcClaimsHelper(t, res).Email)
+
+	res = oidcLoginHelper(t, oidcAuthorizeHelper(t))
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "link@mail.com", oidcClaimsHelper(t, res).Email)
+
+	// an identity is linked to a single user.
+	identityProvider.SetIdentity(oidctest.Identity{Subject: "oidc-1"})
+	res = oidcHelper("POST", "/gicicm/auth/oidc/stub/link", token)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), authorization))
+	res = oidcHelper("GET", oidcCallbackHelper(t, authorization.AuthorizationURL), "")
+	assert.Equal(t, problemHelper(409, apperrors.ErrIdentityAlreadyLinked, path), res.Body.String())
+
+	// the callback of a link must be made by the browser that started it, an identity
+	// can not be linked to the account of a user who follows someone else's link.
+	identityProvider.SetIdentity(oidctest.Identity{Subject: "oidc-4"})
+	res = oidcHelper("POST", "/gicicm/auth/oidc/stub/link", token)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), authorization))
+	callback = oidcCallbackHelper(t, authorization.AuthorizationURL)
+	oidcBrowserCookie = nil
+	res = oidcHelper("GET", callback, "")
+	assert.Equal(t, problemHelper(400, apperrors.ErrInvalidOIDCState, path), res.Body.String())
+}
+
+func TestController_OAuth(t *testing.T) {
+	admin := loginHelper("clayton@test.com", "hello123")
+	user := loginHelper("clayton@gmail.com", "Hello@123123")
+
+	// clients are registered by admins with scopes the admin has.
+	res := oauthJSONHelper("POST", "/gicicm/oauth/clients", user, `{}`)
+	assert.Equal(t, problemHelper(403, apperrors.ErrPermissionDenied, "/gicicm/oauth/clients"), res.Body.String())
+
+	res = oauthJSONHelper("POST", "/gicicm/oauth/clients", admin,
+		`{"name":" ","redirect_uris":["callback"],"grant_types":["password","client_credentials"],"scopes":["unknown"],"public":true}`)
+	assert.Equal(t, problemHelper(400, apperrors.ErrValidation, "/gicicm/oauth/clients",
+		apperrors.FieldError{Field: "name", Message: clientNameValidationError},
+		apperrors.FieldError{Field: "redirect_uris", Message: clientRedirectURIValidationError + "callback"},
+		apperrors.FieldError{Field: "grant_types", Message: clientGrantTypeValidationError + "password"},
+		apperrors.FieldError{Field: "grant_types", Message: clientPublicGrantValidationError},
+		apperrors.FieldError{Field: "scopes", Message: clientScopeValidationError + "unknown"}), res.Body.String())
+
+	web := oauthClientHelper(t, admin, `{"name":"web","redirect_uris":["https://web.test/callback"],`+
+		`"grant_types":["authorization_code","refresh_token"],"scopes":["users:list","users:delete"]}`)
+	require.NotEmpty(t, web.ClientSecret)
+	spa := oauthClientHelper(t, admin, `{"name":"spa","redirect_uris":["https://spa.test/"],`+
+		`"grant_types":["authorization_code"],"scopes":["users:list"],"public":true}`)
+	require.Empty(t, spa.ClientSecret)
+
+	// unknown clients and redirect uris are reported to the user, not redirected to.
+	verifier, err := oidc.NewCodeVerifier()
+	require.NoError(t, err)
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {web.ClientID},
+		"redirect_uri":          {"https://web.test/callback"},
+		"scope":                 {"users:list"},
+		"state":                 {"xyz"},
+		"code_challenge":        {oidc.CodeChallenge(verifier)},
+		"code_challenge_method": {oidc.ChallengeMethod},
+	}
+	authorize := func(modify func(url.Values)) *httptest.ResponseRecorder {
+		q := url.Values{}
+		for key, values := range query {
+			q[key] = values
+		}
+		if modify != nil {
+			modify(q)
+		}
+		return oauthJSONHelper("GET", "/gicicm/oauth/authorize?"+q.Encode(), user, "")
+	}
+
+	res = authorize(func(q url.Values) { q.Set("client_id", "unknown") })
+	assert.Equal(t, "invalid_request", oauthErrorHelper(t, res, http.StatusBadRequest).Code)
+	res = authorize(func(q url.Values) { q.Set("redirect_uri", "https://evil.test/callback") })
+	assert.Equal(t, "invalid_request", oauthErrorHelper(t, res, http.StatusBadRequest).Code)
+
+	// other errors are sent back to the client with the state.
+	res = authorize(func(q url.Values) { q.Set("scope", "users:list users:delete") })
+	redirect := oauthRedirectHelper(t, res, "https://web.test/callback")
+	assert.Equal(t, "invalid_scope", redirect.Get("error"))
+	assert.Equal(t, "xyz", redirect.Get("state"))
+	res = authorize(func(q url.Values) { q.Del("code_challenge") })
+	assert.Equal(t, "invalid_request", oauthRedirectHelper(t, res, "https://web.test/callback").Get("error"))
+	res = authorize(func(q url.Values) { q.Set("response_type", "token") })
+	assert.Equal(t, "unsupported_response_type", oauthRedirectHelper(t, res, "https://web.test/callback").Get("error"))
+
+	// the user consents to the scopes once.
+	res = authorize(nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	authorization := new(models.Authorization)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), authorization))
+	assert.Equal(t, &models.ConsentPrompt{ClientID: web.ClientID, ClientName: "web", Scopes: []string{"users:list"}},
+		authorization.Consent)
+
+	consent := func(answer string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{
+			"response_type": "code", "client_id": web.ClientID, "scope": "users:list", "state": "xyz",
+			"code_challenge": oidc.CodeChallenge(verifier), "code_challenge_method": oidc.ChallengeMethod,
+			"consent": answer,
+		})
+		return oauthJSONHelper("POST", "/gicicm/oauth/authorize", user, string(body))
+	}
+	assert.Equal(t, "invalid_request", oauthErrorHelper(t, consent("maybe"), http.StatusBadRequest).Code)
+	assert.Equal(t, "access_denied", oauthRedirectHelper(t, consent("deny"), "https://web.test/callback").Get("error"))
+
+	redirect = oauthRedirectHelper(t, consent("approve"), "https://web.test/callback")
+	assert.Equal(t, "xyz", redirect.Get("state"))
+	code := redirect.Get("code")
+	require.NotEmpty(t, code)
+
+	otherCode := oauthRedirectHelper(t, authorize(nil), "https://web.test/callback").Get("code")
+	require.NotEmpty(t, otherCode)
+
+	// codes are exchanged once by their client with the code verifier.
+	exchange := func(client *models.CreatedClient, code, verifier string) *httptest.ResponseRecorder {
+		return oauthFormHelper("/gicicm/oauth/token", client, url.Values{
+			"grant_type":    {models.GrantAuthorizationCode},
+			"code":          {code},
+			"redirect_uri":  {"https://web.test/callback"},
+			"code_verifier": {verifier},
+		})
+	}
+	assert.Equal(t, "invalid_grant", oauthErrorHelper(t, exchange(web, otherCode, "wrong-verifier"), http.StatusBadRequest).Code)
+	assert.Equal(t, "invalid_grant", oauthErrorHelper(t, exchange(web, otherCode, verifier), http.StatusBadRequest).Code)
+
+	// the redirect uri of the authorization request has to be sent again.
+	otherCode = oauthRedirectHelper(t, authorize(nil), "https://web.test/callback").Get("code")
+	res = oauthFormHelper("/gicicm/oauth/token", web, url.Values{
+		"grant_type":    {models.GrantAuthorizationCode},
+		"code":          {otherCode},
+		"code_verifier": {verifier},
+	})
+	assert.Equal(t, "invalid_grant", oauthErrorHelper(t, res, http.StatusBadRequest).Code)
+
+	wrongSecret := &models.CreatedClient{Client: web.Client, ClientSecret: "wrong"}
+	res = exchange(wrongSecret, code, verifier)
+	assert.Equal(t, "invalid_client", oauthErrorHelper(t, res, http.StatusUnauthorized).Code)
+	assert.Equal(t, clientAuthenticateHeader, res.Header().Get("WWW-Authenticate"))
+
+	res = exchange(web, code, verifier)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "no-store", res.Header().Get("Cache-Control"))
+	tokens := new(models.TokenPair)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), tokens))
+	assert.Equal(t, "users:list", tokens.Scope)
+	require.NotEmpty(t, tokens.RefreshToken)
+
+	claims := new(models.Claims)
+	_, err = signingKeys.Parse(tokens.AccessToken, claims)
+	require.NoError(t, err)
+	assert.Equal(t, "clayton@gmail.com", claims.Email)
+	assert.Equal(t, web.ClientID, claims.ClientID)
+	assert.Equal(t, "invalid_grant", oauthErrorHelper(t, exchange(web, code, verifier), http.StatusBadRequest).Code)
+
+	// the token only grants its scope and no account operations.
+	assert.Equal(t, http.StatusOK, sessionsHelper("GET", "/gicicm/users", tokens.AccessToken).Code)
+	res = sessionsHelper("GET", "/gicicm/auth/sessions", tokens.AccessToken)
+	assert.Equal(t, problemHelper(403, apperrors.ErrAccessTokenRequired, "/gicicm/auth/sessions"), res.Body.String())
+	res = oauthJSONHelper("PATCH", "/gicicm/users/clayton@gmail.com", tokens.AccessToken, `{"name":"delegated"}`)
+	assert.Equal(t, http.StatusForbidden, res.Code)
+
+	// the user sees the access of the client in the session list.
+	res = sessionsHelper("GET", "/gicicm/auth/sessions", user)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), fmt.Sprintf(`"client_id":"%s"`, web.ClientID))
+
+	// refresh tokens are rotated by their client only.
+	res = refreshHelper(tokens.RefreshToken)
+	assert.Equal(t, problemHelper(401, apperrors.ErrInvalidRefreshToken, "/gicicm/auth/refresh"), res.Body.String())
+	refresh := func(refreshToken, scope string) *httptest.ResponseRecorder {
+		return oauthFormHelper("/gicicm/oauth/token", web, url.Values{
+			"grant_type":    {models.GrantRefreshToken},
+			"refresh_token": {refreshToken},
+			"scope":         {scope},
+		})
+	}
+	assert.Equal(t, "invalid_scope", oauthErrorHelper(t, refresh(tokens.RefreshToken, "users:delete"), http.StatusBadRequest).Code)
+
+	res = refresh(tokens.RefreshToken, "")
+	require.Equal(t, http.StatusOK, res.Code)
+	refreshed := new(models.TokenPair)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), refreshed))
+	assert.Equal(t, "users:list", refreshed.Scope)
+
+	// confidential clients introspect tokens, refresh tokens only their own.
+	introspect := func(client *models.CreatedClient, token string) *models.Introspection {
+		res := oauthFormHelper("/gicicm/oauth/introspect", client, url.Values{"token": {token}})
+		require.Equal(t, http.StatusOK, res.Code)
+		introspection := new(models.Introspection)
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), introspection))
+		return introspection
+	}
+	introspection := introspect(web, refreshed.AccessToken)
+	assert.True(t, introspection.Active)
+	assert.Equal(t, web.ClientID, introspection.ClientID)
+	assert.Equal(t, "clayton@gmail.com", introspection.Username)
+	assert.Equal(t, "users:list", introspection.Scope)
+
+	introspection = introspect(web, user)
+	assert.True(t, introspection.Active)
+	assert.Empty(t, introspection.ClientID)
+
+	introspection = introspect(web, refreshed.RefreshToken)
+	assert.True(t, introspection.Active)
+	assert.Equal(t, "refresh_token", introspection.TokenType)
+	assert.Equal(t, &models.Introspection{Active: false}, introspect(web, tokens.RefreshToken))
+	assert.Equal(t, &models.Introspection{Active: false}, introspect(web, "unknown"))
+
+	res = oauthFormHelper("/gicicm/oauth/introspect", spa, url.Values{"token": {refreshed.AccessToken}})
+	assert.Equal(t, "unauthorized_client", oauthErrorHelper(t, res, http.StatusBadRequest).Code)
+
+	// clients revoke their own tokens, revoking a refresh token ends the session.
+	res = oauthFormHelper("/gicicm/oauth/revoke", spa, url.Values{"token": {refreshed.AccessToken}})
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.True(t, introspect(web, refreshed.AccessToken).Active)
+
+	res = oauthFormHelper("/gicicm/oauth/revoke", web, url.Values{"token": {refreshed.AccessToken}})
+	assert.Equal(t, `{"result":"Successfully Revoked"}`, res.Body.String())
+	assert.False(t, introspect(web, refreshed.AccessToken).Active)
+
+	res = oauthFormHelper("/gicicm/oauth/revoke", web, url.Values{
+		"token": {refreshed.RefreshToken}, "token_type_hint": {"refresh_token"},
+	})
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "invalid_grant", oauthErrorHelper(t, refresh(refreshed.RefreshToken, ""), http.StatusBadRequest).Code)
+
+	// clients get tokens of their own with their credentials.
+	service := oauthClientHelper(t, admin, `{"name":"service","grant_types":["client_credentials"],"scopes":["users:list"]}`)
+	res = oauthFormHelper("/gicicm/oauth/token", nil, url.Values{
+		"grant_type":    {models.GrantClientCredentials},
+		"client_id":     {service.ClientID},
+		"client_secret": {service.ClientSecret},
+	})
+	require.Equal(t, http.StatusOK, res.Code)
+	serviceTokens := new(models.TokenPair)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), serviceTokens))
+	assert.Empty(t, serviceTokens.RefreshToken)
+	assert.Equal(t, "users:list", serviceTokens.Scope)
+
+	assert.Equal(t, http.StatusOK, sessionsHelper("GET", "/gicicm/users", serviceTokens.AccessToken).Code)
+	assert.Equal(t, http.StatusForbidden, sessionsHelper("DELETE", "/gicicm/users/test@mail.com", serviceTokens.AccessToken).Code)
+
+	res = oauthFormHelper("/gicicm/oauth/token", web, url.Values{"grant_type": {models.GrantClientCredentials}})
+	assert.Equal(t, "unauthorized_client", oauthErrorHelper(t, res, http.StatusBadRequest).Code)
+	res = oauthFormHelper("/gicicm/oauth/token", web, url.Values{"grant_type": {"password"}})
+	assert.Equal(t, "unsupported_grant_type", oauthErrorHelper(t, res, http.StatusBadRequest).Code)
+
+	// clients are listed without their secrets, deleting one rejects its tokens.
+	res = sessionsHelper("GET", "/gicicm/oauth/clients", admin)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.NotContains(t, res.Body.String(), web.ClientSecret)
+	assert.Contains(t, res.Body.String(), service.ClientID)
+
+	path := "/gicicm/oauth/clients/" + service.ClientID
+	assert.Equal(t, `{"result":"Successfully Deleted"}`, sessionsHelper("DELETE", path, admin).Body.String())
+	assert.Equal(t, problemHelper(404, apperrors.ErrClientNotFound, path), sessionsHelper("DELETE", path, admin).Body.String())
+	assert.Equal(t, http.StatusUnauthorized, sessionsHelper("GET", "/gicicm/users", serviceTokens.AccessToken).Code)
+}
+
+// problemHelper returns the problem body rendered for a domain error.
+func problemHelper(status int, err *apperrors.Error, instance string, fields ...apperrors.FieldError) string {
+	body, _ := json.Marshal(&models.Problem{
+		Type:     "urn:gicicm:error:" + err.Code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   err.Message,
+		Instance: instance,
+		Code:     err.Code,
+		Errors:   fields,
+	})
+	return string(body)
+}
+
+func passwordHelper(action, reqBody string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(
+		"POST",
+		fmt.Sprintf("/gicicm/auth/password/%s", action),
+		bytes.NewReader([]byte(reqBody)))
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func refreshHelper(refreshToken string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(
+		"POST",
+		"/gicicm/auth/refresh",
+		bytes.NewReader([]byte(fmt.Sprintf(`{"refresh_token":"%s"}`, refreshToken))))
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func loginResponseHelper(email, password, remoteAddr string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(
+		"POST",
+		"/gicicm/auth/login",
+		bytes.NewReader([]byte(fmt.Sprintf(`{"email":"%s","password":"%s"}`, email, password))))
+	req.RemoteAddr = remoteAddr
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func signupHelper(email, password string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(
+		"POST",
+		"/gicicm/auth/signup",
+		bytes.NewReader([]byte(fmt.Sprintf(`{"email":"%s","name":"test user","password":"%s"}`, email, password))))
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func sessionsHelper(method, path, token string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func unlockHelper(email, token string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(
+		"DELETE",
+		fmt.Sprintf("/gicicm/users/%s/lock", email),
+		nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func mfaHelper(action, token, reqBody string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(
+		"POST",
+		fmt.Sprintf("/gicicm/auth/mfa/%s", action),
+		bytes.NewReader([]byte(reqBody)))
+	if token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func oidcHelper(method, path, token string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	if oidcBrowserCookie != nil {
+		req.AddCookie(oidcBrowserCookie)
+	}
+	router.ServeHTTP(res, req)
+
+	// keep the state cookie like the browser of the user.
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			oidcBrowserCookie = cookie
+			if cookie.MaxAge < 0 {
+				oidcBrowserCookie = nil
+			}
+		}
+	}
+	return res
+}
+
+// oidcAuthorizeHelper starts a login with the stub provider
+// and returns the authorization url it redirects to.
+func oidcAuthorizeHelper(t *testing.T) string {
+	res := oidcHelper("GET", "/gicicm/auth/oidc/stub/login", "")
+	require.Equal(t, http.StatusFound, res.Code)
+	return res.Header().Get("Location")
+}
+
+// oidcCallbackHelper follows an authorization url to the stub provider
+// and returns the path of the callback it redirects back to.
+func oidcCallbackHelper(t *testing.T, authURL string) string {
+	require.True(t, strings.HasPrefix(authURL, identityProvider.URL+"/authorize?"))
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	idpRes, err := client.Get(authURL)
+	require.NoError(t, err)
+	idpRes.Body.Close()
+	require.Equal(t, http.StatusFound, idpRes.StatusCode)
+
+	location := idpRes.Header.Get("Location")
+	require.True(t, strings.HasPrefix(location, oidcRedirectURL+"?"))
+	return strings.TrimPrefix(location, "http://gicicm.test")
+}
+
+// oidcLoginHelper completes a login with an authorization url.
+func oidcLoginHelper(t *testing.T, authURL string) *httptest.ResponseRecorder {
+	return oidcHelper("GET", oidcCallbackHelper(t, authURL), "")
+}
+
+// oidcClaimsHelper returns the claims of the access token of a login.
+func oidcClaimsHelper(t *testing.T, res *httptest.ResponseRecorder) *models.Claims {
+	tokens := new(models.TokenPair)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), tokens))
+	claims := new(models.Claims)
+	_, err := signingKeys.Parse(tokens.AccessToken, claims)
+	require.NoError(t, err)
+	return claims
+}
+
+func oauthJSONHelper(method, path, token, reqBody string) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, strings.NewReader(reqBody))
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	router.ServeHTTP(res, req)
+	return res
+}
+
+// oauthFormHelper posts a form to an OAuth endpoint, confidential
+// clients authenticate with basic authentication.
+func oauthFormHelper(path string, client *models.CreatedClient, form url.Values) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	if client != nil && client.ClientSecret == "" {
+		form.Set("client_id", client.ClientID)
+	}
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	if client != nil && client.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(client.ClientID), url.QueryEscape(client.ClientSecret))
+	}
+	router.ServeHTTP(res, req)
+	return res
+}
+
+// oauthClientHelper registers a client as admin.
+func oauthClientHelper(t *testing.T, admin, reqBody string) *models.CreatedClient {
+	res := oauthJSONHelper("POST", "/gicicm/oauth/clients", admin, reqBody)
+	require.Equal(t, http.StatusCreated, res.Code)
+	client := new(models.CreatedClient)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), client))
+	return client
+}
+
+// oauthRedirectHelper returns the query of the redirect of an authorization response.
+func oauthRedirectHelper(t *testing.T, res *httptest.ResponseRecorder, redirectURI string) url.Values {
+	require.Equal(t, http.StatusOK, res.Code)
+	authorization := new(models.Authorization)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), authorization))
+	require.True(t, strings.HasPrefix(authorization.RedirectTo, redirectURI+"?"), authorization.RedirectTo)
+
+	redirect, err := url.Parse(authorization.RedirectTo)
+	require.NoError(t, err)
+	return redirect.Query()
+}
+
+// oauthErrorHelper returns the error of an OAuth error response.
+func oauthErrorHelper(t *testing.T, res *httptest.ResponseRecorder, status int) *models.OAuthError {
+	require.Equal(t, status, res.Code, res.Body.String())
+	oauthErr := new(models.OAuthError)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), oauthErr))
+	return oauthErr
+}
+
+func loginHelper(email, password string) string {
+	return loginTokensHelper(email, password).AccessToken
+}
+
+func loginTokensHelper(email, password string) *models.TokenPair {
+	reqBody := fmt.Sprintf(
+		`{
+			"email":"%s",
+			"password":"%s"
+		}`,
+		email, password)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(
+		"POST",
+		"/gicicm/auth/login",
+		bytes.NewReader([]byte(reqBody)))
+	router.ServeHTTP(res, req)
+
+	tokens := new(models.TokenPair)
+	_ = json.Unmarshal(res.Body.Bytes(), tokens)
+
+	return tokens
+}
+
+// seedHelper migrates the database and inserts the test data.
+func seedHelper(database *sql.DB, dbType string) error {
+	migrator, err := migrations.NewMigrator(database, dbType)
+	if err != nil {
+		return err
+	}
+
+	_, err = migrator.Up(context.Background())
+	if err != nil {
+		return err
+	}
+
+	seed, err := ioutil.ReadFile("../test_data/seed.sql")
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range strings.Split(string(seed), ";\n") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		_, err = database.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func createUserHelper() error {
@@ -0,0 +1,105 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/logger"
+	"gicicm/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// problemTypePrefix prefixes the error code to build the problem type URI.
+const problemTypePrefix = "urn:gicicm:error:"
+
+// kindStatuses maps the kinds of domain errors to http statuses.
+var kindStatuses = []struct {
+	kind   error
+	status int
+}{
+	{apperrors.Validation, http.StatusBadRequest},
+	{apperrors.Unauthorized, http.StatusUnauthorized},
+	{apperrors.Forbidden, http.StatusForbidden},
+	{apperrors.NotFound, http.StatusNotFound},
+	{apperrors.Conflict, http.StatusConflict},
+	{apperrors.Locked, http.StatusLocked},
+	{apperrors.RateLimited, http.StatusTooManyRequests},
+}
+
+// ErrorHandler is a middleware that renders the error of an aborted
+// request as an RFC 7807 problem, errors that are not domain errors
+// are logged and rendered as an internal error so that their
+// message never reaches the client.
+func ErrorHandler(c *gin.Context) {
+	c.Next()
+
+	if len(c.Errors) == 0 || c.Writer.Written() {
+		return
+	}
+
+	err := c.Errors.Last().Err
+	appErr := apperrors.As(err)
+	status := statusOf(appErr)
+	logError(c, appErr, status, err)
+
+	problem := &models.Problem{
+		Type:     problemTypePrefix + appErr.Code,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   appErr.Message,
+		Instance: c.Request.URL.Path,
+		Code:     appErr.Code,
+		Errors:   appErr.Fields,
+	}
+
+	if appErr.RetryAfter > 0 {
+		problem.RetryAfter = retryAfterSeconds(appErr.RetryAfter)
+		c.Header("Retry-After", strconv.FormatInt(problem.RetryAfter, 10))
+	}
+
+	c.Header("Content-Type", "application/problem+json")
+	c.JSON(status, problem)
+}
+
+// logError logs the error of a request with its cause,
+// only errors of the server are logged as errors.
+func logError(c *gin.Context, appErr *apperrors.Error, status int, err error) {
+	log := logger.FromContext(c.Request.Context())
+	if status >= http.StatusInternalServerError {
+		log.Error("request failed", zap.String("code", appErr.Code), zap.Error(err))
+	} else {
+		log.Info("request rejected", zap.String("code", appErr.Code), zap.Error(err))
+	}
+}
+
+// retryAfterSeconds rounds a duration up to whole seconds
+// so that clients never retry too early.
+func retryAfterSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// statusOf returns the http status for the kind of a domain error.
+func statusOf(err *apperrors.Error) int {
+	for _, ks := range kindStatuses {
+		if errors.Is(err, ks.kind) {
+			return ks.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// abort stops the request, the error is rendered by the ErrorHandler.
+func abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// NoRoute renders a problem for requests that match no route.
+func NoRoute(c *gin.Context) {
+	abort(c, apperrors.ErrRouteNotFound)
+}
@@ -0,0 +1,39 @@
+package endpoints
+
+import (
+	"net/http"
+
+	"gicicm/health"
+	"gicicm/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Live is an endpoint that tells orchestrators the process is up.
+func (ctrl *Controller) Live(c *gin.Context) {
+	response := make(map[string]interface{})
+	response["status"] = health.StatusUp
+	c.JSON(http.StatusOK, response)
+}
+
+// Ready is an endpoint that tells orchestrators whether
+// the service and its dependencies are ready to receive traffic.
+func (ctrl *Controller) Ready(c *gin.Context) {
+	ctx := c.Request.Context()
+	report, ready := ctrl.checker.Check(ctx)
+
+	if !ready {
+		for name, result := range report.Checks {
+			if result.Status != health.StatusUp {
+				logger.FromContext(ctx).Warn("dependency check failed", zap.String("check", name),
+					zap.Float64("latency_ms", result.LatencyMS), zap.String("error", result.Error))
+			}
+		}
+		logger.FromContext(ctx).Warn("not ready", zap.String("status", report.Status))
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
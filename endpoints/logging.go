@@ -0,0 +1,69 @@
+package endpoints
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gicicm/logger"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader is the header used to propagate the request id.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits the request ids accepted from clients,
+// anything else is replaced so it can not be used to forge log lines.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestLogger is a middleware that assigns a request id, or propagates
+// the one sent by the client, and binds a logger carrying the request id
+// and the route to the request context. Once the request is handled
+// a structured access log line is written with the same logger.
+func RequestLogger(c *gin.Context) {
+	start := time.Now()
+
+	requestID := c.GetHeader(RequestIDHeader)
+	if !validRequestID.MatchString(requestID) {
+		requestID = newRequestID()
+	}
+	c.Header(RequestIDHeader, requestID)
+
+	route := c.FullPath()
+	if route == "" {
+		route = unmatchedRoute
+	}
+
+	ctx := logger.WithContext(c.Request.Context(), logger.Log().With(
+		zap.String("request_id", requestID),
+		zap.String("route", route),
+	))
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	// handlers may have bound more fields, e.g. the user email.
+	logger.FromContext(c.Request.Context()).Info("request",
+		zap.String("method", c.Request.Method),
+		zap.String("path", c.Request.URL.Path),
+		zap.Int("status", c.Writer.Status()),
+		zap.Duration("latency", time.Since(start)),
+		zap.String("client_ip", clientIP(c)),
+	)
+}
+
+// newRequestID returns a random 128 bit hex encoded id.
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		// crypto/rand does not fail on supported platforms,
+		// fall back to the time to still correlate the lines.
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,37 @@
+package endpoints
+
+import (
+	"strconv"
+	"time"
+
+	"gicicm/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// unmatchedRoute labels requests that did not match any route,
+// using the raw path would make the label cardinality unbounded.
+const unmatchedRoute = "unmatched"
+
+// Instrument is a middleware that observes the duration
+// of every request by method, route template and status.
+func Instrument(c *gin.Context) {
+	start := time.Now()
+
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = unmatchedRoute
+	}
+
+	metrics.HTTPRequestDuration.
+		WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+		Observe(time.Since(start).Seconds())
+}
+
+// Metrics is an endpoint that exposes the metrics in the prometheus text format.
+func Metrics() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
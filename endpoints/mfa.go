@@ -0,0 +1,99 @@
+package endpoints
+
+import (
+	"net/http"
+
+	"gicicm/apperrors"
+	"gicicm/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EnrollMFA is an endpoint that starts the enrolment of a TOTP secret
+// for the authenticated user and returns its otpauth:// URI.
+func (ctrl *Controller) EnrollMFA(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	enrollment, err := ctrl.authProvider.EnrollMFA(ctx, metadata.Email)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// EnableMFA is an endpoint that finishes the enrolment with a code
+// and returns the recovery codes, they are only shown once.
+func (ctrl *Controller) EnableMFA(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	request := new(models.MFAEnableRequest)
+	err = c.ShouldBindJSON(request)
+	if err != nil {
+		abort(c, apperrors.ErrBadRequest.Wrap(err))
+		return
+	}
+
+	if request.Code == "" {
+		abort(c, apperrors.NewValidation(apperrors.FieldError{Field: "code", Message: "is required"}))
+		return
+	}
+
+	codes, err := ctrl.authProvider.EnableMFA(ctx, metadata.Email, request.Code)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+// VerifyMFA is an endpoint that completes a login by exchanging
+// an mfa challenge and a TOTP or recovery code for a pair of tokens.
+func (ctrl *Controller) VerifyMFA(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	request := new(models.MFAVerifyRequest)
+	err := c.ShouldBindJSON(request)
+	if err != nil {
+		abort(c, apperrors.ErrBadRequest.Wrap(err))
+		return
+	}
+
+	var fields []apperrors.FieldError
+	if request.MFAToken == "" {
+		fields = append(fields, apperrors.FieldError{Field: "mfa_token", Message: "is required"})
+	}
+	if request.Code == "" && request.RecoveryCode == "" {
+		fields = append(fields, apperrors.FieldError{Field: "code", Message: "code or recovery_code is required"})
+	}
+	err = apperrors.NewValidation(fields...)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	request.ClientIP = clientIP(c)
+	request.UserAgent = c.Request.UserAgent()
+
+	tokens, err := ctrl.authProvider.VerifyMFA(ctx, request)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
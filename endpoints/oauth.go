@@ -0,0 +1,244 @@
+package endpoints
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"gicicm/apperrors"
+	"gicicm/logger"
+	"gicicm/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+)
+
+const (
+	// oauthServerError is the OAuth error code of internal errors.
+	oauthServerError = "server_error"
+	// clientAuthenticateHeader asks clients to authenticate with basic authentication.
+	clientAuthenticateHeader = `Basic realm="gicicm"`
+)
+
+// messages for invalid OAuth requests.
+const (
+	consentValidationError         = "consent must be approve or deny"
+	tokenRequiredError             = "token is required"
+	clientAuthenticationError      = "the client must use a single authentication method"
+	clientCredentialsEncodingError = "the basic credentials are not form encoded"
+)
+
+// Authorize is the authorization endpoint (RFC 6749 section 3.1) for a signed
+// in user. It returns either the scopes the user is asked to consent to or
+// the redirect uri of the client the user agent is sent back to.
+func (ctrl *Controller) Authorize(c *gin.Context) {
+	request := new(models.AuthorizeRequest)
+	err := c.ShouldBindQuery(request)
+	if err != nil {
+		oauthError(c, apperrors.ErrInvalidOAuthRequest.Wrap(err))
+		return
+	}
+
+	ctrl.authorize(c, request)
+}
+
+// Consent is an endpoint that takes the answer of a signed in user
+// to the consent prompt of an authorization request.
+func (ctrl *Controller) Consent(c *gin.Context) {
+	request := new(models.AuthorizeRequest)
+	err := c.ShouldBindJSON(request)
+	if err != nil {
+		oauthError(c, apperrors.ErrInvalidOAuthRequest.Wrap(err))
+		return
+	}
+
+	if request.Consent != models.ConsentApprove && request.Consent != models.ConsentDeny {
+		oauthError(c, apperrors.ErrInvalidOAuthRequest.WithMessage(consentValidationError))
+		return
+	}
+
+	ctrl.authorize(c, request)
+}
+
+// authorize handles an authorization request of the current user, only
+// scopes that are permissions of the user can be granted to the client.
+func (ctrl *Controller) authorize(c *gin.Context, request *models.AuthorizeRequest) {
+	ctx := c.Request.Context()
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		oauthError(c, err)
+		return
+	}
+
+	request.Email = metadata.Email
+	request.UserScopes = permissionsOf(metadata.Roles)
+
+	ctx = logger.With(ctx, zap.String("client_id", request.ClientID))
+	c.Request = c.Request.WithContext(ctx)
+
+	authorization, err := ctrl.authProvider.Authorize(ctx, request)
+	if err != nil {
+		oauthError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, authorization)
+}
+
+// Token is the token endpoint of the OAuth clients (RFC 6749 section 3.2),
+// it issues tokens for the authorization_code, refresh_token and
+// client_credentials grants.
+func (ctrl *Controller) Token(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	request := new(models.TokenRequest)
+	err := c.ShouldBindWith(request, binding.FormPost)
+	if err != nil {
+		oauthError(c, apperrors.ErrInvalidOAuthRequest.Wrap(err))
+		return
+	}
+
+	request.Credentials, err = clientCredentials(c)
+	if err != nil {
+		oauthError(c, err)
+		return
+	}
+	request.ClientIP = clientIP(c)
+	request.UserAgent = c.Request.UserAgent()
+
+	ctx = logger.With(ctx, zap.String("client_id", request.Credentials.ClientID), zap.String("grant_type", request.GrantType))
+	c.Request = c.Request.WithContext(ctx)
+
+	tokens, err := ctrl.authProvider.Token(ctx, request)
+	if err != nil {
+		oauthError(c, err)
+		return
+	}
+
+	noStore(c)
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Introspect is an endpoint that describes a token to a confidential client (RFC 7662).
+func (ctrl *Controller) Introspect(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	request, err := bindTokenActionRequest(c)
+	if err != nil {
+		oauthError(c, err)
+		return
+	}
+
+	introspection, err := ctrl.authProvider.Introspect(ctx, request)
+	if err != nil {
+		oauthError(c, err)
+		return
+	}
+
+	noStore(c)
+	c.JSON(http.StatusOK, introspection)
+}
+
+// RevokeToken is an endpoint that revokes a token of a client (RFC 7009),
+// it succeeds for tokens that are invalid already.
+func (ctrl *Controller) RevokeToken(c *gin.Context) {
+	ctx := c.Request.Context()
+	response := make(map[string]interface{})
+
+	request, err := bindTokenActionRequest(c)
+	if err != nil {
+		oauthError(c, err)
+		return
+	}
+
+	err = ctrl.authProvider.RevokeToken(ctx, request)
+	if err != nil {
+		oauthError(c, err)
+		return
+	}
+
+	response["result"] = "Successfully Revoked"
+	c.JSON(http.StatusOK, response)
+}
+
+// bindTokenActionRequest binds the form of an introspection or a revocation request.
+func bindTokenActionRequest(c *gin.Context) (*models.TokenActionRequest, error) {
+	request := new(models.TokenActionRequest)
+	err := c.ShouldBindWith(request, binding.FormPost)
+	if err != nil {
+		return nil, apperrors.ErrInvalidOAuthRequest.Wrap(err)
+	}
+	if request.Token == "" {
+		return nil, apperrors.ErrInvalidOAuthRequest.WithMessage(tokenRequiredError)
+	}
+
+	request.Credentials, err = clientCredentials(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := logger.With(c.Request.Context(), zap.String("client_id", request.Credentials.ClientID))
+	c.Request = c.Request.WithContext(ctx)
+	return request, nil
+}
+
+// clientCredentials returns the credentials of a client from the basic
+// authorization header or from the client_id and client_secret of the form,
+// a client must not use both (RFC 6749 section 2.3.1).
+func clientCredentials(c *gin.Context) (models.ClientCredentials, error) {
+	credentials := models.ClientCredentials{
+		ClientID:     c.PostForm("client_id"),
+		ClientSecret: c.PostForm("client_secret"),
+	}
+
+	id, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		return credentials, nil
+	}
+	if credentials.ClientSecret != "" {
+		return credentials, apperrors.ErrInvalidOAuthRequest.WithMessage(clientAuthenticationError)
+	}
+
+	// the credentials are form encoded before they are base64 encoded.
+	id, err := url.QueryUnescape(id)
+	if err != nil {
+		return credentials, apperrors.ErrInvalidClient.WithMessage(clientCredentialsEncodingError)
+	}
+	secret, err = url.QueryUnescape(secret)
+	if err != nil {
+		return credentials, apperrors.ErrInvalidClient.WithMessage(clientCredentialsEncodingError)
+	}
+	if credentials.ClientID != "" && credentials.ClientID != id {
+		return credentials, apperrors.ErrInvalidOAuthRequest.WithMessage(clientAuthenticationError)
+	}
+
+	return models.ClientCredentials{ClientID: id, ClientSecret: secret}, nil
+}
+
+// noStore keeps responses with tokens out of caches (RFC 6749 section 5.1).
+func noStore(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+}
+
+// oauthError stops a request to an OAuth endpoint with an RFC 6749 error
+// response instead of a problem, internal errors are rendered as server_error
+// so that their message never reaches the client.
+func oauthError(c *gin.Context, err error) {
+	appErr := apperrors.As(err)
+	status := statusOf(appErr)
+	logError(c, appErr, status, err)
+
+	response := &models.OAuthError{Code: appErr.Code, Description: appErr.Message}
+	switch {
+	case status >= http.StatusInternalServerError:
+		response.Code = oauthServerError
+	case errors.Is(appErr, apperrors.ErrInvalidClient):
+		c.Header("WWW-Authenticate", clientAuthenticateHeader)
+	}
+
+	noStore(c)
+	c.AbortWithStatusJSON(status, response)
+}
@@ -0,0 +1,130 @@
+package endpoints
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"gicicm/apperrors"
+	"gicicm/logger"
+	"gicicm/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// oidcStateCookie binds an authorization request to the browser that started it,
+	// it holds a hash of the state and callbacks from other browsers are rejected.
+	oidcStateCookie = "oidc_state"
+	// oidcCookiePath limits the cookie to the OpenID endpoints.
+	oidcCookiePath = "/gicicm/auth/oidc"
+)
+
+// OIDCLogin is an endpoint that redirects the user to an OpenID provider to log in.
+func (ctrl *Controller) OIDCLogin(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	authorization, err := ctrl.authProvider.OIDCAuthorize(ctx, c.Param("provider"), "")
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	setOIDCStateCookie(c, hashOIDCState(authorization.State), 0)
+	c.Redirect(http.StatusFound, authorization.AuthorizationURL)
+}
+
+// OIDCCallback is an endpoint the OpenID providers redirect the user back to,
+// it returns the same result as a login with a password.
+func (ctrl *Controller) OIDCCallback(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// the user denied the request or the provider rejected it.
+	if code := c.Query("error"); code != "" {
+		abort(c, apperrors.ErrOIDCLoginFailed.Wrap(fmt.Errorf("provider %s: %s: %s",
+			c.Param("provider"), code, c.Query("error_description"))))
+		return
+	}
+
+	request := &models.OIDCCallbackRequest{
+		Provider:  c.Param("provider"),
+		Code:      c.Query("code"),
+		State:     c.Query("state"),
+		ClientIP:  clientIP(c),
+		UserAgent: c.Request.UserAgent(),
+	}
+
+	var fields []apperrors.FieldError
+	if request.Code == "" {
+		fields = append(fields, apperrors.FieldError{Field: "code", Message: "is required"})
+	}
+	if request.State == "" {
+		fields = append(fields, apperrors.FieldError{Field: "state", Message: "is required"})
+	}
+	err := apperrors.NewValidation(fields...)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	ctx = logger.With(ctx, zap.String("provider", request.Provider))
+	c.Request = c.Request.WithContext(ctx)
+
+	// the state must have been issued to this browser, a callback
+	// url sent by someone else does not log the user in.
+	cookie, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+	if subtle.ConstantTimeCompare([]byte(cookie), []byte(hashOIDCState(request.State))) != 1 {
+		abort(c, apperrors.ErrInvalidOIDCState)
+		return
+	}
+
+	result, err := ctrl.authProvider.OIDCCallback(ctx, request)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// LinkIdentity is an endpoint that starts linking an identity at an OpenID
+// provider to the current user, the user is sent to the returned url and
+// is logged in once the provider redirects back to the callback. The redirect
+// carries no access token, the state cookie set here binds the callback to
+// the browser of the user.
+func (ctrl *Controller) LinkIdentity(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	authorization, err := ctrl.authProvider.OIDCAuthorize(ctx, c.Param("provider"), metadata.Email)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	setOIDCStateCookie(c, hashOIDCState(authorization.State), 0)
+	c.JSON(http.StatusOK, authorization)
+}
+
+// setOIDCStateCookie sets the state cookie for the OpenID endpoints, it is
+// only sent with top level navigations from other sites. a negative maxAge
+// deletes the cookie, 0 keeps it until the browser is closed.
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, oidcCookiePath, "", true, true)
+}
+
+// hashOIDCState returns the hash of a state kept in the cookie.
+func hashOIDCState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,122 @@
+package endpoints
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"gicicm/adapters/cache"
+	"gicicm/apperrors"
+	"gicicm/config"
+	"gicicm/logger"
+	"gicicm/metrics"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// rate limit policies, the name is part of the cache key and of the metric labels.
+const (
+	PolicySignup  = "signup"
+	PolicyLogin   = "login"
+	PolicyAuth    = "auth"
+	PolicyDefault = "default"
+)
+
+// headers describing the rate limit of a request.
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	// RateLimitResetHeader is the number of seconds until the limit is fully restored.
+	RateLimitResetHeader = "X-RateLimit-Reset"
+)
+
+// KeyFunc returns the key a request is limited by,
+// requests with an empty key are not limited.
+type KeyFunc func(c *gin.Context) string
+
+// ByClientIP limits requests per client ip.
+func ByClientIP(c *gin.Context) string {
+	ip := clientIP(c)
+	if ip == "" {
+		return ""
+	}
+	return "ip:" + ip
+}
+
+// ByUser limits authenticated requests per user and others per client ip.
+// must be used after the Verify middleware.
+func ByUser(c *gin.Context) string {
+	email, ok := c.Keys["email"].(string)
+	if ok && email != "" {
+		return "user:" + email
+	}
+	return ByClientIP(c)
+}
+
+// RateLimiter limits requests with token buckets kept in the cache,
+// with redis the limits are shared by all the instances.
+type RateLimiter struct {
+	cache    cache.Cache
+	policies map[string]config.RateLimit
+}
+
+// NewRateLimiter returns a rate limiter enforcing the configured policies.
+func NewRateLimiter(cache cache.Cache, limits config.RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		cache: cache,
+		policies: map[string]config.RateLimit{
+			PolicySignup:  limits.Signup,
+			PolicyLogin:   limits.Login,
+			PolicyAuth:    limits.Auth,
+			PolicyDefault: limits.Default,
+		},
+	}
+}
+
+// Limit is a middleware that takes a token from the bucket of the request key
+// for the policy and rejects the request with 429 once the bucket is empty.
+func (rl *RateLimiter) Limit(policy string, keyBy KeyFunc) gin.HandlerFunc {
+	limit := rl.policies[policy]
+	if limit.Requests <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	bucket := cache.Bucket{
+		Capacity: int64(limit.Requests),
+		Interval: limit.Period / time.Duration(limit.Requests),
+	}
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		key := keyBy(c)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		state, err := rl.cache.TakeToken(ctx, fmt.Sprintf("ratelimit:%s:%s", policy, key), bucket)
+		if err != nil {
+			// fail open, an outage of the cache should not take down the api.
+			logger.FromContext(ctx).Error("rate limiter unavailable", zap.String("policy", policy), zap.Error(err))
+			c.Next()
+			return
+		}
+
+		c.Header(RateLimitLimitHeader, strconv.Itoa(limit.Requests))
+		c.Header(RateLimitRemainingHeader, strconv.FormatInt(state.Remaining, 10))
+		c.Header(RateLimitResetHeader, strconv.FormatInt(retryAfterSeconds(state.Reset), 10))
+
+		if !state.Allowed {
+			metrics.RateLimited.WithLabelValues(policy).Inc()
+			logger.FromContext(ctx).Info("rate limited", zap.String("policy", policy))
+			abort(c, apperrors.ErrRateLimited.WithRetryAfter(state.RetryAfter))
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,157 @@
+package endpoints
+
+import (
+	"strings"
+
+	"gicicm/apperrors"
+	"gicicm/logger"
+	"gicicm/models"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// permissions that can be required by an endpoint.
+const (
+	PermissionUsersList   = "users:list"
+	PermissionUsersDelete = "users:delete"
+	PermissionUsersUpdate = "users:update"
+	PermissionUsersUnlock = "users:unlock"
+	PermissionRolesManage = "roles:manage"
+	// PermissionSessionsRevoke allows logging other users out of all their sessions.
+	PermissionSessionsRevoke = "sessions:revoke"
+	// PermissionClientsManage allows registering and deleting OAuth clients.
+	PermissionClientsManage = "clients:manage"
+)
+
+// rolePermissions is the permission matrix,
+// it maps a role to the permissions it grants.
+var rolePermissions = map[string][]string{
+	models.RoleUser: {
+		PermissionUsersList,
+	},
+	models.RoleAdmin: {
+		PermissionUsersList,
+		PermissionUsersDelete,
+		PermissionUsersUpdate,
+		PermissionUsersUnlock,
+		PermissionRolesManage,
+		PermissionSessionsRevoke,
+		PermissionClientsManage,
+	},
+}
+
+// RequirePermission is a middleware that only lets a request through
+// if one of the roles of the authenticated user grants the permission.
+// must be used after the Verify middleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		metadata, err := parseContextMetaData(c)
+		if err != nil {
+			abort(c, err)
+			return
+		}
+
+		if !isAllowed(metadata, permission) {
+			logger.FromContext(ctx).Info("permission denied", zap.String("permission", permission))
+			abort(c, apperrors.ErrPermissionDenied)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireAccessToken is a middleware that rejects requests authenticated
+// by an api key or a token of an OAuth client, for account operations
+// that need a login. must be used after the Verify middleware.
+func RequireAccessToken(c *gin.Context) {
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	if !metadata.Login() {
+		abort(c, apperrors.ErrAccessTokenRequired)
+		return
+	}
+
+	c.Next()
+}
+
+// isAllowed checks whether the user of a request has the permission,
+// for api keys and tokens of OAuth clients the permission must be in their
+// scopes as well. tokens a client got with its own credentials have no user,
+// they only grant the scopes the client was registered with.
+func isAllowed(metadata *models.RequestMetaData, permission string) bool {
+	var scopes []string
+	switch {
+	case metadata.APIKey != nil:
+		scopes = metadata.APIKey.Scopes
+	case metadata.Claims.Delegated():
+		scopes = strings.Fields(metadata.Claims.Scope)
+		if metadata.Email == "" {
+			return contains(scopes, permission)
+		}
+	default:
+		return hasPermission(metadata.Roles, permission)
+	}
+
+	return hasPermission(metadata.Roles, permission) && contains(scopes, permission)
+}
+
+// contains checks whether values contains value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPermission checks whether any of the roles grants the permission.
+func hasPermission(roles []string, permission string) bool {
+	for _, role := range roles {
+		for _, p := range rolePermissions[role] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// permissionsOf returns the permissions granted by the roles.
+func permissionsOf(roles []string) []string {
+	var permissions []string
+	for _, role := range roles {
+		for _, p := range rolePermissions[role] {
+			if !contains(permissions, p) {
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	return permissions
+}
+
+// isPermissionValid checks whether a permission is granted by any role.
+func isPermissionValid(permission string) bool {
+	for _, permissions := range rolePermissions {
+		for _, p := range permissions {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isRoleValid checks whether a role is part of the permission matrix.
+func isRoleValid(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
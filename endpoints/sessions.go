@@ -0,0 +1,49 @@
+package endpoints
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListSessions is an endpoint that lists the sessions of the current user.
+func (ctrl *Controller) ListSessions(c *gin.Context) {
+	ctx := c.Request.Context()
+	response := make(map[string]interface{})
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	sessions, err := ctrl.authProvider.ListSessions(ctx, metadata.Claims)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["sessions"] = sessions
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeSession is an endpoint that revokes a session of the current user.
+func (ctrl *Controller) RevokeSession(c *gin.Context) {
+	ctx := c.Request.Context()
+	response := make(map[string]interface{})
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	err = ctrl.authProvider.RevokeSession(ctx, metadata.Email, c.Param("id"))
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["result"] = "Successfully Revoked"
+	c.JSON(http.StatusOK, response)
+}
@@ -1,13 +1,14 @@
 package endpoints
 
 import (
+	"context"
+	"fmt"
+	"gicicm/apperrors"
 	"gicicm/common"
-	"gicicm/logger"
 	"gicicm/models"
 	"github.com/gin-gonic/gin"
-	"go.uber.org/zap"
 	"net/http"
-	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 )
@@ -19,55 +20,37 @@ func (ctrl *Controller) CreateUser(c *gin.Context) {
 
 	response := make(map[string]interface{})
 	request := new(models.User)
-	err := c.BindJSON(request)
+	err := c.ShouldBindJSON(request)
 
 	if err != nil {
-		logger.Log().Error("error while binding request body to user", zap.Error(err))
-		response["error"] = common.BadRequestError
-		c.JSON(http.StatusBadRequest, response)
-		c.Abort()
+		abort(c, apperrors.ErrBadRequest.Wrap(err))
 		return
 	}
 
 	// input validation.
-	if !isEmailValid(request.Email) {
-		logger.Log().Info("invalid email", zap.String("email", request.Email))
-		response["error"] = common.EmailValidationError
-		c.JSON(http.StatusBadRequest, response)
-		c.Abort()
-		return
+	var fields []apperrors.FieldError
+
+	if !models.ValidEmail(request.Email) {
+		fields = append(fields, apperrors.FieldError{Field: "email", Message: common.EmailValidationError})
 	}
 
 	if !isPasswordValid(request.Password) {
-		logger.Log().Info("invalid password", zap.String("password", request.Password))
-		response["error"] = common.PasswordValidationError
-		c.JSON(http.StatusBadRequest, response)
-		c.Abort()
-		return
+		fields = append(fields, apperrors.FieldError{Field: "password", Message: common.PasswordValidationError})
 	}
 
 	if strings.Trim(request.Name, " ") == "" {
-		logger.Log().Info("empty name", zap.String("name", request.Name))
-		response["error"] = common.BadRequestError
-		c.JSON(http.StatusBadRequest, response)
-		c.Abort()
+		fields = append(fields, apperrors.FieldError{Field: "name", Message: nameValidationError})
+	}
+
+	err = apperrors.NewValidation(fields...)
+	if err != nil {
+		abort(c, err)
 		return
 	}
 
 	err = ctrl.userProvider.Create(ctx, request)
 	if err != nil {
-		if err.Error() == common.AccountAlreadyExistsError {
-			logger.Log().Info("empty name", zap.String("request", request.Email), zap.Error(err))
-			response["error"] = common.AccountAlreadyExistsError
-			c.JSON(http.StatusConflict, response)
-			c.Abort()
-			return
-		}
-
-		logger.Log().Error("error while creating user", zap.Error(err))
-		response["error"] = err.Error()
-		c.JSON(http.StatusBadRequest, response)
-		c.Abort()
+		abort(c, err)
 		return
 	}
 
@@ -75,22 +58,74 @@ func (ctrl *Controller) CreateUser(c *gin.Context) {
 	c.JSON(http.StatusCreated, response)
 }
 
-// ListUsers is an endpoint for listing all users.
+// nameValidationError is the message for an empty name.
+const nameValidationError = "name must not be empty"
+
+// limits for the page size of ListUsers.
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// ListUsers is an endpoint for listing a page of users.
+// supports the limit, cursor, sort, email_prefix and name query params,
+// sort is one of id, name or email and is descending when prefixed with a -.
 func (ctrl *Controller) ListUsers(c *gin.Context) {
 	ctx := c.Request.Context()
-	response := make(map[string]interface{})
 
-	usersList, err := ctrl.userProvider.List(ctx)
+	request, err := parseListUsersRequest(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
 
+	page, err := ctrl.userProvider.List(ctx, request)
 	if err != nil {
-		logger.Log().Error("error while getting users", zap.Error(err))
-		response["error"] = err.Error()
-		c.JSON(http.StatusInternalServerError, response)
-		c.Abort()
+		abort(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, usersList)
+	c.JSON(http.StatusOK, page)
+}
+
+// parseListUsersRequest parses the query params of ListUsers.
+func parseListUsersRequest(c *gin.Context) (*models.ListUsersRequest, error) {
+	request := &models.ListUsersRequest{
+		Limit:        defaultListLimit,
+		Cursor:       c.Query("cursor"),
+		Sort:         c.DefaultQuery("sort", "id"),
+		EmailPrefix:  c.Query("email_prefix"),
+		NameContains: c.Query("name"),
+	}
+
+	var fields []apperrors.FieldError
+
+	if limit := c.Query("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 1 || value > maxListLimit {
+			fields = append(fields, apperrors.FieldError{
+				Field:   "limit",
+				Message: fmt.Sprintf("must be a number between 1 and %d", maxListLimit),
+			})
+		}
+		request.Limit = value
+	}
+
+	if strings.HasPrefix(request.Sort, "-") {
+		request.Sort = strings.TrimPrefix(request.Sort, "-")
+		request.Descending = true
+	}
+
+	if request.Sort != "id" && request.Sort != "name" && request.Sort != "email" {
+		fields = append(fields, apperrors.FieldError{Field: "sort", Message: "must be one of id, name or email"})
+	}
+
+	err := apperrors.NewValidation(fields...)
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
 }
 
 // DeleteUser deletes a user based on the id.
@@ -98,40 +133,151 @@ func (ctrl *Controller) DeleteUser(c *gin.Context) {
 	ctx := c.Request.Context()
 	response := make(map[string]interface{})
 
+	email := c.Param("email")
+
+	err := ctrl.userProvider.Delete(ctx, email)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["result"] = "Successfully Deleted"
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdateUser partially updates a user based on the id,
+// users can update themselves, others need the users:update permission,
+// as do api keys updating their own user.
+func (ctrl *Controller) UpdateUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	response := make(map[string]interface{})
+
 	metadata, err := parseContextMetaData(c)
 	if err != nil {
-		response["error"] = common.InternalServerError
-		c.JSON(http.StatusInternalServerError, response)
-		c.Abort()
+		abort(c, err)
 		return
 	}
 
-	// send back a 403 if user is not admin.
-	if !metadata.IsAdmin {
-		response["error"] = common.UnAuthorizedError
-		c.JSON(http.StatusForbidden, response)
-		c.Abort()
+	email := c.Param("email")
+
+	// send back a 403 if user is neither the owner nor permitted.
+	self := metadata.Email == email && metadata.Login()
+	if !self && !isAllowed(metadata, PermissionUsersUpdate) {
+		abort(c, apperrors.ErrPermissionDenied)
+		return
+	}
+
+	request := new(models.UserUpdate)
+	err = c.ShouldBindJSON(request)
+	if err != nil {
+		abort(c, apperrors.ErrBadRequest.Wrap(err))
+		return
+	}
+
+	if request.Name == nil && request.Password == nil {
+		abort(c, apperrors.ErrBadRequest)
 		return
 	}
 
+	// input validation.
+	var fields []apperrors.FieldError
+
+	if request.Password != nil && !isPasswordValid(*request.Password) {
+		fields = append(fields, apperrors.FieldError{Field: "password", Message: common.PasswordValidationError})
+	}
+
+	if request.Name != nil && strings.Trim(*request.Name, " ") == "" {
+		fields = append(fields, apperrors.FieldError{Field: "name", Message: nameValidationError})
+	}
+
+	err = apperrors.NewValidation(fields...)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	err = ctrl.userProvider.Update(ctx, email, request)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["result"] = "Successfully Updated"
+	c.JSON(http.StatusOK, response)
+}
+
+// GrantRole grants a role to a user.
+func (ctrl *Controller) GrantRole(c *gin.Context) {
+	ctrl.changeRole(c, ctrl.userProvider.GrantRole, "Successfully Granted")
+}
+
+// RevokeRole revokes a role from a user.
+func (ctrl *Controller) RevokeRole(c *gin.Context) {
+	ctrl.changeRole(c, ctrl.userProvider.RevokeRole, "Successfully Revoked")
+}
+
+// changeRole applies a role change to the user in the path.
+func (ctrl *Controller) changeRole(c *gin.Context, change func(ctx context.Context, email, role string) error, result string) {
+	ctx := c.Request.Context()
+	response := make(map[string]interface{})
+
 	email := c.Param("email")
+	role := c.Param("role")
+
+	if !isRoleValid(role) {
+		abort(c, apperrors.ErrInvalidRole)
+		return
+	}
 
-	err = ctrl.userProvider.Delete(ctx, email)
+	err := change(ctx, email, role)
 	if err != nil {
-		if err.Error() == common.AccountNotFoundError {
-			response["error"] = common.AccountNotFoundError
-			c.JSON(http.StatusNotFound, response)
-			c.Abort()
-			return
-		}
-		logger.Log().Error("error while deleting user", zap.String("email", email), zap.Error(err))
-		response["error"] = err.Error()
-		c.JSON(http.StatusInternalServerError, response)
-		c.Abort()
+		abort(c, err)
 		return
 	}
 
-	response["result"] = "Successfully Deleted"
+	response["result"] = result
+	c.JSON(http.StatusOK, response)
+}
+
+// UnlockUser lifts the login lock of a user and clears its failed logins.
+func (ctrl *Controller) UnlockUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	response := make(map[string]interface{})
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	err = ctrl.authProvider.UnlockAccount(ctx, c.Param("email"), metadata.Email)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["result"] = "Successfully Unlocked"
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeUserSessions logs a user out of all its sessions.
+func (ctrl *Controller) RevokeUserSessions(c *gin.Context) {
+	ctx := c.Request.Context()
+	response := make(map[string]interface{})
+
+	metadata, err := parseContextMetaData(c)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	err = ctrl.authProvider.RevokeSessions(ctx, c.Param("email"), metadata.Email)
+	if err != nil {
+		abort(c, err)
+		return
+	}
+
+	response["result"] = "Successfully Revoked"
 	c.JSON(http.StatusOK, response)
 }
 
@@ -153,11 +299,3 @@ func isPasswordValid(password string) bool {
 	}
 	return len(password) > 8 && hasNumber && hasUpper && hasSymbol
 }
-
-// isPasswordValid checks whether an email is valid.
-// fyi best way to validate an email is to send a mail
-// with a verification link.
-func isEmailValid(email string) bool {
-	re := regexp.MustCompile("^[^@]+@[^@]+[.][^@]+$")
-	return re.MatchString(email)
-}
@@ -7,6 +7,8 @@ require (
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-redis/redis v6.15.8+incompatible
 	github.com/lib/pq v1.7.1
+	github.com/mattn/go-sqlite3 v1.14.5
+	github.com/prometheus/client_golang v1.7.1
 	github.com/stretchr/testify v1.4.0
 	go.uber.org/zap v1.15.0
 	golang.org/x/crypto v0.0.0-20190510104115-cbcb75029529
@@ -0,0 +1,139 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statuses reported by the checker.
+const (
+	StatusUp           = "up"
+	StatusDown         = "down"
+	StatusReady        = "ready"
+	StatusNotReady     = "not ready"
+	StatusShuttingDown = "shutting down"
+)
+
+// Check pings a dependency, it returns an error if the dependency is down.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of a single check. Only the status is rendered,
+// the error may reveal hosts and ports of the dependencies so it is only logged.
+type Result struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"-"`
+	Error     string  `json:"-"`
+}
+
+// Report is the outcome of all the checks.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// Checker checks the readiness of the service and its dependencies.
+type Checker struct {
+	readiness *Readiness
+	timeout   time.Duration
+
+	mu     sync.Mutex
+	checks map[string]Check
+}
+
+// NewChecker returns a new checker, every check is given at most timeout to finish.
+func NewChecker(readiness *Readiness, timeout time.Duration) *Checker {
+	return &Checker{
+		readiness: readiness,
+		timeout:   timeout,
+		checks:    make(map[string]Check),
+	}
+}
+
+// Register adds a dependency check.
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Check runs all the checks concurrently and reports
+// whether the service is ready to receive traffic.
+func (c *Checker) Check(ctx context.Context) (*Report, bool) {
+	if !c.readiness.IsReady() {
+		return &Report{Status: StatusShuttingDown}, false
+	}
+
+	c.mu.Lock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = c.checks[name]
+	}
+	c.mu.Unlock()
+
+	results := make([]Result, len(names))
+
+	var wg sync.WaitGroup
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = c.run(ctx, checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	report := &Report{
+		Status: StatusReady,
+		Checks: make(map[string]Result, len(names)),
+	}
+
+	ready := true
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusUp {
+			ready = false
+			report.Status = StatusNotReady
+		}
+	}
+
+	return report, ready
+}
+
+// run runs a single check within the timeout.
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+
+	// the check may ignore the context, so the timeout
+	// is enforced here as well.
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{
+		Status:    StatusUp,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,73 @@
+// +build !integration
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_Check(t *testing.T) {
+	readiness := NewReadiness()
+	readiness.SetReady(true)
+
+	checker := NewChecker(readiness, time.Millisecond*50)
+	checker.Register("database", func(ctx context.Context) error {
+		return nil
+	})
+
+	report, ready := checker.Check(context.TODO())
+
+	assert.True(t, ready)
+	assert.Equal(t, StatusReady, report.Status)
+	assert.Equal(t, StatusUp, report.Checks["database"].Status)
+}
+
+func TestChecker_Check_DependencyDown(t *testing.T) {
+	readiness := NewReadiness()
+	readiness.SetReady(true)
+
+	checker := NewChecker(readiness, time.Millisecond*50)
+	checker.Register("database", func(ctx context.Context) error {
+		return nil
+	})
+	checker.Register("cache", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	// a check ignoring its context is cut off by the timeout.
+	checker.Register("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	start := time.Now()
+	report, ready := checker.Check(context.TODO())
+
+	assert.False(t, ready)
+	assert.Less(t, int64(time.Since(start)), int64(time.Millisecond*500))
+	assert.Equal(t, StatusNotReady, report.Status)
+	assert.Equal(t, StatusUp, report.Checks["database"].Status)
+	assert.Equal(t, StatusDown, report.Checks["cache"].Status)
+	assert.Equal(t, "connection refused", report.Checks["cache"].Error)
+	assert.Equal(t, StatusDown, report.Checks["slow"].Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+
+	// errors are not rendered.
+	body, err := json.Marshal(report)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"status":"not ready","checks":{"cache":{"status":"down"},"database":{"status":"up"},"slow":{"status":"down"}}}`, string(body))
+}
+
+func TestChecker_Check_ShuttingDown(t *testing.T) {
+	checker := NewChecker(NewReadiness(), time.Millisecond*50)
+
+	report, ready := checker.Check(context.TODO())
+
+	assert.False(t, ready)
+	assert.Equal(t, StatusShuttingDown, report.Status)
+}
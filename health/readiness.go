@@ -0,0 +1,28 @@
+package health
+
+import "sync/atomic"
+
+// Readiness tells whether the service is ready to receive traffic.
+// it is safe for concurrent use.
+type Readiness struct {
+	ready int32
+}
+
+// NewReadiness returns a new readiness flag, initially not ready.
+func NewReadiness() *Readiness {
+	return &Readiness{}
+}
+
+// SetReady sets the readiness flag.
+func (r *Readiness) SetReady(ready bool) {
+	var value int32
+	if ready {
+		value = 1
+	}
+	atomic.StoreInt32(&r.ready, value)
+}
+
+// IsReady checks whether the service is ready.
+func (r *Readiness) IsReady() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
@@ -0,0 +1,32 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// contextKey is the key under which the request scoped logger is stored.
+type contextKey struct{}
+
+// WithContext returns a copy of ctx carrying the given logger.
+func WithContext(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger bound to ctx, falling back to
+// the global logger for code running outside of a request.
+func FromContext(ctx context.Context) *zap.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(contextKey{}).(*zap.Logger); ok {
+			return l
+		}
+	}
+	return Log()
+}
+
+// With returns a copy of ctx whose logger carries the given fields,
+// every line logged through FromContext afterwards includes them.
+func With(ctx context.Context, fields ...zap.Field) context.Context {
+	return WithContext(ctx, FromContext(ctx).With(fields...))
+}
@@ -0,0 +1,31 @@
+// +build !integration
+
+package logger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestFromContext(t *testing.T) {
+	// outside of a request the global logger is used.
+	assert.Equal(t, Log(), FromContext(context.Background()))
+
+	core, logs := observer.New(zap.InfoLevel)
+	ctx := WithContext(context.Background(), zap.New(core).With(zap.String("request_id", "abc")))
+	ctx = With(ctx, zap.String("email", "test@mail.com"))
+
+	FromContext(ctx).Info("hello", zap.String("route", "/users"))
+
+	entries := logs.All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, map[string]interface{}{
+		"request_id": "abc",
+		"email":      "test@mail.com",
+		"route":      "/users",
+	}, entries[0].ContextMap())
+}
@@ -28,17 +28,21 @@ func Log() *zap.Logger {
 		OutputPaths:      []string{"stderr"},
 		ErrorOutputPaths: []string{"stderr"},
 		EncoderConfig: zapcore.EncoderConfig{
-			MessageKey:  "message",
-			TimeKey:     "timestamp",
-			EncodeTime:  zapcore.ISO8601TimeEncoder,
-			LevelKey:    "level",
-			EncodeLevel: zapcore.CapitalLevelEncoder,
+			MessageKey:     "message",
+			TimeKey:        "timestamp",
+			EncodeTime:     zapcore.ISO8601TimeEncoder,
+			LevelKey:       "level",
+			EncodeLevel:    zapcore.CapitalLevelEncoder,
+			EncodeDuration: zapcore.StringDurationEncoder,
 		},
 	}
 
 	// ensures that there is only one instance of the logger at anytime.
 	once.Do(func() {
-		logger, err = zapConfig.Build()
+		redactor := NewRedactor(redactionRules())
+		logger, err = zapConfig.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return NewRedactingCore(core, redactor)
+		}))
 		if err != nil {
 			panic("Logger could not be initialized. Error: " + err.Error())
 		}
@@ -1,16 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"gicicm/adapters/cache"
 	"gicicm/adapters/db"
+	"gicicm/adapters/notifier"
+	"gicicm/apperrors"
 	"gicicm/config"
 	"gicicm/endpoints"
+	"gicicm/health"
 	"gicicm/logger"
+	"gicicm/migrations"
+	"gicicm/models"
+	"gicicm/oidc"
 	"gicicm/providers"
+	"gicicm/signing"
 	"gicicm/stores"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"go.uber.org/zap"
 )
 
 func main() {
@@ -18,31 +32,147 @@ func main() {
 	config := config.GetConfig()
 
 	// Init adapters
-	cache := cache.NewCache(config)
 	database := db.NewDatabaseAdapter(config)
 
+	// gicicm migrate up|down|status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := migrate(database, config.Database.DBType, os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if config.Database.MigrateOnStart {
+		migrator, err := migrations.NewMigrator(database, config.Database.DBType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = migrator.Up(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(config.Auth.MFASecretKey) == 0 {
+		logger.Log().Warn("MFA_SECRET_KEY is not set, the TOTP secrets are stored unencrypted")
+	}
+
+	cache := cache.NewCache(config)
+	notifier := notifier.NewNotifier(config)
+
 	// Init stores
-	userStore := stores.NewUserRepository(database, cache)
+	var userStore stores.UserRepository
+	var auditStore stores.AuditRepository
+	var mfaStore stores.MFARepository
+	var apiKeyStore stores.APIKeyRepository
+	var identityStore stores.IdentityRepository
+	var clientStore stores.ClientRepository
+	switch config.Database.DBType {
+	case "sqlite3":
+		userStore = stores.NewSQLiteUserRepository(database, cache)
+		auditStore = stores.NewSQLiteAuditRepository(database)
+		mfaStore = stores.NewSQLiteMFARepository(database)
+		apiKeyStore = stores.NewSQLiteAPIKeyRepository(database)
+		identityStore = stores.NewSQLiteIdentityRepository(database)
+		clientStore = stores.NewSQLiteClientRepository(database)
+	default:
+		userStore = stores.NewUserRepository(database, cache)
+		auditStore = stores.NewAuditRepository(database)
+		mfaStore = stores.NewMFARepository(database)
+		apiKeyStore = stores.NewAPIKeyRepository(database)
+		identityStore = stores.NewIdentityRepository(database)
+		clientStore = stores.NewClientRepository(database)
+	}
 	authStore := stores.NewAuthRepository(cache)
 
+	keys, err := signing.NewKeySetFromConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	identityProviders, err := oidc.NewRegistryFromConfig(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Init providers
-	authProvider := providers.NewAuthProvider(userStore, authStore)
-	userProvider := providers.NewUserProvider(userStore)
+	authProvider := providers.NewAuthProvider(userStore, authStore, auditStore, mfaStore, apiKeyStore, identityStore,
+		clientStore, keys, identityProviders, notifier, config)
+	userProvider := providers.NewUserProvider(userStore, authStore)
+
+	// granting roles needs an admin, the first ones are configured.
+	for _, email := range config.Auth.AdminEmails {
+		err = userProvider.GrantRole(context.Background(), email, models.RoleAdmin)
+		if errors.Is(err, apperrors.ErrAccountNotFound) {
+			logger.Log().Warn("admin account does not exist, it is granted on the next start", zap.String("email", email))
+			continue
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Init controller with router
-	router := endpoints.NewController(authProvider, userProvider)
+	readiness := health.NewReadiness()
+	checker := health.NewChecker(readiness, time.Second)
+	checker.Register("database", database.PingContext)
+	checker.Register("cache", func(ctx context.Context) error {
+		return cache.Ping(ctx)
+	})
+
+	router := endpoints.NewController(authProvider, userProvider, checker, endpoints.NewRateLimiter(cache, config.RateLimit),
+		config.Server.TrustedProxies)
 
 	server := &http.Server{
-		Addr:         "0.0.0.0:8000",
+		Addr:         config.Server.Addr,
 		Handler:      router,
 		ReadTimeout:  time.Second * 5,
 		WriteTimeout: time.Second * 5,
 	}
 
-	logger.Log().Info("Listening on 8000...")
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Log().Info("Listening...", zap.String("addr", config.Server.Addr))
+		serverErr <- server.ListenAndServe()
+	}()
 
-	err := server.ListenAndServe()
-	if err != nil {
+	readiness.SetReady(true)
+
+	// wait for a termination signal or the server to fail.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
 		log.Fatal(err)
+	case sig := <-signals:
+		logger.Log().Info("Shutting down...", zap.String("signal", sig.String()))
+	}
+
+	// stop receiving new traffic before draining.
+	readiness.SetReady(false)
+	time.Sleep(config.Server.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Server.DrainTimeout)
+	defer cancel()
+
+	err = server.Shutdown(ctx)
+	if err != nil {
+		logger.Log().Error("error while draining requests", zap.Error(err))
+	}
+
+	// close the adapters once no request can use them anymore.
+	err = cache.Close()
+	if err != nil {
+		logger.Log().Error("error while closing cache", zap.Error(err))
 	}
+
+	err = database.Close()
+	if err != nil {
+		logger.Log().Error("error while closing database", zap.Error(err))
+	}
+
+	logger.Log().Info("Shut down")
+	_ = logger.Log().Sync()
 }
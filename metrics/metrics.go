@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "gicicm"
+
+// results used as label values.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	ResultHit     = "hit"
+	ResultMiss    = "miss"
+	ResultError   = "error"
+)
+
+// reasons for revoking all the sessions of a user, used as label values.
+const (
+	ReasonLogoutAll       = "logout_all"
+	ReasonAdmin           = "admin"
+	ReasonUserDeleted     = "user_deleted"
+	ReasonPasswordChanged = "password_changed"
+	ReasonPasswordReset   = "password_reset"
+	ReasonRoleRevoked     = "role_revoked"
+)
+
+var (
+	// HTTPRequestDuration observes request latencies per route and status.
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of HTTP requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// Logins counts login attempts by result.
+	Logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "logins_total",
+		Help:      "Login attempts by result.",
+	}, []string{"result"})
+
+	// TokenRevocations counts revoked access tokens.
+	TokenRevocations = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "token_revocations_total",
+		Help:      "Access tokens revoked.",
+	})
+
+	// SessionRevocations counts revocations of all the sessions of a user by reason.
+	SessionRevocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auth",
+		Name:      "session_revocations_total",
+		Help:      "Revocations of all the sessions of a user by reason.",
+	}, []string{"reason"})
+
+	// OAuthTokens counts the tokens issued to OAuth clients by grant type.
+	OAuthTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "oauth",
+		Name:      "tokens_issued_total",
+		Help:      "Tokens issued to OAuth clients by grant type.",
+	}, []string{"grant_type"})
+
+	// RateLimited counts requests rejected by the rate limiter by policy.
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by the rate limiter by policy.",
+	}, []string{"policy"})
+
+	// CacheRequests counts cache lookups by driver and result.
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Cache lookups by driver and result (hit, miss or error).",
+	}, []string{"driver", "result"})
+
+	// DBQueryDuration observes database query latencies per query.
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of database queries by query name.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"query"})
+)
+
+// ObserveQuery records the duration of the named query since start.
+// usage: defer metrics.ObserveQuery("fetch_user", time.Now())
+func ObserveQuery(query string, start time.Time) {
+	DBQueryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
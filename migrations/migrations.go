@@ -0,0 +1,262 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gicicm/logger"
+
+	"go.uber.org/zap"
+)
+
+// Migration is a versioned change to the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is the state of a migration in a database.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Dialect contains the migrations and
+// database specific queries for a database type.
+type Dialect struct {
+	Migrations []Migration
+
+	// LockQuery and UnlockQuery guard against concurrent
+	// runs, they are skipped when empty.
+	LockQuery   string
+	UnlockQuery string
+}
+
+const (
+	createMigrationsTableQuery = "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name varchar(200) NOT NULL, applied_at TIMESTAMP NOT NULL)"
+	listMigrationsQuery        = "SELECT version,applied_at from schema_migrations"
+	insertMigrationQuery       = "INSERT INTO schema_migrations(version,name,applied_at) VALUES($1,$2,$3)"
+	deleteMigrationQuery       = "DELETE FROM schema_migrations WHERE version=$1"
+)
+
+// dialects maps a DB_TYPE to its dialect.
+var dialects = map[string]*Dialect{
+	"postgres": postgres,
+	"sqlite3":  sqlite,
+}
+
+// ErrNoMigration is returned by Down when there is nothing to roll back.
+var ErrNoMigration = errors.New("no migration to roll back")
+
+// Migrator applies and rolls back migrations.
+type Migrator struct {
+	db      *sql.DB
+	dialect *Dialect
+}
+
+// NewMigrator returns a new migrator for the database type.
+func NewMigrator(db *sql.DB, dbType string) (*Migrator, error) {
+	dialect, ok := dialects[dbType]
+	if !ok {
+		return nil, fmt.Errorf("no migrations for database type %s", dbType)
+	}
+
+	return &Migrator{
+		db:      db,
+		dialect: dialect,
+	}, nil
+}
+
+// Up applies all the pending migrations in order
+// and returns the applied migrations.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			if status.Applied {
+				continue
+			}
+
+			err = m.apply(ctx, conn, status.Migration.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, insertMigrationQuery, status.Version, status.Name, time.Now().UTC())
+				return err
+			})
+			if err != nil {
+				logger.Log().Error("error while applying migration", zap.Int("version", status.Version), zap.Error(err))
+				return err
+			}
+
+			logger.Log().Info("applied migration", zap.Int("version", status.Version), zap.String("name", status.Name))
+			applied = append(applied, status.Migration)
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back the latest applied migration and returns it.
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	var rolledBack *Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(statuses) - 1; i >= 0; i-- {
+			status := statuses[i]
+			if !status.Applied {
+				continue
+			}
+
+			err = m.apply(ctx, conn, status.Migration.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, deleteMigrationQuery, status.Version)
+				return err
+			})
+			if err != nil {
+				logger.Log().Error("error while rolling back migration", zap.Int("version", status.Version), zap.Error(err))
+				return err
+			}
+
+			logger.Log().Info("rolled back migration", zap.Int("version", status.Version), zap.String("name", status.Name))
+			rolledBack = &status.Migration
+			return nil
+		}
+		return ErrNoMigration
+	})
+
+	return rolledBack, err
+}
+
+// Status returns the state of every known migration.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		statuses, err = m.status(ctx, conn)
+		return err
+	})
+
+	return statuses, err
+}
+
+// status returns the state of every known migration ordered by version.
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]Status, error) {
+	_, err := conn.ExecContext(ctx, createMigrationsTableQuery)
+	if err != nil {
+		logger.Log().Error("error while creating migrations table", zap.Error(err))
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, listMigrationsQuery)
+	if err != nil {
+		logger.Log().Error("error while querying migrations", zap.Error(err))
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			logger.Log().Error("error while closing rows", zap.Error(err))
+		}
+	}()
+
+	appliedAt := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			logger.Log().Error("error while scanning migration", zap.Error(err))
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+
+	statuses := make([]Status, 0, len(m.dialect.Migrations))
+	for _, migration := range m.dialect.Migrations {
+		at, ok := appliedAt[migration.Version]
+		statuses = append(statuses, Status{
+			Migration: migration,
+			Applied:   ok,
+			AppliedAt: at,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+// apply executes a migration script and records it in a single transaction.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err == nil {
+		err = record(tx)
+	}
+
+	if err != nil {
+		rollBackErr := tx.Rollback()
+		if rollBackErr != nil {
+			logger.Log().Error("Error while rolling back transaction", zap.Error(rollBackErr))
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// withLock runs f on a single connection holding the migration lock.
+func (m *Migrator) withLock(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err := conn.Close()
+		if err != nil {
+			logger.Log().Error("error while closing connection", zap.Error(err))
+		}
+	}()
+
+	if m.dialect.LockQuery != "" {
+		_, err = conn.ExecContext(ctx, m.dialect.LockQuery)
+		if err != nil {
+			logger.Log().Error("error while acquiring migration lock", zap.Error(err))
+			return err
+		}
+
+		defer func() {
+			_, err := conn.ExecContext(ctx, m.dialect.UnlockQuery)
+			if err != nil {
+				logger.Log().Error("error while releasing migration lock", zap.Error(err))
+			}
+		}()
+	}
+
+	return f(conn)
+}
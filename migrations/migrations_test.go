@@ -0,0 +1,112 @@
+// +build !integration
+
+package migrations
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func init() {
+	dialects["test"] = &Dialect{
+		LockQuery:   "SELECT lock()",
+		UnlockQuery: "SELECT unlock()",
+		Migrations: []Migration{
+			{Version: 2, Name: "second", Up: "CREATE TABLE second()", Down: "DROP TABLE second"},
+			{Version: 1, Name: "first", Up: "CREATE TABLE first()", Down: "DROP TABLE first"},
+		},
+	}
+}
+
+func TestMigrator_Up(t *testing.T) {
+	db, mockSQL, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+	}
+	defer db.Close()
+
+	mockSQL.ExpectExec(regexp.QuoteMeta("SELECT lock()")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockSQL.ExpectExec(regexp.QuoteMeta(createMigrationsTableQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockSQL.ExpectQuery(regexp.QuoteMeta(listMigrationsQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+
+	// only the pending migration is applied.
+	mockSQL.ExpectBegin()
+	mockSQL.ExpectExec(regexp.QuoteMeta("CREATE TABLE second()")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockSQL.ExpectExec(regexp.QuoteMeta(insertMigrationQuery)).
+		WithArgs(2, "second", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mockSQL.ExpectCommit()
+	mockSQL.ExpectExec(regexp.QuoteMeta("SELECT unlock()")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	migrator, err := NewMigrator(db, "test")
+	assert.NoError(t, err)
+
+	applied, err := migrator.Up(context.TODO())
+
+	assert.NoError(t, err)
+	assert.Len(t, applied, 1)
+	assert.Equal(t, 2, applied[0].Version)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+func TestMigrator_Up_FailureRollsBack(t *testing.T) {
+	db, mockSQL, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+	}
+	defer db.Close()
+
+	mockSQL.ExpectExec(regexp.QuoteMeta("SELECT lock()")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockSQL.ExpectExec(regexp.QuoteMeta(createMigrationsTableQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockSQL.ExpectQuery(regexp.QuoteMeta(listMigrationsQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+
+	mockSQL.ExpectBegin()
+	mockSQL.ExpectExec(regexp.QuoteMeta("CREATE TABLE first()")).WillReturnError(assert.AnError)
+	mockSQL.ExpectRollback()
+	mockSQL.ExpectExec(regexp.QuoteMeta("SELECT unlock()")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	migrator, _ := NewMigrator(db, "test")
+	applied, err := migrator.Up(context.TODO())
+
+	assert.Error(t, err)
+	assert.Empty(t, applied)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+func TestMigrator_Down(t *testing.T) {
+	db, mockSQL, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+	}
+	defer db.Close()
+
+	mockSQL.ExpectExec(regexp.QuoteMeta("SELECT lock()")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockSQL.ExpectExec(regexp.QuoteMeta(createMigrationsTableQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockSQL.ExpectQuery(regexp.QuoteMeta(listMigrationsQuery)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()).AddRow(2, time.Now()))
+
+	// only the latest migration is rolled back.
+	mockSQL.ExpectBegin()
+	mockSQL.ExpectExec(regexp.QuoteMeta("DROP TABLE second")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mockSQL.ExpectExec(regexp.QuoteMeta(deleteMigrationQuery)).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mockSQL.ExpectCommit()
+	mockSQL.ExpectExec(regexp.QuoteMeta("SELECT unlock()")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	migrator, _ := NewMigrator(db, "test")
+	migration, err := migrator.Down(context.TODO())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, migration.Version)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+func TestMigrator_UnknownDialect(t *testing.T) {
+	_, err := NewMigrator(nil, "oracle")
+	assert.Error(t, err)
+}
@@ -0,0 +1,145 @@
+package migrations
+
+// sqlite is the dialect for DB_TYPE=sqlite3.
+// sqlite allows a single writer so no lock is needed.
+var sqlite = &Dialect{
+	Migrations: []Migration{
+		{
+			Version: 1,
+			Name:    "create users",
+			Up: `CREATE TABLE IF NOT EXISTS users (
+				id        INTEGER PRIMARY KEY AUTOINCREMENT,
+				name      varchar(40) NOT NULL,
+				email     varchar(40) UNIQUE NOT NULL,
+				password  varchar(200) NOT NULL
+			)`,
+			Down: `DROP TABLE users`,
+		},
+		{
+			Version: 2,
+			Name:    "create user roles",
+			Up: `CREATE TABLE IF NOT EXISTS user_roles (
+				user_id   INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				role      varchar(40) NOT NULL,
+				PRIMARY KEY (user_id, role)
+			)`,
+			Down: `DROP TABLE user_roles`,
+		},
+		{
+			Version: 3,
+			Name:    "create audit log",
+			Up: `CREATE TABLE IF NOT EXISTS audit_log (
+				id          INTEGER PRIMARY KEY AUTOINCREMENT,
+				action      varchar(40) NOT NULL,
+				actor       varchar(40) NOT NULL DEFAULT '',
+				subject     varchar(64) NOT NULL,
+				ip          varchar(45) NOT NULL DEFAULT '',
+				details     varchar(200) NOT NULL DEFAULT '',
+				created_at  timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			Down: `DROP TABLE audit_log`,
+		},
+		{
+			Version: 4,
+			Name:    "create user mfa",
+			Up: `CREATE TABLE IF NOT EXISTS user_mfa (
+				user_id   INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+				secret    varchar(64) NOT NULL,
+				enabled   BOOLEAN NOT NULL DEFAULT false
+			)`,
+			Down: `DROP TABLE user_mfa`,
+		},
+		{
+			Version: 5,
+			Name:    "create mfa recovery codes",
+			Up: `CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+				user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				code_hash  varchar(64) NOT NULL,
+				PRIMARY KEY (user_id, code_hash)
+			)`,
+			Down: `DROP TABLE mfa_recovery_codes`,
+		},
+		{
+			Version: 6,
+			Name:    "create api keys",
+			Up: `CREATE TABLE IF NOT EXISTS api_keys (
+				id            INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id       INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				name          varchar(64) NOT NULL,
+				prefix        varchar(16) UNIQUE NOT NULL,
+				key_hash      varchar(64) NOT NULL,
+				scopes        varchar(200) NOT NULL,
+				created_at    timestamp NOT NULL,
+				expires_at    timestamp,
+				last_used_at  timestamp
+			)`,
+			Down: `DROP TABLE api_keys`,
+		},
+		{
+			Version: 7,
+			Name:    "create user identities",
+			Up: `CREATE TABLE IF NOT EXISTS user_identities (
+				user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				issuer      varchar(200) NOT NULL,
+				subject     varchar(200) NOT NULL,
+				created_at  timestamp NOT NULL,
+				PRIMARY KEY (issuer, subject)
+			)`,
+			Down: `DROP TABLE user_identities`,
+		},
+		{
+			Version: 8,
+			Name:    "create oauth clients",
+			Up: `CREATE TABLE IF NOT EXISTS oauth_clients (
+				id             INTEGER PRIMARY KEY AUTOINCREMENT,
+				client_id      varchar(64) UNIQUE NOT NULL,
+				name           varchar(64) NOT NULL,
+				secret_hash    varchar(64) NOT NULL,
+				redirect_uris  varchar(2000) NOT NULL,
+				grant_types    varchar(100) NOT NULL,
+				scopes         varchar(200) NOT NULL,
+				created_at     timestamp NOT NULL
+			)`,
+			Down: `DROP TABLE oauth_clients`,
+		},
+		{
+			Version: 9,
+			Name:    "create oauth consents",
+			Up: `CREATE TABLE IF NOT EXISTS oauth_consents (
+				user_id     INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				client_id   INTEGER NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+				scopes      varchar(200) NOT NULL,
+				created_at  timestamp NOT NULL,
+				PRIMARY KEY (user_id, client_id)
+			)`,
+			Down: `DROP TABLE oauth_consents`,
+		},
+		{
+			Version: 10,
+			Name:    "widen mfa secret",
+			// encrypted secrets are longer than the base32 ones.
+			Up:   `-- sqlite does not enforce the length of varchar columns`,
+			Down: `-- sqlite does not enforce the length of varchar columns`,
+		},
+		{
+			Version: 11,
+			Name:    "create session generations",
+			Up: `CREATE TABLE IF NOT EXISTS session_generations (
+				email       varchar(40) PRIMARY KEY REFERENCES users(email) ON DELETE CASCADE,
+				generation  INTEGER NOT NULL
+			)`,
+			Down: `DROP TABLE session_generations`,
+		},
+		{
+			Version: 12,
+			Name:    "grant admin to test.com users",
+			// admin was granted by the @test.com email suffix before roles were stored,
+			// the users who had it keep it. the grants are kept on the way down as
+			// they can not be told apart from the ones made later.
+			Up: `INSERT INTO user_roles(user_id, role)
+				SELECT id, 'admin' FROM users WHERE email LIKE '%@test.com'
+				ON CONFLICT DO NOTHING`,
+			Down: `-- the granted roles are kept`,
+		},
+	},
+}
@@ -0,0 +1,54 @@
+// +build !integration
+
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/mattn/go-sqlite3" //dialect to be used
+)
+
+func TestSQLite_GrantAdminToTestUsers(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file::memory:?_foreign_keys=on")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	ctx := context.TODO()
+
+	// a deployment from before the roles were stored.
+	migrator := &Migrator{db: db, dialect: &Dialect{Migrations: sqlite.Migrations[:11]}}
+	_, err = migrator.Up(ctx)
+	require.NoError(t, err)
+
+	for _, email := range []string{"admin@test.com", "user@gmail.com", "granted@test.com"} {
+		_, err = db.Exec("INSERT INTO users(name, email, password) VALUES('name', ?, 'hash')", email)
+		require.NoError(t, err)
+	}
+	_, err = db.Exec("INSERT INTO user_roles(user_id, role) SELECT id, 'admin' FROM users WHERE email = 'granted@test.com'")
+	require.NoError(t, err)
+
+	migrator, err = NewMigrator(db, "sqlite3")
+	require.NoError(t, err)
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, applied)
+
+	rows, err := db.Query("SELECT email FROM users JOIN user_roles ON user_roles.user_id = users.id WHERE role = 'admin' ORDER BY email")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var admins []string
+	for rows.Next() {
+		var email string
+		require.NoError(t, rows.Scan(&email))
+		admins = append(admins, email)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"admin@test.com", "granted@test.com"}, admins)
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// APIKey is a long lived credential of a user for scripts,
+// it only grants the permissions in its scopes.
+type APIKey struct {
+	Name string `json:"name"`
+	// Prefix is the public part of the key, it identifies the key.
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+
+	// Email is the owner of the key.
+	Email string `json:"-"`
+	// Hash is the sha256 hash of the secret part of the key.
+	Hash string `json:"-"`
+}
+
+// Expired reports whether the key is expired at now.
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// CreateAPIKeyRequest represents a request for a new api key.
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is optional, keys without it never expire.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedAPIKey is returned once when a key is created,
+// Key is not stored and can not be shown again.
+type CreatedAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
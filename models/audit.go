@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Audited actions.
+const (
+	AuditAccountLocked   = "account_locked"
+	AuditAccountUnlocked = "account_unlocked"
+	AuditIPLocked        = "ip_locked"
+	AuditSessionsRevoked = "sessions_revoked"
+	AuditAPIKeyCreated   = "api_key_created"
+	AuditAPIKeyRevoked   = "api_key_revoked"
+	AuditIdentityLinked  = "identity_linked"
+	AuditUserProvisioned = "user_provisioned"
+	AuditClientCreated   = "client_created"
+	AuditClientDeleted   = "client_deleted"
+	AuditConsentGranted  = "consent_granted"
+)
+
+// AuditEntry records a security relevant action.
+// Actor is empty for actions taken by the system itself.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor,omitempty"`
+	Subject   string    `json:"subject"`
+	IP        string    `json:"ip,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
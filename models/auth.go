@@ -1,9 +1,16 @@
 package models
 
+import "time"
+
 // LoginRequest represents a login request
 type LoginRequest struct {
 	Email    string
 	Password string
+	// ClientIP is set by the server from the connection, or from X-Forwarded-For when the
+	// connection comes from a trusted proxy. failed logins are counted per ip.
+	ClientIP string `json:"-"`
+	// UserAgent is set by the server from the headers, it is shown in the session list.
+	UserAgent string `json:"-"`
 }
 
 // SignUpRequest requests represents a request
@@ -13,3 +20,53 @@ type SignUpRequest struct {
 	Password string
 	Name     string
 }
+
+// RefreshRequest represents a request to exchange
+// a refresh token for a new pair of tokens.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenPair is returned on a successful login or refresh,
+// tokens issued to OAuth clients carry their scope and,
+// for the client credentials grant, no refresh token.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// RefreshToken represents the stored state of an issued refresh token.
+// All tokens rotated from the same login share a FamilyID.
+type RefreshToken struct {
+	Email      string    `json:"email"`
+	FamilyID   string    `json:"family_id"`
+	Generation int64     `json:"generation"`
+	Used       bool      `json:"used"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Grant is set for refresh tokens issued to an OAuth client.
+	Grant *Grant `json:"grant,omitempty"`
+}
+
+// ClientID returns the OAuth client the token was issued to,
+// empty for the tokens of a login.
+func (t *RefreshToken) ClientID() string {
+	if t.Grant == nil {
+		return ""
+	}
+	return t.Grant.ClientID
+}
+
+// ForgotPasswordRequest represents a request for a password reset token.
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest represents a request to set a new
+// password using a password reset token.
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
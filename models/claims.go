@@ -0,0 +1,74 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// errors returned by Claims.Validate.
+var (
+	ErrClaimsIssuer    = errors.New("token has an unexpected issuer")
+	ErrClaimsAudience  = errors.New("token is not intended for this audience")
+	ErrClaimsExpired   = errors.New("token is expired")
+	ErrClaimsNotBefore = errors.New("token is not valid yet")
+	ErrClaimsIssuedAt  = errors.New("token is issued in the future")
+	ErrClaimsID        = errors.New("token has no id")
+)
+
+// Claims are the claims of an access token.
+type Claims struct {
+	jwt.StandardClaims
+	Email string   `json:"email"`
+	Roles []string `json:"roles"`
+	// Generation is the session generation of the user when the token was issued.
+	Generation int64 `json:"gen"`
+	// FamilyID identifies the login session of the token.
+	FamilyID string `json:"fid"`
+	// ClientID is set for tokens issued to an OAuth client, the token
+	// then only grants the permissions in Scope, separated by spaces.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// Valid satisfies jwt.Claims, validation depends on the configuration
+// so it is done with Validate once the signature is verified.
+func (c *Claims) Valid() error {
+	return nil
+}
+
+// Validate checks the registered claims at now,
+// tolerating skew between the clocks of the issuer and now.
+func (c *Claims) Validate(issuer, audience string, now time.Time, skew time.Duration) error {
+	if c.Issuer != issuer {
+		return ErrClaimsIssuer
+	}
+	if c.Audience != audience {
+		return ErrClaimsAudience
+	}
+	if c.Id == "" {
+		return ErrClaimsID
+	}
+
+	if c.ExpiresAt == 0 || now.Add(-skew).After(time.Unix(c.ExpiresAt, 0)) {
+		return ErrClaimsExpired
+	}
+	if c.NotBefore != 0 && now.Add(skew).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrClaimsNotBefore
+	}
+	if c.IssuedAt == 0 || now.Add(skew).Before(time.Unix(c.IssuedAt, 0)) {
+		return ErrClaimsIssuedAt
+	}
+	return nil
+}
+
+// Delegated reports whether the token was issued to an OAuth client.
+func (c *Claims) Delegated() bool {
+	return c.ClientID != ""
+}
+
+// ExpiresIn returns the remaining lifetime of the token at now.
+func (c *Claims) ExpiresIn(now time.Time) time.Duration {
+	return time.Unix(c.ExpiresAt, 0).Sub(now)
+}
@@ -0,0 +1,54 @@
+// +build !integration
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaims_Validate(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	skew := time.Second * 30
+
+	valid := func() *Claims {
+		return &Claims{StandardClaims: jwt.StandardClaims{
+			Id:        "id",
+			Issuer:    "icm",
+			Audience:  "gicicm",
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(time.Minute).Unix(),
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		change   func(c *Claims)
+		now      time.Time
+		expected error
+	}{
+		{"valid", func(c *Claims) {}, now, nil},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "other" }, now, ErrClaimsIssuer},
+		{"wrong audience", func(c *Claims) { c.Audience = "other" }, now, ErrClaimsAudience},
+		{"no id", func(c *Claims) { c.Id = "" }, now, ErrClaimsID},
+		{"no expiry", func(c *Claims) { c.ExpiresAt = 0 }, now, ErrClaimsExpired},
+		{"expired within skew", func(c *Claims) {}, now.Add(time.Minute + skew), nil},
+		{"expired", func(c *Claims) {}, now.Add(time.Minute + skew + time.Second), ErrClaimsExpired},
+		{"not before within skew", func(c *Claims) {}, now.Add(-skew), nil},
+		{"not before", func(c *Claims) { c.IssuedAt = 0 }, now.Add(-skew - time.Second), ErrClaimsNotBefore},
+		{"issued in the future", func(c *Claims) { c.NotBefore = 0 }, now.Add(-skew - time.Second), ErrClaimsIssuedAt},
+		{"no issued at", func(c *Claims) { c.IssuedAt = 0 }, now, ErrClaimsIssuedAt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.change(claims)
+			assert.Equal(t, tt.expected, claims.Validate("icm", "gicicm", tt.now, skew))
+		})
+	}
+}
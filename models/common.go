@@ -1,8 +1,18 @@
 package models
 
-// RequestMetaData
+// RequestMetaData contains details about the authenticated user of a request.
 type RequestMetaData struct {
-	IsAdmin bool
-	Email   string
-	Token   string
+	Roles []string
+	Email string
+	// Claims are the claims of the access token of the request,
+	// nil if the request is authenticated by an api key.
+	Claims *Claims
+	// APIKey is the api key of the request, nil for access tokens.
+	APIKey *APIKey
+}
+
+// Login reports whether the request is authenticated by the access token
+// of a login, rather than by an api key or a token of an OAuth client.
+func (m *RequestMetaData) Login() bool {
+	return m.Claims != nil && !m.Claims.Delegated()
 }
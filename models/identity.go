@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Identity links a user to an account at an OpenID provider,
+// the account is identified by the issuer and the subject of its ID tokens.
+type Identity struct {
+	Email     string
+	Issuer    string
+	Subject   string
+	CreatedAt time.Time
+}
+
+// OIDCState is kept between an authorization request and its callback.
+type OIDCState struct {
+	Provider string `json:"provider"`
+	// Verifier is the PKCE code verifier of the request.
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	// LinkEmail is set when a signed in user links the identity to their account.
+	LinkEmail string `json:"link_email,omitempty"`
+}
+
+// OIDCCallbackRequest represents the redirect of an OpenID provider back to the service.
+type OIDCCallbackRequest struct {
+	Provider string
+	Code     string
+	State    string
+	// ClientIP and UserAgent are set by the server, they are shown in the session list.
+	ClientIP  string
+	UserAgent string
+}
+
+// OIDCAuthorization is returned when a login or the linking of an identity starts.
+type OIDCAuthorization struct {
+	AuthorizationURL string `json:"authorization_url"`
+	// State is bound to the browser of the user with a cookie, it is never returned.
+	State string `json:"-"`
+}
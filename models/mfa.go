@@ -0,0 +1,65 @@
+package models
+
+import "encoding/json"
+
+// MFAStatusPending is the status of a login waiting for a second factor.
+const MFAStatusPending = "mfa_pending"
+
+// MFA represents the TOTP enrolment of a user,
+// a secret that is not enabled is still being enrolled.
+type MFA struct {
+	Secret  string
+	Enabled bool
+}
+
+// MFAEnrollment is returned when a user starts to enrol a TOTP secret.
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAEnableRequest represents a request to finish the enrolment
+// with a code generated from the new secret.
+type MFAEnableRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes are returned once when MFA is enabled,
+// each can be used once instead of a TOTP code.
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAChallenge is returned by login instead of tokens when the user has
+// MFA enabled, the token is exchanged along with a code for the tokens.
+type MFAChallenge struct {
+	Status    string `json:"status"`
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// MFAVerifyRequest represents a request to complete a login
+// with a TOTP code or a recovery code.
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	// ClientIP and UserAgent are set by the server, they are recorded with the session.
+	ClientIP  string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+// LoginResult holds either the tokens of a login or,
+// for users with MFA enabled, the challenge to complete it.
+type LoginResult struct {
+	Tokens    *TokenPair
+	Challenge *MFAChallenge
+}
+
+// MarshalJSON encodes the challenge if there is one, the tokens otherwise.
+func (r LoginResult) MarshalJSON() ([]byte, error) {
+	if r.Challenge != nil {
+		return json.Marshal(r.Challenge)
+	}
+	return json.Marshal(r.Tokens)
+}
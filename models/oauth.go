@@ -0,0 +1,224 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// OAuth grant types a client can be registered for.
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+	GrantRefreshToken      = "refresh_token"
+)
+
+// Answers of a user to a consent prompt.
+const (
+	ConsentApprove = "approve"
+	ConsentDeny    = "deny"
+)
+
+// Client is an application registered to obtain tokens from the service
+// on behalf of its users, or on its own with the client credentials grant.
+type Client struct {
+	ClientID string `json:"client_id"`
+	Name     string `json:"name"`
+	// RedirectURIs are matched exactly against the redirect_uri of authorization requests.
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	// Scopes are the permissions the client may request.
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// SecretHash is the sha256 hash of the secret of a confidential
+	// client, it is empty for public clients.
+	SecretHash string `json:"-"`
+}
+
+// Confidential reports whether the client authenticates with a secret.
+func (c *Client) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+// AllowsGrant reports whether the client is registered for a grant type.
+func (c *Client) AllowsGrant(grantType string) bool {
+	return containsAll(c.GrantTypes, []string{grantType})
+}
+
+// AllowsRedirectURI reports whether uri is a registered redirect uri of the client.
+func (c *Client) AllowsRedirectURI(uri string) bool {
+	return containsAll(c.RedirectURIs, []string{uri})
+}
+
+// AllowsScopes reports whether the client may request all the scopes.
+func (c *Client) AllowsScopes(scopes []string) bool {
+	return containsAll(c.Scopes, scopes)
+}
+
+// CreateClientRequest represents a request to register a client.
+type CreateClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes"`
+	// Public clients, e.g. single page and mobile apps, can not keep
+	// a secret, they only get tokens with authorization_code and PKCE.
+	Public bool `json:"public"`
+}
+
+// CreatedClient is returned once when a client is registered,
+// ClientSecret is not stored and can not be shown again.
+type CreatedClient struct {
+	*Client
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// Consent records the scopes a user granted to a client.
+type Consent struct {
+	Email     string
+	ClientID  string
+	Scopes    []string
+	CreatedAt time.Time
+}
+
+// Covers reports whether the consent was given for all the scopes.
+func (c *Consent) Covers(scopes []string) bool {
+	return containsAll(c.Scopes, scopes)
+}
+
+// AuthorizeRequest represents an authorization request
+// of the authorization code grant with PKCE (RFC 6749 and RFC 7636).
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type" json:"response_type"`
+	ClientID            string `form:"client_id" json:"client_id"`
+	RedirectURI         string `form:"redirect_uri" json:"redirect_uri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+	// Consent is the answer of the user to the consent prompt, approve or deny.
+	Consent string `form:"-" json:"consent"`
+
+	// Email is set by the server to the signed in user.
+	Email string `form:"-" json:"-"`
+	// UserScopes are set by the server to the permissions of the user,
+	// only those can be granted to a client.
+	UserScopes []string `form:"-" json:"-"`
+}
+
+// Authorization is the result of an authorization request: either the user
+// has to consent to the scopes, or the user agent is sent back to the client.
+type Authorization struct {
+	// RedirectTo is the redirect uri of the client with the code or the error.
+	RedirectTo string         `json:"redirect_to,omitempty"`
+	Consent    *ConsentPrompt `json:"consent,omitempty"`
+}
+
+// ConsentPrompt asks the user to grant scopes to a client.
+type ConsentPrompt struct {
+	ClientID   string   `json:"client_id"`
+	ClientName string   `json:"client_name"`
+	Scopes     []string `json:"scopes"`
+}
+
+// AuthorizationCode is kept between an authorization request and
+// the token request that exchanges the code.
+type AuthorizationCode struct {
+	ClientID      string   `json:"client_id"`
+	Email         string   `json:"email"`
+	RedirectURI   string   `json:"redirect_uri"`
+	Scopes        []string `json:"scopes"`
+	CodeChallenge string   `json:"code_challenge"`
+	// RedirectURIProvided is set when the authorization request carried the redirect
+	// uri, the token request then has to carry it too (RFC 6749 section 4.1.3).
+	RedirectURIProvided bool `json:"redirect_uri_provided"`
+}
+
+// Grant is the access a user delegated to a client, it is kept
+// with the refresh tokens issued to the client.
+type Grant struct {
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
+}
+
+// TokenRequest represents a token request of any grant type (RFC 6749 section 4).
+type TokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	Scope        string `form:"scope"`
+
+	Credentials ClientCredentials `form:"-"`
+	// ClientIP and UserAgent are set by the server, they are shown in the session list.
+	ClientIP  string `form:"-"`
+	UserAgent string `form:"-"`
+}
+
+// ClientCredentials authenticate a client, from the basic authorization
+// header or the request body. ClientSecret is empty for public clients.
+type ClientCredentials struct {
+	ClientID     string
+	ClientSecret string
+}
+
+// TokenActionRequest represents an introspection (RFC 7662)
+// or a revocation (RFC 7009) request.
+type TokenActionRequest struct {
+	Token         string `form:"token"`
+	TokenTypeHint string `form:"token_type_hint"`
+
+	Credentials ClientCredentials `form:"-"`
+}
+
+// Introspection describes a token to the client that introspects it,
+// only Active is set for tokens that are not active (RFC 7662 section 2.2).
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	TokenID   string `json:"jti,omitempty"`
+}
+
+// OAuthError is the error response of the OAuth endpoints (RFC 6749 section 5.2).
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// ParseScope splits a space separated scope parameter, dropping duplicates.
+func ParseScope(scope string) []string {
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if !containsAll(scopes, []string{s}) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// containsAll reports whether every value is in set.
+func containsAll(set, values []string) bool {
+	for _, v := range values {
+		found := false
+		for _, s := range set {
+			if s == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
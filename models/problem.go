@@ -0,0 +1,16 @@
+package models
+
+import "gicicm/apperrors"
+
+// Problem is an RFC 7807 problem details response body.
+type Problem struct {
+	Type     string                 `json:"type"`
+	Title    string                 `json:"title"`
+	Status   int                    `json:"status"`
+	Detail   string                 `json:"detail"`
+	Instance string                 `json:"instance"`
+	Code     string                 `json:"code"`
+	Errors   []apperrors.FieldError `json:"errors,omitempty"`
+	// RetryAfter is the number of seconds after which the request may be retried.
+	RetryAfter int64 `json:"retry_after,omitempty"`
+}
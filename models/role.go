@@ -0,0 +1,8 @@
+package models
+
+// roles a user can have on the platform.
+const (
+	// RoleUser is implicitly held by every user.
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
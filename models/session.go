@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Session is a login session of a user, it lives as long as
+// the refresh tokens rotated from the login and its ID is their FamilyID.
+type Session struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	// ClientID is set for the sessions of OAuth clients the user granted access to.
+	ClientID string `json:"client_id,omitempty"`
+	// Current is set when listing the sessions for the session of the request.
+	Current bool `json:"current"`
+}
+
+// Seen moves LastSeen to now unless it was moved less than interval ago,
+// it reports whether LastSeen changed.
+func (s *Session) Seen(now time.Time, interval time.Duration) bool {
+	if now.Sub(s.LastSeen) < interval {
+		return false
+	}
+	s.LastSeen = now
+	return true
+}
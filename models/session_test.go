@@ -0,0 +1,25 @@
+// +build !integration
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession_Seen(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	session := &Session{LastSeen: now}
+
+	// updates within the interval are skipped.
+	assert.False(t, session.Seen(now.Add(time.Second*59), time.Minute))
+	assert.Equal(t, now, session.LastSeen)
+
+	assert.True(t, session.Seen(now.Add(time.Minute), time.Minute))
+	assert.Equal(t, now.Add(time.Minute), session.LastSeen)
+
+	// a zero interval updates every time.
+	assert.True(t, session.Seen(now.Add(time.Minute), 0))
+}
@@ -1,9 +1,53 @@
 package models
 
+import (
+	"regexp"
+	"unicode/utf8"
+)
+
+// MaxEmailLength is the length of the email column.
+const MaxEmailLength = 40
+
+// emailPattern only checks the shape of an email, the best way
+// to validate an email is to send a mail with a verification link.
+var emailPattern = regexp.MustCompile("^[^@]+@[^@]+[.][^@]+$")
+
+// ValidEmail checks whether an email looks like one and fits the email column.
+func ValidEmail(email string) bool {
+	return utf8.RuneCountInString(email) <= MaxEmailLength && emailPattern.MatchString(email)
+}
+
 // User represents a user entity on the platform.
 type User struct {
-	ID       string `json:"id"`
-	Email    string `json:"email"`
-	Name     string `json:"name"`
-	Password string `json:"password,omitempty"`
+	ID       string   `json:"id"`
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+	Password string   `json:"password,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+}
+
+// UserUpdate represents a partial update of a user,
+// fields that are nil are left unchanged.
+type UserUpdate struct {
+	Name     *string `json:"name"`
+	Password *string `json:"password"`
+}
+
+// ListUsersRequest represents the paging, sorting and filtering
+// options for listing users.
+type ListUsersRequest struct {
+	Limit        int
+	Cursor       string
+	Sort         string
+	Descending   bool
+	EmailPrefix  string
+	NameContains string
+}
+
+// UserPage is a single page of users.
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	// TotalEstimate is the number of matching users, it is only set on the first page.
+	TotalEstimate int64 `json:"total_estimate,omitempty"`
 }
@@ -0,0 +1,22 @@
+// +build !integration
+
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidEmail(t *testing.T) {
+	assert.True(t, ValidEmail("clayton@test.com"))
+	assert.True(t, ValidEmail(strings.Repeat("a", 31)+"@mail.com"))
+
+	assert.False(t, ValidEmail(""))
+	assert.False(t, ValidEmail("clayton"))
+	assert.False(t, ValidEmail("clayton@test"))
+	assert.False(t, ValidEmail("clay@ton@test.com"))
+	// longer than the email column.
+	assert.False(t, ValidEmail(strings.Repeat("a", 32)+"@mail.com"))
+}
@@ -0,0 +1,83 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// DiscoveryPath is where a provider publishes its metadata, relative to its issuer.
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+// maxResponseSize limits the size of the documents read from a provider.
+const maxResponseSize = 1 << 20
+
+// ErrIssuerMismatch is returned when a discovery document or
+// an ID token was issued by another issuer than the configured one.
+var ErrIssuerMismatch = errors.New("issuer does not match")
+
+// Discovery is the provider metadata used by a relying party
+// (OpenID Connect Discovery 1.0, section 3).
+type Discovery struct {
+	Issuer                           string   `json:"issuer"`
+	AuthorizationEndpoint            string   `json:"authorization_endpoint"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	UserinfoEndpoint                 string   `json:"userinfo_endpoint,omitempty"`
+	ScopesSupported                  []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported           []string `json:"response_types_supported,omitempty"`
+	CodeChallengeMethodsSupported    []string `json:"code_challenge_methods_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// Discover fetches the discovery document of an issuer, the issuer of
+// the document must be the one it was fetched for.
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Discovery, error) {
+	discovery := new(Discovery)
+	err := getJSON(ctx, client, strings.TrimSuffix(issuer, "/")+DiscoveryPath, discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	if discovery.Issuer != issuer {
+		return nil, fmt.Errorf("%w: discovered %q for %q", ErrIssuerMismatch, discovery.Issuer, issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %s is incomplete", issuer)
+	}
+	return discovery, nil
+}
+
+// getJSON decodes the JSON response of a GET request into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", url, resp.StatusCode)
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return fmt.Errorf("GET %s: %v", url, err)
+	}
+	return nil
+}
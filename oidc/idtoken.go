@@ -0,0 +1,105 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ID token validation errors.
+var (
+	ErrAudienceMismatch = errors.New("token was not issued for this client")
+	ErrNonceMismatch    = errors.New("nonce does not match")
+	ErrMissingSubject   = errors.New("token has no subject")
+	ErrTokenExpired     = errors.New("token is expired")
+	ErrTokenIssuedAt    = errors.New("token was issued in the future")
+)
+
+// Audience is the aud claim, a single string or an array of strings.
+type Audience []string
+
+// UnmarshalJSON accepts both forms of the claim.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	err := json.Unmarshal(data, &multiple)
+	if err != nil {
+		return fmt.Errorf("aud must be a string or an array of strings")
+	}
+	*a = multiple
+	return nil
+}
+
+// MarshalJSON writes a single audience as a string.
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// Contains checks whether the audience includes value.
+func (a Audience) Contains(value string) bool {
+	return contains(a, value)
+}
+
+// IDToken holds the claims of an ID token (OpenID Connect Core 1.0, section 2)
+// and the standard claims describing the user.
+type IDToken struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        Audience `json:"aud"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce,omitempty"`
+
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+}
+
+// Valid implements jwt.Claims, the claims are checked
+// by Validate once the signature has been verified.
+func (t *IDToken) Valid() error {
+	return nil
+}
+
+// Validate checks the claims of a token received by the client
+// with the given id (OpenID Connect Core 1.0, section 3.1.3.7).
+// skew is the tolerated clock difference with the issuer.
+func (t *IDToken) Validate(issuer, clientID, nonce string, now time.Time, skew time.Duration) error {
+	if t.Issuer != issuer {
+		return ErrIssuerMismatch
+	}
+	if t.Subject == "" {
+		return ErrMissingSubject
+	}
+
+	// with several audiences the client must be the authorized party.
+	if !t.Audience.Contains(clientID) {
+		return ErrAudienceMismatch
+	}
+	if (len(t.Audience) > 1 || t.AuthorizedParty != "") && t.AuthorizedParty != clientID {
+		return ErrAudienceMismatch
+	}
+
+	if !now.Before(time.Unix(t.ExpiresAt, 0).Add(skew)) {
+		return ErrTokenExpired
+	}
+	if time.Unix(t.IssuedAt, 0).After(now.Add(skew)) {
+		return ErrTokenIssuedAt
+	}
+
+	// the nonce binds the token to the authorization request.
+	if t.Nonce != nonce {
+		return ErrNonceMismatch
+	}
+	return nil
+}
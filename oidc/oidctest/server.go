@@ -0,0 +1,209 @@
+// Package oidctest provides a stub OpenID provider for tests.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"gicicm/oidc"
+	"gicicm/signing"
+)
+
+// Identity is the user logging in at the provider.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// authRequest is an authorization code waiting to be exchanged.
+type authRequest struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	identity    Identity
+}
+
+// Server is a provider serving discovery, keys, authorization and token
+// endpoints. The authorization endpoint logs the current Identity in
+// without user interaction and redirects back with a code.
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+	// Modify, if set, changes the claims of the next ID tokens, e.g. to test their validation.
+	Modify func(token *oidc.IDToken)
+
+	mu       sync.Mutex
+	identity Identity
+	codes    map[string]*authRequest
+	keys     *signing.KeySet
+}
+
+// NewServer starts a provider with a single client and an RS256 key,
+// the caller should call Close when finished.
+func NewServer(clientID, clientSecret string) *Server {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	keys, err := signing.NewKeySet(signing.NewRSAKey("stub", rsaKey))
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		codes:        make(map[string]*authRequest),
+		keys:         keys,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidc.DiscoveryPath, s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Issuer returns the issuer of the provider.
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// SetIdentity sets the user logged in by the next authorization requests.
+func (s *Server) SetIdentity(identity Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identity = identity
+}
+
+// discovery serves the discovery document.
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &oidc.Discovery{
+		Issuer:                           s.URL,
+		AuthorizationEndpoint:            s.URL + "/authorize",
+		TokenEndpoint:                    s.URL + "/token",
+		JWKSURI:                          s.URL + "/jwks",
+		ScopesSupported:                  oidc.DefaultScopes,
+		ResponseTypesSupported:           []string{"code"},
+		CodeChallengeMethodsSupported:    []string{oidc.ChallengeMethod},
+		IDTokenSigningAlgValuesSupported: []string{"RS256"},
+	})
+}
+
+// jwks serves the public key.
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.keys.JWKS())
+}
+
+// authorize issues a code for the current identity.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != s.ClientID || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != oidc.ChallengeMethod || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = &authRequest{
+		redirectURI: query.Get("redirect_uri"),
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		identity:    s.identity,
+	}
+	s.mu.Unlock()
+
+	callback := redirectURI.Query()
+	callback.Set("code", code)
+	callback.Set("state", query.Get("state"))
+	redirectURI.RawQuery = callback.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token exchanges a code for an ID token, codes can be used once.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	if clientID != s.ClientID || clientSecret != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, &oidc.Error{Code: "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	request := s.codes[r.PostFormValue("code")]
+	delete(s.codes, r.PostFormValue("code"))
+	s.mu.Unlock()
+
+	if r.PostFormValue("grant_type") != "authorization_code" || request == nil ||
+		request.redirectURI != r.PostFormValue("redirect_uri") ||
+		!oidc.VerifyCodeChallenge(r.PostFormValue("code_verifier"), request.challenge) {
+		writeJSON(w, http.StatusBadRequest, &oidc.Error{Code: "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := &oidc.IDToken{
+		Issuer:        s.URL,
+		Subject:       request.identity.Subject,
+		Audience:      oidc.Audience{s.ClientID},
+		ExpiresAt:     now.Add(time.Minute * 5).Unix(),
+		IssuedAt:      now.Unix(),
+		Nonce:         request.nonce,
+		Email:         request.identity.Email,
+		EmailVerified: request.identity.EmailVerified,
+		Name:          request.identity.Name,
+	}
+	if s.Modify != nil {
+		s.Modify(claims)
+	}
+
+	idToken, err := s.keys.Sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &oidc.Token{
+		AccessToken: randomString(),
+		TokenType:   "Bearer",
+		ExpiresIn:   300,
+		IDToken:     idToken,
+	})
+}
+
+// writeJSON writes v as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// randomString returns a random url safe string.
+func randomString() string {
+	bytes := make([]byte, 16)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
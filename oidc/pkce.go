@@ -0,0 +1,35 @@
+// Package oidc implements an OpenID Connect relying party: provider
+// discovery, the authorization code flow with PKCE (RFC 7636)
+// and the validation of ID tokens.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// ChallengeMethod is the only PKCE method used, plain is never sent.
+const ChallengeMethod = "S256"
+
+// NewCodeVerifier returns a random code verifier, 43 url safe characters.
+func NewCodeVerifier() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// CodeChallenge returns the S256 challenge of a code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyCodeChallenge checks a code verifier against its S256 challenge.
+func VerifyCodeChallenge(verifier, challenge string) bool {
+	return subtle.ConstantTimeCompare([]byte(CodeChallenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,278 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gicicm/signing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// jwksRefreshInterval limits how often the keys are fetched again
+// for a token signed with an unknown key, so that forged kids can
+// not be used to flood the provider.
+const jwksRefreshInterval = time.Minute
+
+// ErrNoIDToken is returned when a token response has no ID token.
+var ErrNoIDToken = errors.New("token response has no id_token")
+
+// Error is an error response of a token endpoint (RFC 6749, section 5.2).
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// Error returns the code followed by the description, if any.
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+// Token is a successful response of a token endpoint.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// Provider is an OpenID provider the service is registered with as a client.
+// The discovery document and the keys are fetched on first use.
+type Provider struct {
+	config ProviderConfig
+	client *http.Client
+	skew   time.Duration
+	now    func() time.Time
+
+	mu          sync.Mutex
+	discovery   *Discovery
+	keys        *signing.KeySet
+	keysFetched time.Time
+}
+
+// NewProvider returns a provider, the client is used for every request
+// to the provider and skew is the tolerated clock difference with it.
+func NewProvider(config ProviderConfig, client *http.Client, skew time.Duration) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = DefaultScopes
+	}
+	return &Provider{
+		config: config,
+		client: client,
+		skew:   skew,
+		now:    time.Now,
+	}
+}
+
+// Name returns the name the provider is registered under.
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// Issuer returns the issuer of the provider.
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// AutoProvision reports whether unknown users are created on their first login.
+func (p *Provider) AutoProvision() bool {
+	return p.config.AutoProvision
+}
+
+// Discovery returns the discovery document of the provider, it is cached once fetched.
+func (p *Provider) Discovery(ctx context.Context) (*Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	discovery, err := Discover(ctx, p.client, p.config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	p.discovery = discovery
+	return discovery, nil
+}
+
+// AuthCodeURL returns the url of the authorization request that the user
+// is redirected to. state and nonce must be random and kept to check the
+// callback and the ID token, challenge is the S256 challenge of the code verifier.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	discovery, err := p.Discovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", ChallengeMethod)
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// Exchange redeems an authorization code with the code verifier of its request.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	discovery, err := p.Discovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	// public clients identify themselves in the body.
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		// client_secret_basic, the credentials are form encoded first (RFC 6749, section 2.3.1).
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		tokenErr := new(Error)
+		if json.Unmarshal(body, tokenErr) == nil && tokenErr.Code != "" {
+			return nil, tokenErr
+		}
+		return nil, fmt.Errorf("POST %s: unexpected status %d", discovery.TokenEndpoint, resp.StatusCode)
+	}
+
+	token := new(Token)
+	err = json.Unmarshal(body, token)
+	if err != nil {
+		return nil, fmt.Errorf("POST %s: %v", discovery.TokenEndpoint, err)
+	}
+	if token.IDToken == "" {
+		return nil, ErrNoIDToken
+	}
+	return token, nil
+}
+
+// VerifyIDToken verifies the signature and the claims of an ID token,
+// nonce is the one sent in the authorization request.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*IDToken, error) {
+	keys, err := p.keySet(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	idToken := new(IDToken)
+	_, err = keys.Parse(rawIDToken, idToken)
+	if unknownKey(err) {
+		// the provider may have rotated its keys.
+		keys, err = p.keySet(ctx, true)
+		if err != nil {
+			return nil, err
+		}
+		idToken = new(IDToken)
+		_, err = keys.Parse(rawIDToken, idToken)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = idToken.Validate(p.config.Issuer, p.config.ClientID, nonce, p.now(), p.skew)
+	if err != nil {
+		return nil, err
+	}
+	return idToken, nil
+}
+
+// unknownKey checks whether a token was rejected because its key is not in the key set.
+func unknownKey(err error) bool {
+	var ve *jwt.ValidationError
+	return errors.As(err, &ve) && ve.Inner == signing.ErrUnknownKey
+}
+
+// keySet returns the keys of the provider, they are fetched
+// on first use and again on refresh unless they were just fetched.
+func (p *Provider) keySet(ctx context.Context, refresh bool) (*signing.KeySet, error) {
+	discovery, err := p.Discovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if p.keys != nil && (!refresh || now.Sub(p.keysFetched) < jwksRefreshInterval) {
+		return p.keys, nil
+	}
+
+	jwks := new(signing.JWKS)
+	err = getJSON(ctx, p.client, discovery.JWKSURI, jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	// keys that can not verify signatures, e.g. encryption keys, are skipped.
+	keys := make([]*signing.Key, 0, len(jwks.Keys))
+	for i := range jwks.Keys {
+		if jwks.Keys[i].Use != "" && jwks.Keys[i].Use != "sig" {
+			continue
+		}
+		key, err := jwks.Keys[i].Key()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	keySet, err := signing.NewKeySet(keys...)
+	if err != nil {
+		return nil, fmt.Errorf("keys of %s: %v", p.config.Issuer, err)
+	}
+
+	p.keys = keySet
+	p.keysFetched = now
+	return keySet, nil
+}
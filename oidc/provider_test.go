@@ -0,0 +1,205 @@
+// +build !integration
+
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"gicicm/oidc"
+	"gicicm/oidc/oidctest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "https://icm.test/gicicm/auth/oidc/stub/callback"
+
+// newTestProvider returns a provider registered with a stub server.
+func newTestProvider(t *testing.T, server *oidctest.Server) *oidc.Provider {
+	registry, err := oidc.NewRegistry(server.Client(), time.Second, oidc.ProviderConfig{
+		Name:         "stub",
+		Issuer:       server.Issuer(),
+		ClientID:     server.ClientID,
+		ClientSecret: server.ClientSecret,
+		RedirectURL:  redirectURL,
+	})
+	require.NoError(t, err)
+	provider, ok := registry.Provider("stub")
+	require.True(t, ok)
+	return provider
+}
+
+// authorize follows an authorization request and returns the code of the callback.
+func authorize(t *testing.T, provider *oidc.Provider, state, nonce, verifier string) string {
+	authURL, err := provider.AuthCodeURL(context.TODO(), state, nonce, oidc.CodeChallenge(verifier))
+	require.NoError(t, err)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, redirectURL, callback.Scheme+"://"+callback.Host+callback.Path)
+	assert.Equal(t, state, callback.Query().Get("state"))
+	return callback.Query().Get("code")
+}
+
+func TestProvider_AuthorizationCodeFlow(t *testing.T) {
+	server := oidctest.NewServer("client", "s3cr3t:/")
+	defer server.Close()
+	server.SetIdentity(oidctest.Identity{Subject: "42", Email: "a@test.com", EmailVerified: true, Name: "A"})
+
+	provider := newTestProvider(t, server)
+	ctx := context.TODO()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", "challenge")
+	require.NoError(t, err)
+	query, _ := url.Parse(authURL)
+	assert.Equal(t, "openid email profile", query.Query().Get("scope"))
+	assert.Equal(t, "S256", query.Query().Get("code_challenge_method"))
+	assert.Equal(t, redirectURL, query.Query().Get("redirect_uri"))
+
+	verifier, err := oidc.NewCodeVerifier()
+	require.NoError(t, err)
+	assert.Len(t, verifier, 43)
+
+	code := authorize(t, provider, "state", "nonce", verifier)
+	token, err := provider.Exchange(ctx, code, verifier)
+	require.NoError(t, err)
+
+	idToken, err := provider.VerifyIDToken(ctx, token.IDToken, "nonce")
+	require.NoError(t, err)
+	assert.Equal(t, server.Issuer(), idToken.Issuer)
+	assert.Equal(t, "42", idToken.Subject)
+	assert.Equal(t, "a@test.com", idToken.Email)
+	assert.True(t, idToken.EmailVerified)
+
+	// codes are single use.
+	_, err = provider.Exchange(ctx, code, verifier)
+	var tokenErr *oidc.Error
+	require.True(t, errors.As(err, &tokenErr))
+	assert.Equal(t, "invalid_grant", tokenErr.Code)
+
+	// the code is bound to the verifier of its request.
+	other, err := oidc.NewCodeVerifier()
+	require.NoError(t, err)
+	code = authorize(t, provider, "state", "nonce", verifier)
+	_, err = provider.Exchange(ctx, code, other)
+	require.True(t, errors.As(err, &tokenErr))
+	assert.Equal(t, "invalid_grant", tokenErr.Code)
+}
+
+func TestProvider_VerifyIDToken(t *testing.T) {
+	server := oidctest.NewServer("client", "secret")
+	defer server.Close()
+	server.SetIdentity(oidctest.Identity{Subject: "42"})
+
+	tests := []struct {
+		name   string
+		modify func(token *oidc.IDToken)
+		nonce  string
+		err    error
+	}{
+		{"valid", nil, "nonce", nil},
+		{"other nonce", nil, "other", oidc.ErrNonceMismatch},
+		{"other issuer", func(token *oidc.IDToken) { token.Issuer = "https://evil.test" }, "nonce", oidc.ErrIssuerMismatch},
+		{"other audience", func(token *oidc.IDToken) { token.Audience = oidc.Audience{"other"} }, "nonce", oidc.ErrAudienceMismatch},
+		{"several audiences", func(token *oidc.IDToken) { token.Audience = oidc.Audience{"client", "other"} }, "nonce", oidc.ErrAudienceMismatch},
+		{"authorized party", func(token *oidc.IDToken) {
+			token.Audience = oidc.Audience{"client", "other"}
+			token.AuthorizedParty = "client"
+		}, "nonce", nil},
+		{"expired", func(token *oidc.IDToken) { token.ExpiresAt = time.Now().Add(-time.Minute).Unix() }, "nonce", oidc.ErrTokenExpired},
+		{"issued in the future", func(token *oidc.IDToken) { token.IssuedAt = time.Now().Add(time.Minute).Unix() }, "nonce", oidc.ErrTokenIssuedAt},
+		{"no subject", func(token *oidc.IDToken) { token.Subject = "" }, "nonce", oidc.ErrMissingSubject},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.Modify = tt.modify
+			provider := newTestProvider(t, server)
+
+			verifier, err := oidc.NewCodeVerifier()
+			require.NoError(t, err)
+			token, err := provider.Exchange(context.TODO(), authorize(t, provider, "state", "nonce", verifier), verifier)
+			require.NoError(t, err)
+
+			_, err = provider.VerifyIDToken(context.TODO(), token.IDToken, tt.nonce)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+
+	// tokens signed by another key are rejected.
+	server.Modify = nil
+	provider := newTestProvider(t, server)
+	other := oidctest.NewServer("client", "secret")
+	defer other.Close()
+	otherProvider := newTestProvider(t, other)
+
+	verifier, err := oidc.NewCodeVerifier()
+	require.NoError(t, err)
+	token, err := otherProvider.Exchange(context.TODO(), authorize(t, otherProvider, "state", "nonce", verifier), verifier)
+	require.NoError(t, err)
+	_, err = provider.VerifyIDToken(context.TODO(), token.IDToken, "nonce")
+	assert.Error(t, err)
+}
+
+func TestDiscover(t *testing.T) {
+	server := oidctest.NewServer("client", "secret")
+	defer server.Close()
+
+	discovery, err := oidc.Discover(context.TODO(), server.Client(), server.Issuer())
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/token", discovery.TokenEndpoint)
+
+	// the document must be issued for the configured issuer.
+	_, err = oidc.Discover(context.TODO(), server.Client(), server.Issuer()+"/")
+	assert.True(t, errors.Is(err, oidc.ErrIssuerMismatch))
+}
+
+func TestAudience_JSON(t *testing.T) {
+	var token oidc.IDToken
+	require.NoError(t, json.Unmarshal([]byte(`{"aud":"client"}`), &token))
+	assert.Equal(t, oidc.Audience{"client"}, token.Audience)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"aud":["client","other"]}`), &token))
+	assert.Equal(t, oidc.Audience{"client", "other"}, token.Audience)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"aud":1}`), &token))
+
+	raw, err := json.Marshal(oidc.Audience{"client"})
+	require.NoError(t, err)
+	assert.Equal(t, `"client"`, string(raw))
+}
+
+func TestNewRegistry_Invalid(t *testing.T) {
+	valid := oidc.ProviderConfig{Name: "stub", Issuer: "https://idp.test", ClientID: "client", RedirectURL: redirectURL}
+
+	invalid := []func(pc *oidc.ProviderConfig){
+		func(pc *oidc.ProviderConfig) { pc.Name = "Stub/1" },
+		func(pc *oidc.ProviderConfig) { pc.Issuer = "idp.test" },
+		func(pc *oidc.ProviderConfig) { pc.ClientID = "" },
+		func(pc *oidc.ProviderConfig) { pc.RedirectURL = "/callback" },
+		func(pc *oidc.ProviderConfig) { pc.Scopes = []string{"email"} },
+	}
+	for _, modify := range invalid {
+		pc := valid
+		modify(&pc)
+		_, err := oidc.NewRegistry(nil, 0, pc)
+		assert.Error(t, err)
+	}
+
+	_, err := oidc.NewRegistry(nil, 0, valid, valid)
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"gicicm/config"
+)
+
+// DefaultScopes are requested when a provider does not configure scopes.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// providerName restricts names to what can be used in a path segment.
+var providerName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// ProviderConfig describes a provider in the providers file.
+//
+//	[
+//	  {"name":"google","issuer":"https://accounts.google.com","client_id":"...","client_secret":"...",
+//	   "redirect_url":"https://icm.example.com/gicicm/auth/oidc/google/callback","auto_provision":true}
+//	]
+type ProviderConfig struct {
+	// Name identifies the provider in the urls, lower case letters, digits and dashes.
+	Name     string `json:"name"`
+	Issuer   string `json:"issuer"`
+	ClientID string `json:"client_id"`
+	// ClientSecret is empty for public clients, PKCE protects the code exchange either way.
+	ClientSecret string `json:"client_secret"`
+	// RedirectURL is the callback url registered with the provider.
+	RedirectURL string `json:"redirect_url"`
+	// Scopes must include openid, DefaultScopes when empty.
+	Scopes []string `json:"scopes"`
+	// AutoProvision creates a user on the first login of an unknown identity
+	// with a verified email, otherwise identities must be linked to a user first.
+	AutoProvision bool `json:"auto_provision"`
+}
+
+// validate checks that the provider can be used.
+func (pc *ProviderConfig) validate() error {
+	if !providerName.MatchString(pc.Name) {
+		return fmt.Errorf("invalid name %q, use lower case letters, digits and dashes", pc.Name)
+	}
+	if pc.ClientID == "" {
+		return fmt.Errorf("client_id is required")
+	}
+	for field, value := range map[string]string{"issuer": pc.Issuer, "redirect_url": pc.RedirectURL} {
+		u, err := url.Parse(value)
+		if err != nil || !u.IsAbs() || u.Host == "" {
+			return fmt.Errorf("%s must be an absolute url", field)
+		}
+	}
+	if len(pc.Scopes) > 0 && !contains(pc.Scopes, "openid") {
+		return fmt.Errorf("scopes must include openid")
+	}
+	return nil
+}
+
+// Registry holds the configured providers by name.
+type Registry struct {
+	providers map[string]*Provider
+}
+
+// NewRegistry returns a registry of the given providers, names must be unique.
+func NewRegistry(client *http.Client, skew time.Duration, configs ...ProviderConfig) (*Registry, error) {
+	r := &Registry{providers: make(map[string]*Provider, len(configs))}
+	for _, pc := range configs {
+		err := pc.validate()
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %v", pc.Name, err)
+		}
+		if r.providers[pc.Name] != nil {
+			return nil, fmt.Errorf("duplicate provider %s", pc.Name)
+		}
+		r.providers[pc.Name] = NewProvider(pc, client, skew)
+	}
+	return r, nil
+}
+
+// NewRegistryFromConfig loads the providers listed in OIDC_PROVIDERS_FILE,
+// without it the registry is empty and OIDC logins are disabled.
+func NewRegistryFromConfig(config *config.Config) (*Registry, error) {
+	if config.OIDCProvidersFile == "" {
+		return NewRegistry(nil, 0)
+	}
+
+	configs, err := LoadProviders(config.OIDCProvidersFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewRegistry(&http.Client{Timeout: time.Second * 10}, config.Auth.ClockSkew, configs...)
+}
+
+// LoadProviders reads a providers file.
+func LoadProviders(path string) ([]ProviderConfig, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []ProviderConfig
+	err = json.Unmarshal(raw, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return configs, nil
+}
+
+// Provider returns the provider registered under name.
+func (r *Registry) Provider(name string) (*Provider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// contains checks whether values includes value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
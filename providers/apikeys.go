@@ -0,0 +1,142 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/models"
+)
+
+const (
+	// apiKeyTag starts every api key so that leaked keys are easy to find.
+	apiKeyTag = "gic_"
+	// apiKeyPrefixLength is the length of the hex encoded lookup prefix.
+	apiKeyPrefixLength = 12
+)
+
+// CreateAPIKey creates a key for a user, the returned key is not stored
+// and can not be shown again. scopes must be validated by the caller.
+func (ap *authProvider) CreateAPIKey(ctx context.Context, email string, request *models.CreateAPIKeyRequest) (*models.CreatedAPIKey, error) {
+	bytes := make([]byte, apiKeyPrefixLength/2)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return nil, err
+	}
+	prefix := hex.EncodeToString(bytes)
+
+	secret, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	key := &models.APIKey{
+		Name:      request.Name,
+		Prefix:    prefix,
+		Scopes:    request.Scopes,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		ExpiresAt: request.ExpiresAt,
+		Email:     email,
+		Hash:      hashSecret(secret),
+	}
+	err = ap.apiKeyStore.Create(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ap.auditStore.Record(ctx, &models.AuditEntry{
+		Action:  models.AuditAPIKeyCreated,
+		Actor:   email,
+		Subject: email,
+		Details: "prefix=" + prefix,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CreatedAPIKey{
+		APIKey: key,
+		Key:    apiKeyTag + prefix + "_" + secret,
+	}, nil
+}
+
+// ListAPIKeys returns the keys of a user.
+func (ap *authProvider) ListAPIKeys(ctx context.Context, email string) ([]*models.APIKey, error) {
+	return ap.apiKeyStore.List(ctx, email)
+}
+
+// RevokeAPIKey deletes a key of a user.
+func (ap *authProvider) RevokeAPIKey(ctx context.Context, email, prefix string) error {
+	err := ap.apiKeyStore.Delete(ctx, email, prefix)
+	if err != nil {
+		return err
+	}
+
+	return ap.auditStore.Record(ctx, &models.AuditEntry{
+		Action:  models.AuditAPIKeyRevoked,
+		Actor:   email,
+		Subject: email,
+		Details: "prefix=" + prefix,
+	})
+}
+
+// AuthenticateAPIKey returns the stored key matching an api key
+// along with the current roles of its user.
+func (ap *authProvider) AuthenticateAPIKey(ctx context.Context, apiKey string) (*models.APIKey, []string, error) {
+	prefix, secret, ok := splitAPIKey(apiKey)
+	if !ok {
+		return nil, nil, apperrors.ErrInvalidAPIKey
+	}
+
+	key, err := ap.apiKeyStore.FetchByPrefix(ctx, prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key == nil {
+		return nil, nil, apperrors.ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	hash := hashSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(key.Hash)) != 1 || key.Expired(now) {
+		return nil, nil, apperrors.ErrInvalidAPIKey
+	}
+
+	user, err := ap.userStore.Fetch(ctx, key.Email)
+	if err != nil {
+		return nil, nil, apperrors.ErrInvalidAPIKey.Wrap(err)
+	}
+
+	// the last use is recorded at most once per interval,
+	// a failure must not fail the request.
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= ap.config.Auth.APIKeyTouchInterval {
+		_ = ap.apiKeyStore.Touch(ctx, prefix, now.UTC())
+	}
+
+	return key, append([]string{models.RoleUser}, user.Roles...), nil
+}
+
+// splitAPIKey splits an api key into its lookup prefix and its secret.
+func splitAPIKey(apiKey string) (string, string, bool) {
+	if !strings.HasPrefix(apiKey, apiKeyTag) {
+		return "", "", false
+	}
+	apiKey = strings.TrimPrefix(apiKey, apiKeyTag)
+
+	if len(apiKey) < apiKeyPrefixLength+2 || apiKey[apiKeyPrefixLength] != '_' {
+		return "", "", false
+	}
+	return apiKey[:apiKeyPrefixLength], apiKey[apiKeyPrefixLength+1:], true
+}
+
+// hashSecret hashes the secret part of an api key or a client secret,
+// secrets are random so a fast hash does not make them easier to guess.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,106 +2,529 @@ package providers
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
-	"gicicm/common"
+	"gicicm/adapters/notifier"
+	"gicicm/apperrors"
 	"gicicm/config"
+	"gicicm/logger"
+	"gicicm/metrics"
 	"strings"
 	"time"
 
+	"go.uber.org/zap"
+
 	"golang.org/x/crypto/bcrypt"
 
 	"gicicm/models"
+	"gicicm/oidc"
+	"gicicm/signing"
 	"gicicm/stores"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
+// causes of invalid token errors.
+var (
+	errTokenRevoked  = errors.New("token has been revoked")
+	errClientDeleted = errors.New("client of the token has been deleted")
+)
+
 // Repository layer for auth related operations.
 type AuthProvider interface {
-	Login(ctx context.Context, request *models.LoginRequest) (string, error)
-	ParseToken(ctx context.Context, token string) (map[string]interface{}, error)
-	Logout(ctx context.Context, token, email string) error
-	IsTokenRevoked(ctx context.Context, token string) bool
+	Login(ctx context.Context, request *models.LoginRequest) (*models.LoginResult, error)
+	Refresh(ctx context.Context, refreshToken string) (*models.TokenPair, error)
+	ParseToken(ctx context.Context, token string) (*models.Claims, error)
+	Logout(ctx context.Context, claims *models.Claims, refreshToken string) error
+	LogoutAll(ctx context.Context, email string) error
+	RevokeSessions(ctx context.Context, email, actor string) error
+	ListSessions(ctx context.Context, claims *models.Claims) ([]*models.Session, error)
+	RevokeSession(ctx context.Context, email, sessionID string) error
+	TouchSession(ctx context.Context, claims *models.Claims)
+	CreateAPIKey(ctx context.Context, email string, request *models.CreateAPIKeyRequest) (*models.CreatedAPIKey, error)
+	ListAPIKeys(ctx context.Context, email string) ([]*models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, email, prefix string) error
+	AuthenticateAPIKey(ctx context.Context, apiKey string) (*models.APIKey, []string, error)
+	OIDCAuthorize(ctx context.Context, providerName, linkEmail string) (*models.OIDCAuthorization, error)
+	OIDCCallback(ctx context.Context, request *models.OIDCCallbackRequest) (*models.LoginResult, error)
+	CreateClient(ctx context.Context, actor string, request *models.CreateClientRequest) (*models.CreatedClient, error)
+	ListClients(ctx context.Context) ([]*models.Client, error)
+	DeleteClient(ctx context.Context, actor, clientID string) error
+	Authorize(ctx context.Context, request *models.AuthorizeRequest) (*models.Authorization, error)
+	Token(ctx context.Context, request *models.TokenRequest) (*models.TokenPair, error)
+	Introspect(ctx context.Context, request *models.TokenActionRequest) (*models.Introspection, error)
+	RevokeToken(ctx context.Context, request *models.TokenActionRequest) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token, password string) error
+	UnlockAccount(ctx context.Context, email, actor string) error
+	EnrollMFA(ctx context.Context, email string) (*models.MFAEnrollment, error)
+	EnableMFA(ctx context.Context, email, code string) (*models.RecoveryCodes, error)
+	VerifyMFA(ctx context.Context, request *models.MFAVerifyRequest) (*models.TokenPair, error)
+	JWKS() *signing.JWKS
 }
 
 // authProvider is struct for auth Provider
 // and is responsible for communicated with the stores.
 type authProvider struct {
-	userStore stores.UserRepository
-	authStore stores.AuthRepository
-	config    *config.Config
+	userStore     stores.UserRepository
+	authStore     stores.AuthRepository
+	auditStore    stores.AuditRepository
+	mfaStore      stores.MFARepository
+	apiKeyStore   stores.APIKeyRepository
+	identityStore stores.IdentityRepository
+	clientStore   stores.ClientRepository
+	keys          *signing.KeySet
+	// identityProviders are the OpenID providers users can log in with.
+	identityProviders *oidc.Registry
+	notifier          notifier.Notifier
+	config            *config.Config
 }
 
 // NewAuthProvider returns a new instance of the auth repository.
-func NewAuthProvider(userStore stores.UserRepository, authStore stores.AuthRepository, config *config.Config) AuthProvider {
+func NewAuthProvider(userStore stores.UserRepository, authStore stores.AuthRepository, auditStore stores.AuditRepository,
+	mfaStore stores.MFARepository, apiKeyStore stores.APIKeyRepository, identityStore stores.IdentityRepository,
+	clientStore stores.ClientRepository, keys *signing.KeySet, identityProviders *oidc.Registry, notifier notifier.Notifier, config *config.Config) AuthProvider {
 	return &authProvider{
-		userStore: userStore,
-		authStore: authStore,
-		config:    config,
+		userStore:         userStore,
+		authStore:         authStore,
+		auditStore:        auditStore,
+		mfaStore:          mfaStore,
+		apiKeyStore:       apiKeyStore,
+		identityStore:     identityStore,
+		clientStore:       clientStore,
+		keys:              keys,
+		identityProviders: identityProviders,
+		notifier:          notifier,
+		config:            config,
 	}
 }
 
-// Login returns a token for a successful login of a user.
-func (ap *authProvider) Login(ctx context.Context, request *models.LoginRequest) (string, error) {
+// dummyPasswordHash is compared with the password of a login for an unknown account so that
+// it takes as long as for an existing one, it has the cost of the stored hashes.
+var dummyPasswordHash = []byte("$2a$10$rLZzCG7QDyNGTZ3MVuSgMeqGkgZq5tFDtrapmA6BEzkHfLF.GZ3uK")
+
+// Login returns a pair of tokens for a successful login of a user,
+// or an mfa challenge if the user has MFA enabled.
+func (ap *authProvider) Login(ctx context.Context, request *models.LoginRequest) (*models.LoginResult, error) {
+	// locks are checked before the password so that
+	// a locked account can not be used to guess passwords.
+	err := ap.checkLockout(ctx, request.Email, request.ClientIP)
+	if err != nil {
+		metrics.Logins.WithLabelValues(metrics.ResultFailure).Inc()
+		return nil, err
+	}
 
 	user, err := ap.userStore.Fetch(ctx, request.Email)
 	if err != nil {
-		return "", err
+		// do not reveal whether the account exists, not even by the response time.
+		if errors.Is(err, apperrors.ErrAccountNotFound) {
+			_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(request.Password))
+			return nil, ap.loginFailed(ctx, request.Email, request.ClientIP)
+		}
+		return nil, err
 	}
 
 	// compare passwords
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(request.Password))
 	if err != nil {
-		return "", errors.New(common.InvalidCredentialsError)
+		return nil, ap.loginFailed(ctx, request.Email, request.ClientIP)
+	}
+
+	result, err := ap.completeLogin(ctx, user, request.ClientIP, request.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	// failures are only counted between successful logins, with MFA
+	// the login is only successful once the code is verified.
+	if result.Tokens != nil {
+		_ = ap.authStore.ResetLoginFailures(ctx, stores.LockoutScopeAccount, request.Email)
+	}
+	return result, nil
+}
+
+// completeLogin returns the tokens of an authenticated user,
+// or an mfa challenge if the user has MFA enabled.
+func (ap *authProvider) completeLogin(ctx context.Context, user *models.User, ip, userAgent string) (*models.LoginResult, error) {
+	mfa, err := ap.mfaStore.Fetch(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	if mfa != nil && mfa.Enabled {
+		challenge, err := ap.challengeMFA(ctx, user.Email)
+		if err != nil {
+			return nil, err
+		}
+		return &models.LoginResult{Challenge: challenge}, nil
+	}
+
+	tokens, err := ap.login(ctx, user, nil, ip, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	return &models.LoginResult{Tokens: tokens}, nil
+}
+
+// login issues the tokens of a new login session from the given client,
+// grant is set when the user delegated access to an OAuth client.
+func (ap *authProvider) login(ctx context.Context, user *models.User, grant *models.Grant, ip, userAgent string) (*models.TokenPair, error) {
+	// every login starts a new refresh token family.
+	familyID, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var scopes []string
+	if grant != nil {
+		scopes = grant.Scopes
+	}
+	tokens, err := ap.issueTokens(ctx, user, familyID, grant, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		ID:        familyID,
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(ap.config.Auth.RefreshTokenTTL),
+		UserAgent: userAgent,
+		IP:        ip,
+	}
+	if grant != nil {
+		session.ClientID = grant.ClientID
+	}
+	err = ap.authStore.SaveSession(ctx, user.Email, session)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.Logins.WithLabelValues(metrics.ResultSuccess).Inc()
+	return tokens, nil
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens.
+// the presented refresh token is rotated and can not be used again,
+// presenting an already used refresh token revokes its whole family.
+func (ap *authProvider) Refresh(ctx context.Context, refreshToken string) (*models.TokenPair, error) {
+	return ap.refresh(ctx, refreshToken, "", nil)
+}
+
+// refresh rotates a refresh token issued to clientID, empty for the tokens
+// of a login. scopes narrow the access token of an OAuth client to a subset
+// of its grant, the rotated refresh token keeps the whole grant.
+func (ap *authProvider) refresh(ctx context.Context, refreshToken, clientID string, scopes []string) (*models.TokenPair, error) {
+	stored, err := ap.fetchRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
 	}
 
-	// add claims for tokens
-	claims := jwt.MapClaims{}
-	claims["iss"] = "icm"
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
-	claims["email"] = request.Email
-	claims["isAdmin"] = false
+	// tokens of a client are only refreshed by that client.
+	if stored.ClientID() != clientID {
+		return nil, apperrors.ErrInvalidRefreshToken
+	}
+	if stored.Grant != nil {
+		if scopes == nil {
+			scopes = stored.Grant.Scopes
+		}
+		if !containsAll(stored.Grant.Scopes, scopes) {
+			return nil, apperrors.ErrInvalidScope
+		}
+	}
 
-	// if email has a test.com suffix
-	// give admin privileges
-	if strings.HasSuffix(user.Email, "@test.com") {
-		claims["isAdmin"] = true
+	// the token is claimed before anything is issued so that
+	// only one of concurrent presentations can rotate it.
+	uses, err := ap.authStore.ClaimRefreshToken(ctx, refreshToken, time.Until(stored.ExpiresAt))
+	if err != nil {
+		return nil, err
+	}
+
+	if uses > 1 || stored.Used {
+		// a rotated token was replayed, it has most likely leaked
+		// so none of the tokens in the family can be trusted anymore.
+		logger.FromContext(ctx).Warn("refresh token reuse detected, revoking token family",
+			zap.String("email", stored.Email), zap.String("family", stored.FamilyID))
+		err = ap.revokeSession(ctx, stored.Email, stored.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, apperrors.ErrInvalidRefreshToken
+	}
+
+	// mark the token as used for the rest of its lifetime
+	// so that it is no longer shown as active.
+	stored.Used = true
+	err = ap.authStore.SaveRefreshToken(ctx, refreshToken, stored)
+	if err != nil {
+		return nil, err
 	}
 
-	// generate token and return
-	rawToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	token, _ := rawToken.SignedString([]byte(ap.config.SigningKey))
-	return token, nil
+	// roles are read again so that grants and revocations
+	// take effect on the next refresh.
+	user, err := ap.userStore.Fetch(ctx, stored.Email)
+	if err != nil {
+		if errors.Is(err, apperrors.ErrAccountNotFound) {
+			return nil, apperrors.ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	tokens, err := ap.issueTokens(ctx, user, stored.FamilyID, stored.Grant, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ap.extendSession(ctx, user.Email, stored.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
 }
 
-// Verify parses the token and verifies the user for further operation.
-func (ap *authProvider) ParseToken(ctx context.Context, token string) (map[string]interface{}, error) {
-	parseToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(ap.config.SigningKey), nil
+// fetchRefreshToken returns the stored state of a refresh token that is
+// neither expired nor revoked, it may have been used already.
+func (ap *authProvider) fetchRefreshToken(ctx context.Context, refreshToken string) (*models.RefreshToken, error) {
+	stored, err := ap.authStore.FetchRefreshToken(ctx, refreshToken)
+	if err != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, apperrors.ErrInvalidRefreshToken
+	}
+
+	revoked, err := ap.authStore.IsTokenFamilyRevoked(ctx, stored.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, apperrors.ErrInvalidRefreshToken
+	}
+
+	// all the sessions of the user were revoked after this token was issued.
+	generation, err := ap.userStore.SessionGeneration(ctx, stored.Email)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Generation != generation {
+		return nil, apperrors.ErrInvalidRefreshToken
+	}
+	return stored, nil
+}
+
+// issueTokens generates an access token and a refresh token belonging
+// to the given family. grant is set for the tokens of an OAuth client,
+// the access token then only carries scopes, a subset of the grant.
+func (ap *authProvider) issueTokens(ctx context.Context, user *models.User, familyID string,
+	grant *models.Grant, scopes []string) (*models.TokenPair, error) {
+	email := user.Email
+	generation, err := ap.userStore.SessionGeneration(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &models.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: email,
+		},
+		Email:      email,
+		Roles:      append([]string{models.RoleUser}, user.Roles...),
+		Generation: generation,
+		FamilyID:   familyID,
+	}
+	if grant != nil {
+		claims.ClientID = grant.ClientID
+		claims.Scope = strings.Join(scopes, " ")
+	}
+
+	tokens, err := ap.issueAccessToken(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ap.authStore.SaveRefreshToken(ctx, refreshToken, &models.RefreshToken{
+		Email:      email,
+		FamilyID:   familyID,
+		Generation: generation,
+		ExpiresAt:  time.Now().Add(ap.config.Auth.RefreshTokenTTL),
+		Grant:      grant,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	tokens.RefreshToken = refreshToken
+	return tokens, nil
+}
 
+// issueAccessToken sets the registered claims of a new access token and signs it.
+func (ap *authProvider) issueAccessToken(claims *models.Claims) (*models.TokenPair, error) {
+	// every token has a unique id so that it can be revoked on its own.
+	tokenID, err := generateOpaqueToken()
 	if err != nil {
 		return nil, err
 	}
 
-	claims, ok := parseToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, errors.New("could not parse token")
+	now := time.Now()
+	claims.Id = tokenID
+	claims.Issuer = ap.config.Auth.Issuer
+	claims.Audience = ap.config.Auth.Audience
+	claims.IssuedAt = now.Unix()
+	claims.NotBefore = now.Unix()
+	claims.ExpiresAt = now.Add(ap.config.Auth.AccessTokenTTL).Unix()
+
+	// generate token
+	accessToken, err := ap.keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenPair{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(ap.config.Auth.AccessTokenTTL.Seconds()),
+		Scope:       claims.Scope,
+	}, nil
+}
+
+// ParseToken verifies the signature and the claims of an access token
+// and checks that neither the token nor its session were revoked.
+func (ap *authProvider) ParseToken(ctx context.Context, token string) (*models.Claims, error) {
+	claims := new(models.Claims)
+	_, err := ap.keys.Parse(token, claims)
+	if err != nil {
+		return nil, apperrors.ErrInvalidToken.Wrap(err)
+	}
+
+	cfg := ap.config.Auth
+	err = claims.Validate(cfg.Issuer, cfg.Audience, time.Now(), cfg.ClockSkew)
+	if err != nil {
+		return nil, apperrors.ErrInvalidToken.Wrap(err)
+	}
+
+	revoked, err := ap.authStore.IsTokenRevoked(ctx, claims.Id)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, apperrors.ErrInvalidToken.Wrap(errTokenRevoked)
+	}
+
+	// all the sessions of the user were revoked after this token was issued,
+	// the token is rejected as well when the generation can not be checked.
+	generation, err := ap.userStore.SessionGeneration(ctx, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Generation != generation {
+		return nil, apperrors.ErrInvalidToken.Wrap(errTokenRevoked)
+	}
+
+	// the login session of the token was revoked.
+	revoked, err = ap.authStore.IsTokenFamilyRevoked(ctx, claims.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, apperrors.ErrInvalidToken.Wrap(errTokenRevoked)
+	}
+
+	if claims.Delegated() {
+		client, err := ap.clientStore.Fetch(ctx, claims.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		if client == nil {
+			return nil, apperrors.ErrInvalidToken.Wrap(errClientDeleted)
+		}
 	}
 
 	return claims, nil
 }
 
-// Logout logs a user out.
-func (ap *authProvider) Logout(ctx context.Context, token, email string) error {
-	err := ap.authStore.RevokeToken(ctx, token, email)
+// Logout logs a user out by revoking the access token with the given claims.
+// if a refresh token is given its whole family is revoked as well.
+func (ap *authProvider) Logout(ctx context.Context, claims *models.Claims, refreshToken string) error {
+	// the revocation is kept until the token would be rejected
+	// as expired, including the tolerated clock skew.
+	ttl := claims.ExpiresIn(time.Now()) + ap.config.Auth.ClockSkew
+	err := ap.authStore.RevokeToken(ctx, claims.Id, ttl)
+	if err != nil {
+		return err
+	}
+	metrics.TokenRevocations.Inc()
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	stored, err := ap.authStore.FetchRefreshToken(ctx, refreshToken)
+	if err != nil || stored.Email != claims.Email {
+		return apperrors.ErrInvalidRefreshToken
+	}
+
+	return ap.revokeSession(ctx, stored.Email, stored.FamilyID)
+}
+
+// JWKS returns the public keys that verify the access tokens.
+func (ap *authProvider) JWKS() *signing.JWKS {
+	return ap.keys.JWKS()
+}
+
+// ForgotPassword sends a single use password reset token to the user.
+// no error is returned for unknown emails so that
+// the endpoint can not be used to discover accounts.
+func (ap *authProvider) ForgotPassword(ctx context.Context, email string) error {
+	_, err := ap.userStore.Fetch(ctx, email)
 	if err != nil {
+		if errors.Is(err, apperrors.ErrAccountNotFound) {
+			logger.FromContext(ctx).Info("password reset requested for unknown account", zap.String("email", email))
+			return nil
+		}
 		return err
 	}
-	return nil
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = ap.authStore.SaveResetToken(ctx, token, email, ap.config.Auth.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	return ap.notifier.SendPasswordReset(ctx, email, token)
+}
+
+// ResetPassword consumes a password reset token, sets the new password
+// and revokes all the existing sessions of the user.
+func (ap *authProvider) ResetPassword(ctx context.Context, token, password string) error {
+	email, err := ap.authStore.ConsumeResetToken(ctx, token, ap.config.Auth.PasswordResetTTL)
+	if err != nil || email == "" {
+		return apperrors.ErrInvalidResetToken
+	}
+
+	err = ap.userStore.Update(ctx, email, &models.UserUpdate{Password: &password})
+	if err != nil {
+		if errors.Is(err, apperrors.ErrAccountNotFound) {
+			return apperrors.ErrInvalidResetToken
+		}
+		return err
+	}
+
+	return revokeSessions(ctx, ap.userStore, ap.authStore, email, metrics.ReasonPasswordReset)
 }
 
-// IsTokenRevoked checks if a token is revoked or not.
-func (ap *authProvider) IsTokenRevoked(ctx context.Context, token string) bool {
-	return ap.authStore.IsTokenRevoked(ctx, token)
+// generateOpaqueToken returns a random url safe token.
+func generateOpaqueToken() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
 }
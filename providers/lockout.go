@@ -0,0 +1,125 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/logger"
+	"gicicm/metrics"
+	"gicicm/models"
+	"gicicm/stores"
+
+	"go.uber.org/zap"
+)
+
+// lockoutMemory is how long consecutive lockouts of an account are
+// remembered, the lockout duration starts over once it has passed.
+const lockoutMemory = time.Hour * 24
+
+// checkLockout returns an error if logins from the client ip
+// or to the account are blocked.
+func (ap *authProvider) checkLockout(ctx context.Context, email, ip string) error {
+	now := time.Now()
+
+	if ip != "" {
+		until := ap.authStore.LockedUntil(ctx, stores.LockoutScopeIP, ip)
+		if until.After(now) {
+			return apperrors.ErrTooManyLoginAttempts.WithRetryAfter(until.Sub(now))
+		}
+	}
+
+	until := ap.authStore.LockedUntil(ctx, stores.LockoutScopeAccount, email)
+	if until.After(now) {
+		return apperrors.ErrAccountLocked.WithRetryAfter(until.Sub(now))
+	}
+	return nil
+}
+
+// loginFailed counts a failed login against the account and the client ip
+// and locks them once they reach their threshold. Unknown accounts are
+// counted as well so that locks do not reveal whether an account exists.
+// The counters fail open, a cache outage does not block logins.
+func (ap *authProvider) loginFailed(ctx context.Context, email, ip string) error {
+	metrics.Logins.WithLabelValues(metrics.ResultFailure).Inc()
+	lockout := ap.config.Lockout
+
+	if ip != "" && lockout.MaxIPFailures > 0 {
+		failures, err := ap.authStore.IncrementLoginFailures(ctx, stores.LockoutScopeIP, ip, lockout.FailureWindow)
+		if err == nil && failures >= int64(lockout.MaxIPFailures) {
+			ap.lock(ctx, stores.LockoutScopeIP, ip, lockout.FailureWindow, &models.AuditEntry{
+				Action:  models.AuditIPLocked,
+				Subject: ip,
+				IP:      ip,
+				Details: fmt.Sprintf("failures=%d duration=%s", failures, lockout.FailureWindow),
+			})
+			return apperrors.ErrTooManyLoginAttempts.WithRetryAfter(lockout.FailureWindow)
+		}
+	}
+
+	if lockout.MaxFailures <= 0 {
+		return apperrors.ErrInvalidCredentials
+	}
+
+	failures, err := ap.authStore.IncrementLoginFailures(ctx, stores.LockoutScopeAccount, email, lockout.FailureWindow)
+	if err != nil || failures < int64(lockout.MaxFailures) {
+		return apperrors.ErrInvalidCredentials
+	}
+
+	lockouts, err := ap.authStore.IncrementLockouts(ctx, stores.LockoutScopeAccount, email, lockoutMemory)
+	if err != nil {
+		lockouts = 1
+	}
+
+	duration := lockoutDuration(lockout.Duration, lockout.MaxDuration, lockouts)
+	ap.lock(ctx, stores.LockoutScopeAccount, email, duration, &models.AuditEntry{
+		Action:  models.AuditAccountLocked,
+		Subject: email,
+		IP:      ip,
+		Details: fmt.Sprintf("failures=%d lockouts=%d duration=%s", failures, lockouts, duration),
+	})
+	return apperrors.ErrAccountLocked.WithRetryAfter(duration)
+}
+
+// lock blocks logins for the given duration, clears the
+// failed login count and records the lock in the audit log.
+func (ap *authProvider) lock(ctx context.Context, scope, subject string, duration time.Duration, entry *models.AuditEntry) {
+	logger.FromContext(ctx).Warn("too many failed logins, locking",
+		zap.String(scope, subject), zap.Duration("duration", duration))
+
+	_ = ap.authStore.Lock(ctx, scope, subject, time.Now().Add(duration))
+	_ = ap.authStore.ResetLoginFailures(ctx, scope, subject)
+	_ = ap.auditStore.Record(ctx, entry)
+}
+
+// lockoutDuration doubles the base duration for every
+// consecutive lockout, up to max. The duration does not grow without a max.
+func lockoutDuration(base, max time.Duration, lockouts int64) time.Duration {
+	if max <= 0 {
+		return base
+	}
+
+	duration := base
+	for i := int64(1); i < lockouts && duration < max; i++ {
+		duration *= 2
+	}
+	if duration > max {
+		return max
+	}
+	return duration
+}
+
+// UnlockAccount lifts the lock of an account and clears its failed logins.
+func (ap *authProvider) UnlockAccount(ctx context.Context, email, actor string) error {
+	err := ap.authStore.Unlock(ctx, stores.LockoutScopeAccount, email)
+	if err != nil {
+		return err
+	}
+
+	return ap.auditStore.Record(ctx, &models.AuditEntry{
+		Action:  models.AuditAccountUnlocked,
+		Actor:   actor,
+		Subject: email,
+	})
+}
@@ -0,0 +1,303 @@
+package providers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/logger"
+	"gicicm/metrics"
+	"gicicm/models"
+	"gicicm/stores"
+	"gicicm/totp"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// recoveryCodeCount is the number of recovery codes issued when MFA is enabled.
+	recoveryCodeCount = 10
+	// maxMFAFailures is the number of wrong codes after which a challenge is dropped
+	// and the user has to log in with the password again. Wrong codes are also
+	// counted against the account, new challenges do not give more guesses.
+	maxMFAFailures = 5
+	// totpReplayWindow covers all the time steps in which a code is accepted.
+	totpReplayWindow = totp.Period * (2*totp.Skew + 1)
+	// sealedSecretPrefix marks the secrets encrypted with MFA_SECRET_KEY, secrets
+	// stored without a key are base32 encoded and never contain it.
+	sealedSecretPrefix = "v1:"
+)
+
+// recoveryCodeEncoding encodes recovery codes in lower case without ambiguous padding.
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// EnrollMFA starts the enrolment of a new TOTP secret, the secret
+// is only used for logins once it is enabled with a code.
+func (ap *authProvider) EnrollMFA(ctx context.Context, email string) (*models.MFAEnrollment, error) {
+	mfa, err := ap.mfaStore.Fetch(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if mfa != nil && mfa.Enabled {
+		return nil, apperrors.ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := sealMFASecret(ap.config.Auth.MFASecretKey, email, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ap.mfaStore.SaveSecret(ctx, email, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.MFAEnrollment{
+		Secret: secret,
+		URI:    totp.URI(ap.config.Auth.MFAIssuer, email, secret),
+	}, nil
+}
+
+// EnableMFA finishes the enrolment with a code generated from the new
+// secret and returns the recovery codes, they are only stored hashed.
+func (ap *authProvider) EnableMFA(ctx context.Context, email, code string) (*models.RecoveryCodes, error) {
+	mfa, err := ap.fetchMFA(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if mfa == nil {
+		return nil, apperrors.ErrMFANotEnrolled
+	}
+	if mfa.Enabled {
+		return nil, apperrors.ErrMFAAlreadyEnabled
+	}
+
+	counter, ok := totp.Validate(mfa.Secret, code, time.Now())
+	if !ok || !ap.authStore.UseTOTPCounter(ctx, email, counter, totpReplayWindow) {
+		return nil, apperrors.NewValidation(apperrors.FieldError{Field: "code", Message: "invalid code"})
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	err = ap.mfaStore.Enable(ctx, email, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.FromContext(ctx).Info("mfa enabled")
+	return &models.RecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// challengeMFA issues the challenge of a login waiting for a second factor.
+func (ap *authProvider) challengeMFA(ctx context.Context, email string) (*models.MFAChallenge, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ap.authStore.SaveMFAChallenge(ctx, token, email, ap.config.Auth.MFAChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.MFAChallenge{
+		Status:    models.MFAStatusPending,
+		MFAToken:  token,
+		ExpiresIn: int64(ap.config.Auth.MFAChallengeTTL.Seconds()),
+	}, nil
+}
+
+// VerifyMFA completes a login by exchanging an mfa challenge
+// and a TOTP or recovery code for a pair of tokens.
+func (ap *authProvider) VerifyMFA(ctx context.Context, request *models.MFAVerifyRequest) (*models.TokenPair, error) {
+	email, err := ap.authStore.FetchMFAChallenge(ctx, request.MFAToken)
+	if err != nil || email == "" {
+		return nil, apperrors.ErrInvalidMFAToken
+	}
+	ctx = logger.With(ctx, zap.String("email", email))
+
+	// a locked account can not be used to guess codes either.
+	err = ap.checkLockout(ctx, email, request.ClientIP)
+	if err != nil {
+		metrics.Logins.WithLabelValues(metrics.ResultFailure).Inc()
+		return nil, err
+	}
+
+	mfa, err := ap.fetchMFA(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if mfa == nil || !mfa.Enabled {
+		return nil, apperrors.ErrInvalidMFAToken
+	}
+
+	ok, err := ap.checkMFACode(ctx, email, mfa, request)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		failures, _ := ap.authStore.IncrementMFAFailures(ctx, request.MFAToken, ap.config.Auth.MFAChallengeTTL)
+		if failures >= maxMFAFailures {
+			logger.FromContext(ctx).Warn("too many wrong mfa codes, dropping challenge")
+			_ = ap.authStore.DeleteMFAChallenge(ctx, request.MFAToken)
+		}
+
+		// wrong codes count towards the lockout like wrong passwords.
+		err = ap.loginFailed(ctx, email, request.ClientIP)
+		if errors.Is(err, apperrors.ErrInvalidCredentials) {
+			return nil, apperrors.ErrInvalidMFACode
+		}
+		return nil, err
+	}
+	_ = ap.authStore.ResetLoginFailures(ctx, stores.LockoutScopeAccount, email)
+
+	// a challenge completes a single login.
+	err = ap.authStore.DeleteMFAChallenge(ctx, request.MFAToken)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := ap.userStore.Fetch(ctx, email)
+	if err != nil {
+		if errors.Is(err, apperrors.ErrAccountNotFound) {
+			return nil, apperrors.ErrInvalidMFAToken
+		}
+		return nil, err
+	}
+
+	return ap.login(ctx, user, nil, request.ClientIP, request.UserAgent)
+}
+
+// checkMFACode checks the recovery code of a request or else its TOTP code.
+func (ap *authProvider) checkMFACode(ctx context.Context, email string, mfa *models.MFA, request *models.MFAVerifyRequest) (bool, error) {
+	if request.RecoveryCode != "" {
+		ok, err := ap.mfaStore.ConsumeRecoveryCode(ctx, email, hashRecoveryCode(request.RecoveryCode))
+		if ok {
+			logger.FromContext(ctx).Info("login completed with a recovery code")
+		}
+		return ok, err
+	}
+
+	counter, ok := totp.Validate(mfa.Secret, request.Code, time.Now())
+	return ok && ap.authStore.UseTOTPCounter(ctx, email, counter, totpReplayWindow), nil
+}
+
+// fetchMFA returns the enrolment of a user with the secret decrypted,
+// nil if the user never enrolled.
+func (ap *authProvider) fetchMFA(ctx context.Context, email string) (*models.MFA, error) {
+	mfa, err := ap.mfaStore.Fetch(ctx, email)
+	if err != nil || mfa == nil {
+		return mfa, err
+	}
+
+	mfa.Secret, err = openMFASecret(ap.config.Auth.MFASecretKey, email, mfa.Secret)
+	if err != nil {
+		logger.FromContext(ctx).Error("error decrypting mfa secret", zap.Error(err))
+		return nil, err
+	}
+	return mfa, nil
+}
+
+// sealMFASecret encrypts a TOTP secret with AES-GCM, the email is authenticated
+// with it so that a secret can not be moved to another user. The secret is
+// returned as is without a key.
+func sealMFASecret(key []byte, email, secret string) (string, error) {
+	if len(key) == 0 {
+		return secret, nil
+	}
+
+	aead, err := newMFASecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(secret), []byte(email))
+	return sealedSecretPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// openMFASecret decrypts a secret sealed by sealMFASecret,
+// secrets stored before the key was set are returned as is.
+func openMFASecret(key []byte, email, stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedSecretPrefix) {
+		return stored, nil
+	}
+	if len(key) == 0 {
+		return "", errors.New("mfa secret is encrypted and MFA_SECRET_KEY is not set")
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedSecretPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newMFASecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("mfa secret is too short")
+	}
+
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(email))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// newMFASecretCipher returns the AES-GCM cipher of the key.
+func newMFASecretCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// generateRecoveryCode returns a random recovery code formatted as xxxxx-xxxxx.
+func generateRecoveryCode() (string, error) {
+	bytes := make([]byte, 10)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	code := recoveryCodeEncoding.EncodeToString(bytes)[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode returns the stored hash of a recovery code, codes are
+// random so a fast hash is enough. The code is normalised so that
+// it can be typed in upper case and without the dash.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
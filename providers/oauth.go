@@ -0,0 +1,481 @@
+package providers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/metrics"
+	"gicicm/models"
+	"gicicm/oidc"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// responseTypeCode is the only response type of the authorize endpoint.
+	responseTypeCode = "code"
+	// tokenTypeHintRefreshToken hints that an introspected or revoked token is a refresh token.
+	tokenTypeHintRefreshToken = "refresh_token"
+	// clientIDLength is the length of the random bytes of a client id.
+	clientIDLength = 16
+)
+
+// descriptions of OAuth errors more specific than their default message.
+const (
+	unknownClientError          = "unknown client_id"
+	unregisteredRedirectError   = "redirect_uri is not registered for the client"
+	pkceRequiredError           = "code_challenge with code_challenge_method S256 is required"
+	codeRequiredError           = "code and code_verifier are required"
+	refreshTokenRequiredError   = "refresh_token is required"
+	confidentialClientOnlyError = "only confidential clients can use this grant or endpoint"
+)
+
+// CreateClient registers an OAuth client on behalf of actor, the returned
+// secret of a confidential client is not stored and can not be shown again.
+// the request must be validated by the caller.
+func (ap *authProvider) CreateClient(ctx context.Context, actor string, request *models.CreateClientRequest) (*models.CreatedClient, error) {
+	bytes := make([]byte, clientIDLength)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &models.Client{
+		ClientID:     hex.EncodeToString(bytes),
+		Name:         request.Name,
+		RedirectURIs: request.RedirectURIs,
+		GrantTypes:   request.GrantTypes,
+		Scopes:       request.Scopes,
+		CreatedAt:    time.Now().UTC().Truncate(time.Second),
+	}
+
+	var secret string
+	if !request.Public {
+		secret, err = generateOpaqueToken()
+		if err != nil {
+			return nil, err
+		}
+		client.SecretHash = hashSecret(secret)
+	}
+
+	err = ap.clientStore.Create(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ap.auditStore.Record(ctx, &models.AuditEntry{
+		Action:  models.AuditClientCreated,
+		Actor:   actor,
+		Subject: client.ClientID,
+		Details: "name=" + client.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CreatedClient{Client: client, ClientSecret: secret}, nil
+}
+
+// ListClients returns all the registered clients.
+func (ap *authProvider) ListClients(ctx context.Context) ([]*models.Client, error) {
+	return ap.clientStore.List(ctx)
+}
+
+// DeleteClient deletes a client on behalf of actor, the tokens issued to it
+// are rejected from then on and its refresh tokens can not be used anymore.
+func (ap *authProvider) DeleteClient(ctx context.Context, actor, clientID string) error {
+	err := ap.clientStore.Delete(ctx, clientID)
+	if err != nil {
+		return err
+	}
+
+	return ap.auditStore.Record(ctx, &models.AuditEntry{
+		Action:  models.AuditClientDeleted,
+		Actor:   actor,
+		Subject: clientID,
+	})
+}
+
+// Authorize handles an authorization request of a signed in user. Errors are
+// returned until the redirect uri is known to belong to the client, from then
+// on they are sent back to the client through the redirect. The user is asked
+// to consent to scopes they have not granted to the client before, once they
+// are granted an authorization code is sent back to the client.
+func (ap *authProvider) Authorize(ctx context.Context, request *models.AuthorizeRequest) (*models.Authorization, error) {
+	client, err := ap.clientStore.Fetch(ctx, request.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, apperrors.ErrInvalidOAuthRequest.WithMessage(unknownClientError)
+	}
+
+	// the redirect uri can only be omitted if the client has a single one.
+	redirectURI := request.RedirectURI
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !client.AllowsRedirectURI(redirectURI) {
+		return nil, apperrors.ErrInvalidOAuthRequest.WithMessage(unregisteredRedirectError)
+	}
+
+	scopes := models.ParseScope(request.Scope)
+	switch {
+	case request.ResponseType != responseTypeCode:
+		return authorizationError(redirectURI, request.State, apperrors.ErrUnsupportedResponseType)
+	case !client.AllowsGrant(models.GrantAuthorizationCode):
+		return authorizationError(redirectURI, request.State, apperrors.ErrUnauthorizedClient)
+	case request.CodeChallenge == "" || request.CodeChallengeMethod != oidc.ChallengeMethod:
+		return authorizationError(redirectURI, request.State, apperrors.ErrInvalidOAuthRequest.WithMessage(pkceRequiredError))
+	case !client.AllowsScopes(scopes) || !containsAll(request.UserScopes, scopes):
+		return authorizationError(redirectURI, request.State, apperrors.ErrInvalidScope)
+	case request.Consent == models.ConsentDeny:
+		return authorizationError(redirectURI, request.State, apperrors.ErrAccessDenied)
+	}
+
+	consent, err := ap.clientStore.FetchConsent(ctx, request.Email, client.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if consent == nil || !consent.Covers(scopes) {
+		if request.Consent != models.ConsentApprove {
+			return &models.Authorization{Consent: &models.ConsentPrompt{
+				ClientID:   client.ClientID,
+				ClientName: client.Name,
+				Scopes:     scopes,
+			}}, nil
+		}
+
+		err = ap.grantConsent(ctx, request.Email, client.ClientID, consent, scopes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	code, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ap.authStore.SaveAuthorizationCode(ctx, code, &models.AuthorizationCode{
+		ClientID:      client.ClientID,
+		Email:         request.Email,
+		RedirectURI:   redirectURI,
+		Scopes:        scopes,
+		CodeChallenge: request.CodeChallenge,
+
+		RedirectURIProvided: request.RedirectURI != "",
+	}, ap.config.Auth.AuthorizationCodeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Authorization{RedirectTo: redirectWith(redirectURI, url.Values{
+		"code":  {code},
+		"state": {request.State},
+	})}, nil
+}
+
+// grantConsent adds scopes to the previous consent, if any, of a user for a client.
+func (ap *authProvider) grantConsent(ctx context.Context, email, clientID string, previous *models.Consent, scopes []string) error {
+	granted := scopes
+	if previous != nil {
+		granted = append([]string{}, previous.Scopes...)
+		for _, scope := range scopes {
+			if !containsAll(granted, []string{scope}) {
+				granted = append(granted, scope)
+			}
+		}
+	}
+
+	err := ap.clientStore.SaveConsent(ctx, &models.Consent{
+		Email:     email,
+		ClientID:  clientID,
+		Scopes:    granted,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	})
+	if err != nil {
+		return err
+	}
+
+	return ap.auditStore.Record(ctx, &models.AuditEntry{
+		Action:  models.AuditConsentGranted,
+		Actor:   email,
+		Subject: email,
+		Details: fmt.Sprintf("client_id=%s scopes=%s", clientID, strings.Join(granted, " ")),
+	})
+}
+
+// Token issues tokens to a client for an authorization code,
+// a refresh token or its own client credentials.
+func (ap *authProvider) Token(ctx context.Context, request *models.TokenRequest) (*models.TokenPair, error) {
+	client, err := ap.authenticateClient(ctx, request.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens *models.TokenPair
+	switch request.GrantType {
+	case models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials:
+		if !client.AllowsGrant(request.GrantType) {
+			return nil, apperrors.ErrUnauthorizedClient
+		}
+	default:
+		return nil, apperrors.ErrUnsupportedGrantType
+	}
+
+	switch request.GrantType {
+	case models.GrantAuthorizationCode:
+		tokens, err = ap.exchangeCode(ctx, client, request)
+	case models.GrantRefreshToken:
+		tokens, err = ap.refreshGrant(ctx, client, request)
+	default:
+		tokens, err = ap.clientCredentials(client, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.OAuthTokens.WithLabelValues(request.GrantType).Inc()
+	return tokens, nil
+}
+
+// exchangeCode exchanges an authorization code for the tokens of a new session of its
+// user, the code verifier must match the code challenge of the authorization request.
+func (ap *authProvider) exchangeCode(ctx context.Context, client *models.Client, request *models.TokenRequest) (*models.TokenPair, error) {
+	if request.Code == "" || request.CodeVerifier == "" {
+		return nil, apperrors.ErrInvalidOAuthRequest.WithMessage(codeRequiredError)
+	}
+
+	code, err := ap.authStore.ConsumeAuthorizationCode(ctx, request.Code, ap.config.Auth.AuthorizationCodeTTL)
+	if err != nil {
+		return nil, err
+	}
+	if code == nil || code.ClientID != client.ClientID {
+		return nil, apperrors.ErrInvalidGrant
+	}
+	// a redirect uri sent with the authorization request must be sent again.
+	if code.RedirectURIProvided && request.RedirectURI == "" {
+		return nil, apperrors.ErrInvalidGrant
+	}
+	if request.RedirectURI != "" && request.RedirectURI != code.RedirectURI {
+		return nil, apperrors.ErrInvalidGrant
+	}
+	if !oidc.VerifyCodeChallenge(request.CodeVerifier, code.CodeChallenge) {
+		return nil, apperrors.ErrInvalidGrant
+	}
+
+	user, err := ap.userStore.Fetch(ctx, code.Email)
+	if err != nil {
+		if errors.Is(err, apperrors.ErrAccountNotFound) {
+			return nil, apperrors.ErrInvalidGrant
+		}
+		return nil, err
+	}
+
+	return ap.login(ctx, user, &models.Grant{
+		ClientID: client.ClientID,
+		Scopes:   code.Scopes,
+	}, request.ClientIP, request.UserAgent)
+}
+
+// refreshGrant rotates a refresh token issued to the client.
+func (ap *authProvider) refreshGrant(ctx context.Context, client *models.Client, request *models.TokenRequest) (*models.TokenPair, error) {
+	if request.RefreshToken == "" {
+		return nil, apperrors.ErrInvalidOAuthRequest.WithMessage(refreshTokenRequiredError)
+	}
+
+	tokens, err := ap.refresh(ctx, request.RefreshToken, client.ClientID, models.ParseScope(request.Scope))
+	if errors.Is(err, apperrors.ErrInvalidRefreshToken) {
+		return nil, apperrors.ErrInvalidGrant
+	}
+	return tokens, err
+}
+
+// clientCredentials issues an access token to a confidential client acting on its own,
+// it only grants the scopes of the client and comes without a refresh token.
+func (ap *authProvider) clientCredentials(client *models.Client, request *models.TokenRequest) (*models.TokenPair, error) {
+	if !client.Confidential() {
+		return nil, apperrors.ErrUnauthorizedClient.WithMessage(confidentialClientOnlyError)
+	}
+
+	scopes := models.ParseScope(request.Scope)
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	if !client.AllowsScopes(scopes) {
+		return nil, apperrors.ErrInvalidScope
+	}
+
+	return ap.issueAccessToken(&models.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: client.ClientID,
+		},
+		ClientID: client.ClientID,
+		Scope:    strings.Join(scopes, " "),
+	})
+}
+
+// Introspect describes a token to a confidential client (RFC 7662). Access tokens
+// of any login or client are described, refresh tokens only to their client.
+func (ap *authProvider) Introspect(ctx context.Context, request *models.TokenActionRequest) (*models.Introspection, error) {
+	client, err := ap.authenticateClient(ctx, request.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	if !client.Confidential() {
+		return nil, apperrors.ErrUnauthorizedClient.WithMessage(confidentialClientOnlyError)
+	}
+
+	if request.TokenTypeHint != tokenTypeHintRefreshToken {
+		claims, err := ap.ParseToken(ctx, request.Token)
+		if err == nil {
+			return &models.Introspection{
+				Active:    true,
+				Scope:     claims.Scope,
+				ClientID:  claims.ClientID,
+				Username:  claims.Email,
+				TokenType: "Bearer",
+				ExpiresAt: claims.ExpiresAt,
+				IssuedAt:  claims.IssuedAt,
+				NotBefore: claims.NotBefore,
+				Subject:   claims.Subject,
+				Audience:  claims.Audience,
+				Issuer:    claims.Issuer,
+				TokenID:   claims.Id,
+			}, nil
+		}
+		if !errors.Is(err, apperrors.Unauthorized) {
+			return nil, err
+		}
+	}
+
+	stored, err := ap.fetchRefreshToken(ctx, request.Token)
+	if err != nil || stored.Used || stored.ClientID() != client.ClientID {
+		return &models.Introspection{Active: false}, nil
+	}
+
+	return &models.Introspection{
+		Active:    true,
+		Scope:     strings.Join(stored.Grant.Scopes, " "),
+		ClientID:  stored.Grant.ClientID,
+		Username:  stored.Email,
+		TokenType: tokenTypeHintRefreshToken,
+		ExpiresAt: stored.ExpiresAt.Unix(),
+		Subject:   stored.Email,
+		Issuer:    ap.config.Auth.Issuer,
+	}, nil
+}
+
+// RevokeToken revokes a token issued to a client (RFC 7009). Revoking a refresh
+// token ends its session, revoking an access token only revokes that token.
+// tokens that are invalid or issued to another client are ignored.
+func (ap *authProvider) RevokeToken(ctx context.Context, request *models.TokenActionRequest) error {
+	client, err := ap.authenticateClient(ctx, request.Credentials)
+	if err != nil {
+		return err
+	}
+
+	if request.TokenTypeHint != tokenTypeHintRefreshToken {
+		claims, err := ap.ParseToken(ctx, request.Token)
+		if err == nil {
+			if claims.ClientID != client.ClientID {
+				return nil
+			}
+			err = ap.authStore.RevokeToken(ctx, claims.Id, claims.ExpiresIn(time.Now())+ap.config.Auth.ClockSkew)
+			if err != nil {
+				return err
+			}
+			metrics.TokenRevocations.Inc()
+			return nil
+		}
+		if !errors.Is(err, apperrors.Unauthorized) {
+			return err
+		}
+	}
+
+	stored, err := ap.authStore.FetchRefreshToken(ctx, request.Token)
+	if err != nil || stored.ClientID() != client.ClientID {
+		return nil
+	}
+	return ap.revokeSession(ctx, stored.Email, stored.FamilyID)
+}
+
+// authenticateClient authenticates a client by its credentials,
+// public clients only present their id.
+func (ap *authProvider) authenticateClient(ctx context.Context, credentials models.ClientCredentials) (*models.Client, error) {
+	if credentials.ClientID == "" {
+		return nil, apperrors.ErrInvalidClient
+	}
+
+	client, err := ap.clientStore.Fetch(ctx, credentials.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, apperrors.ErrInvalidClient
+	}
+
+	if !client.Confidential() {
+		if credentials.ClientSecret != "" {
+			return nil, apperrors.ErrInvalidClient
+		}
+		return client, nil
+	}
+
+	hash := hashSecret(credentials.ClientSecret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(client.SecretHash)) != 1 {
+		return nil, apperrors.ErrInvalidClient
+	}
+	return client, nil
+}
+
+// authorizationError sends an error of an authorization request back to the client.
+func authorizationError(redirectURI, state string, err *apperrors.Error) (*models.Authorization, error) {
+	return &models.Authorization{RedirectTo: redirectWith(redirectURI, url.Values{
+		"error":             {err.Code},
+		"error_description": {err.Message},
+		"state":             {state},
+	})}, nil
+}
+
+// redirectWith adds params to the query of a registered redirect uri,
+// empty params are left out.
+func redirectWith(redirectURI string, params url.Values) string {
+	// registered redirect uris are validated to be absolute urls.
+	u, _ := url.Parse(redirectURI)
+	query := u.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// containsAll reports whether every value is in set.
+func containsAll(set, values []string) bool {
+	for _, v := range values {
+		found := false
+		for _, s := range set {
+			if s == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/logger"
+	"gicicm/metrics"
+	"gicicm/models"
+	"gicicm/oidc"
+
+	"go.uber.org/zap"
+)
+
+// maxNameLength is the length of the name column, longer
+// names of provisioned users are truncated.
+const maxNameLength = 40
+
+// OIDCAuthorize starts a login with an OpenID provider and returns the url
+// the user is redirected to with the state of the request. linkEmail is set
+// when a signed in user links an identity to their account instead.
+func (ap *authProvider) OIDCAuthorize(ctx context.Context, providerName, linkEmail string) (*models.OIDCAuthorization, error) {
+	provider, ok := ap.identityProviders.Provider(providerName)
+	if !ok {
+		return nil, apperrors.ErrProviderNotFound
+	}
+
+	state, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		return nil, oidcLoginFailed(provider, err)
+	}
+
+	err = ap.authStore.SaveOIDCState(ctx, state, &models.OIDCState{
+		Provider:  provider.Name(),
+		Verifier:  verifier,
+		Nonce:     nonce,
+		LinkEmail: linkEmail,
+	}, ap.config.Auth.OIDCStateTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &models.OIDCAuthorization{AuthorizationURL: authURL, State: state}, nil
+}
+
+// OIDCCallback completes a login with an OpenID provider: the code is
+// exchanged with the verifier of its request and the ID token is validated.
+// The identity is either linked to the user who started the request or
+// has to be linked already, unknown identities are provisioned when the
+// provider allows it. The user is then logged in like with a password.
+func (ap *authProvider) OIDCCallback(ctx context.Context, request *models.OIDCCallbackRequest) (*models.LoginResult, error) {
+	state, err := ap.authStore.ConsumeOIDCState(ctx, request.State, ap.config.Auth.OIDCStateTTL)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil || state.Provider != request.Provider {
+		return nil, apperrors.ErrInvalidOIDCState
+	}
+
+	provider, ok := ap.identityProviders.Provider(state.Provider)
+	if !ok {
+		return nil, apperrors.ErrProviderNotFound
+	}
+
+	token, err := provider.Exchange(ctx, request.Code, state.Verifier)
+	if err != nil {
+		return nil, oidcLoginFailed(provider, err)
+	}
+
+	idToken, err := provider.VerifyIDToken(ctx, token.IDToken, state.Nonce)
+	if err != nil {
+		return nil, oidcLoginFailed(provider, err)
+	}
+	ctx = logger.With(ctx, zap.String("issuer", idToken.Issuer), zap.String("subject", idToken.Subject))
+
+	var user *models.User
+	if state.LinkEmail != "" {
+		user, err = ap.linkIdentity(ctx, state.LinkEmail, idToken)
+	} else {
+		user, err = ap.identityUser(ctx, provider, idToken)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ap.completeLogin(ctx, user, request.ClientIP, request.UserAgent)
+}
+
+// linkIdentity links an identity to a user, linking it again is a no-op.
+func (ap *authProvider) linkIdentity(ctx context.Context, email string, idToken *oidc.IDToken) (*models.User, error) {
+	identity, err := ap.identityStore.Fetch(ctx, idToken.Issuer, idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil && identity.Email != email {
+		return nil, apperrors.ErrIdentityAlreadyLinked
+	}
+
+	if identity == nil {
+		err = ap.link(ctx, email, idToken, models.AuditIdentityLinked)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ap.userStore.Fetch(ctx, email)
+}
+
+// identityUser returns the user an identity is linked to. An unknown identity
+// is provisioned as a new user if the provider allows it and its verified
+// email is valid and not used by an existing user, an existing user has to link
+// the identity first as the provider is not trusted with their account.
+func (ap *authProvider) identityUser(ctx context.Context, provider *oidc.Provider, idToken *oidc.IDToken) (*models.User, error) {
+	identity, err := ap.identityStore.Fetch(ctx, idToken.Issuer, idToken.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		return ap.userStore.Fetch(ctx, identity.Email)
+	}
+
+	// emails that signup would reject, e.g. longer than the email column, are not provisioned.
+	if !provider.AutoProvision() || !idToken.EmailVerified || !models.ValidEmail(idToken.Email) {
+		return nil, apperrors.ErrIdentityNotLinked
+	}
+
+	_, err = ap.userStore.Fetch(ctx, idToken.Email)
+	if err == nil {
+		return nil, apperrors.ErrIdentityNotLinked
+	}
+	if !errors.Is(err, apperrors.ErrAccountNotFound) {
+		return nil, err
+	}
+
+	// the password is never revealed, a password login
+	// can be set up with the forgot password flow.
+	password, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	name := []rune(idToken.Name)
+	if len(name) == 0 {
+		name = []rune(idToken.Email)
+	}
+	if len(name) > maxNameLength {
+		name = name[:maxNameLength]
+	}
+
+	err = ap.userStore.Create(ctx, &models.User{Name: string(name), Email: idToken.Email, Password: password})
+	if err != nil {
+		if errors.Is(err, apperrors.ErrAccountAlreadyExists) {
+			return nil, apperrors.ErrIdentityNotLinked
+		}
+		return nil, err
+	}
+
+	err = ap.link(ctx, idToken.Email, idToken, models.AuditUserProvisioned)
+	if err != nil {
+		return nil, err
+	}
+
+	return ap.userStore.Fetch(ctx, idToken.Email)
+}
+
+// link stores an identity of a user and audits it with the given action.
+func (ap *authProvider) link(ctx context.Context, email string, idToken *oidc.IDToken, action string) error {
+	err := ap.identityStore.Link(ctx, &models.Identity{
+		Email:     email,
+		Issuer:    idToken.Issuer,
+		Subject:   idToken.Subject,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	})
+	if err != nil {
+		return err
+	}
+
+	return ap.auditStore.Record(ctx, &models.AuditEntry{
+		Action:  action,
+		Actor:   email,
+		Subject: email,
+		Details: fmt.Sprintf("issuer=%s subject=%s", idToken.Issuer, idToken.Subject),
+	})
+}
+
+// oidcLoginFailed counts a failed login with a provider, the cause
+// is logged with the rejected request but not returned to the client.
+func oidcLoginFailed(provider *oidc.Provider, err error) error {
+	metrics.Logins.WithLabelValues(metrics.ResultFailure).Inc()
+	return apperrors.ErrOIDCLoginFailed.Wrap(fmt.Errorf("provider %s: %w", provider.Name(), err))
+}
@@ -0,0 +1,122 @@
+package providers
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/metrics"
+	"gicicm/models"
+	"gicicm/stores"
+)
+
+// revokeSessions revokes every access and refresh token issued to a user so far
+// by moving the user to a new session generation.
+func revokeSessions(ctx context.Context, userStore stores.UserRepository, authStore stores.AuthRepository,
+	email, reason string) error {
+	err := userStore.IncrementSessionGeneration(ctx, email)
+	if err != nil {
+		return err
+	}
+	metrics.SessionRevocations.WithLabelValues(reason).Inc()
+
+	return authStore.DeleteSessions(ctx, email)
+}
+
+// LogoutAll logs a user out of all its sessions, including the current one.
+func (ap *authProvider) LogoutAll(ctx context.Context, email string) error {
+	return revokeSessions(ctx, ap.userStore, ap.authStore, email, metrics.ReasonLogoutAll)
+}
+
+// RevokeSessions revokes all the sessions of an existing user on behalf of actor.
+func (ap *authProvider) RevokeSessions(ctx context.Context, email, actor string) error {
+	_, err := ap.userStore.Fetch(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	err = revokeSessions(ctx, ap.userStore, ap.authStore, email, metrics.ReasonAdmin)
+	if err != nil {
+		return err
+	}
+
+	return ap.auditStore.Record(ctx, &models.AuditEntry{
+		Action:  models.AuditSessionsRevoked,
+		Actor:   actor,
+		Subject: email,
+	})
+}
+
+// ListSessions returns the sessions of the user of the claims,
+// most recently seen first, marking the session of the claims as current.
+func (ap *authProvider) ListSessions(ctx context.Context, claims *models.Claims) ([]*models.Session, error) {
+	sessions, err := ap.authStore.ListSessions(ctx, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, session := range sessions {
+		session.Current = session.ID == claims.FamilyID
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// RevokeSession revokes a single session of a user.
+func (ap *authProvider) RevokeSession(ctx context.Context, email, sessionID string) error {
+	session, err := ap.authStore.FetchSession(ctx, email, sessionID)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return apperrors.ErrSessionNotFound
+	}
+
+	return ap.revokeSession(ctx, email, sessionID)
+}
+
+// TouchSession records that the session of the claims was seen,
+// at most once per SessionTouchInterval. failures are ignored
+// as they must not fail the request.
+func (ap *authProvider) TouchSession(ctx context.Context, claims *models.Claims) {
+	session, err := ap.authStore.FetchSession(ctx, claims.Email, claims.FamilyID)
+	if err != nil || session == nil {
+		return
+	}
+
+	if session.Seen(time.Now(), ap.config.Auth.SessionTouchInterval) {
+		_ = ap.authStore.TouchSession(ctx, claims.Email, session)
+	}
+}
+
+// revokeSession revokes the refresh token family of a session,
+// which revokes its access tokens as well, and deletes the session.
+func (ap *authProvider) revokeSession(ctx context.Context, email, familyID string) error {
+	err := ap.authStore.RevokeTokenFamily(ctx, familyID, ap.config.Auth.RefreshTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	return ap.authStore.DeleteSession(ctx, email, familyID)
+}
+
+// extendSession extends a session to the lifetime of its rotated refresh token,
+// sessions of logins older than session tracking are recreated.
+func (ap *authProvider) extendSession(ctx context.Context, email, familyID string) error {
+	now := time.Now()
+
+	session, err := ap.authStore.FetchSession(ctx, email, familyID)
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		session = &models.Session{ID: familyID, CreatedAt: now}
+	}
+
+	session.LastSeen = now
+	session.ExpiresAt = now.Add(ap.config.Auth.RefreshTokenTTL)
+	return ap.authStore.SaveSession(ctx, email, session)
+}
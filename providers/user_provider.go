@@ -2,7 +2,9 @@ package providers
 
 import (
 	"context"
+	"time"
 
+	"gicicm/metrics"
 	"gicicm/models"
 	"gicicm/stores"
 )
@@ -10,20 +12,25 @@ import (
 // UserProvider is tbe Repository layer for user related operations.
 type UserProvider interface {
 	Create(ctx context.Context, user *models.User) error
-	List(ctx context.Context) ([]models.User, error)
+	List(ctx context.Context, request *models.ListUsersRequest) (*models.UserPage, error)
 	Delete(ctx context.Context, emailID string) error
+	Update(ctx context.Context, emailID string, update *models.UserUpdate) error
+	GrantRole(ctx context.Context, emailID, role string) error
+	RevokeRole(ctx context.Context, emailID, role string) error
 }
 
 // userProvider is a struct responsible for communicating with
 // all the different stores for the user related operations.
 type userProvider struct {
 	userStore stores.UserRepository
+	authStore stores.AuthRepository
 }
 
 // NewUserProvider returns a new instance of the user repository.
-func NewUserProvider(userStore stores.UserRepository) UserProvider {
+func NewUserProvider(userStore stores.UserRepository, authStore stores.AuthRepository) UserProvider {
 	return &userProvider{
 		userStore: userStore,
+		authStore: authStore,
 	}
 }
 
@@ -36,21 +43,80 @@ func (up *userProvider) Create(ctx context.Context, user *models.User) error {
 	return nil
 }
 
-// List lists all the users.
-func (up *userProvider) List(ctx context.Context) ([]models.User, error) {
-	users, err := up.userStore.List(ctx)
+// List lists a page of users.
+func (up *userProvider) List(ctx context.Context, request *models.ListUsersRequest) (*models.UserPage, error) {
+	page, err := up.userStore.List(ctx, request)
 	if err != nil {
 		return nil, err
 	}
-	return users, nil
+	return page, nil
 }
 
-// Delete deletes a user based on the id.
+// Delete deletes a user based on the id and revokes all its sessions. The session
+// generation is deleted with the user, so the token families of the sessions are
+// revoked instead, an account created again with the email can not use the tokens.
 func (up *userProvider) Delete(ctx context.Context, emailID string) error {
-	err := up.userStore.Delete(ctx, emailID)
+	sessions, err := up.authStore.ListSessions(ctx, emailID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		ttl := time.Until(session.ExpiresAt)
+		if ttl <= 0 {
+			continue
+		}
+		err = up.authStore.RevokeTokenFamily(ctx, session.ID, ttl)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = up.userStore.Delete(ctx, emailID)
 	if err != nil {
 		return err
 	}
+	metrics.SessionRevocations.WithLabelValues(metrics.ReasonUserDeleted).Inc()
 
+	return up.authStore.DeleteSessions(ctx, emailID)
+}
+
+// Update partially updates a user based on the id,
+// changing the password revokes all the sessions of the user.
+func (up *userProvider) Update(ctx context.Context, emailID string, update *models.UserUpdate) error {
+	err := up.userStore.Update(ctx, emailID, update)
+	if err != nil {
+		return err
+	}
+
+	if update.Password != nil {
+		return revokeSessions(ctx, up.userStore, up.authStore, emailID, metrics.ReasonPasswordChanged)
+	}
 	return nil
 }
+
+// GrantRole grants a role to an existing user.
+func (up *userProvider) GrantRole(ctx context.Context, emailID, role string) error {
+	_, err := up.userStore.Fetch(ctx, emailID)
+	if err != nil {
+		return err
+	}
+
+	return up.userStore.GrantRole(ctx, emailID, role)
+}
+
+// RevokeRole revokes a role from an existing user, the roles are embedded
+// in the access tokens so all the sessions of the user are revoked as well.
+func (up *userProvider) RevokeRole(ctx context.Context, emailID, role string) error {
+	_, err := up.userStore.Fetch(ctx, emailID)
+	if err != nil {
+		return err
+	}
+
+	err = up.userStore.RevokeRole(ctx, emailID, role)
+	if err != nil {
+		return err
+	}
+
+	return revokeSessions(ctx, up.userStore, up.authStore, emailID, metrics.ReasonRoleRevoked)
+}
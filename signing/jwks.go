@@ -0,0 +1,129 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// JWK is the public part of a key as a JSON web key (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON web key set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys that are not retired, including keys
+// that are not active yet so that verifiers can fetch them in advance.
+// HMAC keys are shared secrets and are never published.
+func (ks *KeySet) JWKS() *JWKS {
+	now := ks.now()
+	jwks := &JWKS{Keys: []JWK{}}
+
+	for _, key := range ks.ordered {
+		if key.retired(now) {
+			continue
+		}
+
+		jwk := JWK{
+			KeyID:     key.ID,
+			Use:       "sig",
+			Algorithm: key.Method.Alg(),
+		}
+
+		switch public := key.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encode(public.N.Bytes())
+			jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = public.Curve.Params().Name
+			jwk.X = encode(pad(public.X.Bytes(), size))
+			jwk.Y = encode(pad(public.Y.Bytes(), size))
+		default:
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+// Key returns the verification key of a published JWK, so that a key set
+// can verify tokens of another issuer. RS256 and P-256 ES256 keys are supported,
+// the algorithm is derived from the key type when alg is not set.
+func (jwk *JWK) Key() (*Key, error) {
+	switch {
+	case jwk.KeyType == "RSA" && (jwk.Algorithm == "" || jwk.Algorithm == jwt.SigningMethodRS256.Alg()):
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{
+			ID:        jwk.KeyID,
+			Method:    jwt.SigningMethodRS256,
+			VerifyKey: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())},
+		}, nil
+	case jwk.KeyType == "EC" && jwk.Curve == elliptic.P256().Params().Name &&
+		(jwk.Algorithm == "" || jwk.Algorithm == jwt.SigningMethodES256.Alg()):
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, fmt.Errorf("key %s: point is not on the curve", jwk.KeyID)
+		}
+		return &Key{ID: jwk.KeyID, Method: jwt.SigningMethodES256, VerifyKey: public}, nil
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %s %s", jwk.KeyID, jwk.KeyType, jwk.Algorithm)
+	}
+}
+
+// decode decodes base64url without padding.
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// encode encodes bytes as base64url without padding.
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// pad left pads b with zeros to size, EC coordinates have a fixed size.
+func pad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
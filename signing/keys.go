@@ -0,0 +1,171 @@
+// Package signing signs and verifies tokens with a set of keys identified
+// by kid, so that keys can be rotated without invalidating issued tokens.
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrNoSigningKey is returned when none of the keys is active.
+	ErrNoSigningKey = errors.New("no active signing key")
+	// ErrUnknownKey is returned for tokens signed with a key that is
+	// not in the set, has been retired or without a kid.
+	ErrUnknownKey = errors.New("unknown signing key")
+	// ErrAlgorithmMismatch is returned for tokens whose alg header does
+	// not match the algorithm of their key.
+	ErrAlgorithmMismatch = errors.New("signing algorithm does not match the key")
+)
+
+// Key is a key identified by ID used with a single algorithm.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// SignKey is nil for keys that are only used to verify
+	// tokens, e.g. a rotated key whose private part was removed.
+	SignKey   interface{}
+	VerifyKey interface{}
+	// ActiveFrom is when the key starts to sign tokens, a key is
+	// accepted for verification before that so that it can be published first.
+	ActiveFrom time.Time
+	// RetireAt is when tokens signed with the key are no longer accepted, zero means never.
+	RetireAt time.Time
+}
+
+// NewRSAKey returns an RS256 key.
+func NewRSAKey(id string, key *rsa.PrivateKey) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodRS256,
+		SignKey:   key,
+		VerifyKey: &key.PublicKey,
+	}
+}
+
+// NewECKey returns an ES256 key, the key must be on the P-256 curve.
+func NewECKey(id string, key *ecdsa.PrivateKey) (*Key, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("key %s: ES256 requires a P-256 key", id)
+	}
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodES256,
+		SignKey:   key,
+		VerifyKey: &key.PublicKey,
+	}, nil
+}
+
+// NewHMACKey returns an HS256 key, it is a shared secret
+// and is never published.
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		SignKey:   secret,
+		VerifyKey: secret,
+	}
+}
+
+// retired checks whether tokens signed with the key are no longer accepted.
+func (k *Key) retired(now time.Time) bool {
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+// KeySet signs tokens with its newest active key
+// and verifies them with any key that is not retired.
+type KeySet struct {
+	keys map[string]*Key
+	// ordered holds the keys from the newest to the oldest ActiveFrom.
+	ordered []*Key
+	methods []string
+	now     func() time.Time
+}
+
+// NewKeySet returns a key set, key ids must be unique.
+func NewKeySet(keys ...*Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+
+	ks := &KeySet{
+		keys: make(map[string]*Key, len(keys)),
+		now:  time.Now,
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("signing keys must have a kid")
+		}
+		if ks.keys[key.ID] != nil {
+			return nil, fmt.Errorf("duplicate signing key %s", key.ID)
+		}
+		ks.keys[key.ID] = key
+		ks.ordered = append(ks.ordered, key)
+
+		if !seen[key.Method.Alg()] {
+			seen[key.Method.Alg()] = true
+			ks.methods = append(ks.methods, key.Method.Alg())
+		}
+	}
+
+	sort.SliceStable(ks.ordered, func(i, j int) bool {
+		return ks.ordered[i].ActiveFrom.After(ks.ordered[j].ActiveFrom)
+	})
+
+	return ks, nil
+}
+
+// SigningKey returns the key that signs new tokens,
+// the active key with the latest ActiveFrom.
+func (ks *KeySet) SigningKey() (*Key, error) {
+	now := ks.now()
+	for _, key := range ks.ordered {
+		if key.SignKey != nil && !key.ActiveFrom.After(now) && !key.retired(now) {
+			return key, nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// Sign signs the claims with the signing key and sets the kid header.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key, err := ks.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.SignKey)
+}
+
+// Parse verifies a token and parses its claims. The key is selected by
+// the kid header and the alg header must match the algorithm of the key,
+// so that a token can not choose how it is verified.
+func (ks *KeySet) Parse(token string, claims jwt.Claims) (*jwt.Token, error) {
+	parser := &jwt.Parser{ValidMethods: ks.methods}
+	return parser.ParseWithClaims(token, claims, ks.keyfunc)
+}
+
+// keyfunc returns the verification key of a token.
+func (ks *KeySet) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok || key.retired(ks.now()) {
+		return nil, ErrUnknownKey
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, ErrAlgorithmMismatch
+	}
+	return key.VerifyKey, nil
+}
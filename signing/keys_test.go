@@ -0,0 +1,233 @@
+// +build !integration
+
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRSAKey(t *testing.T, id string) *Key {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return NewRSAKey(id, key)
+}
+
+func newTestECKey(t *testing.T, id string) *Key {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key, err := NewECKey(id, ecKey)
+	require.NoError(t, err)
+	return key
+}
+
+// validationError returns the inner error of a jwt validation error.
+func validationError(err error) error {
+	var ve *jwt.ValidationError
+	if errors.As(err, &ve) {
+		return ve.Inner
+	}
+	return err
+}
+
+func TestKeySet_SignAndParse(t *testing.T) {
+	for _, key := range []*Key{newTestRSAKey(t, "rsa"), newTestECKey(t, "ec"), NewHMACKey("hmac", []byte("secret"))} {
+		ks, err := NewKeySet(key)
+		require.NoError(t, err)
+
+		token, err := ks.Sign(jwt.MapClaims{"email": "clayton@test.com"})
+		require.NoError(t, err)
+
+		parsed, err := ks.Parse(token, jwt.MapClaims{})
+		require.NoError(t, err, key.ID)
+		assert.Equal(t, key.ID, parsed.Header["kid"])
+		assert.Equal(t, key.Method.Alg(), parsed.Method.Alg())
+		assert.Equal(t, "clayton@test.com", parsed.Claims.(jwt.MapClaims)["email"])
+	}
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	now := time.Now()
+	old := newTestRSAKey(t, "old")
+	old.RetireAt = now.Add(time.Hour)
+	current := newTestECKey(t, "current")
+	current.ActiveFrom = now.Add(-time.Minute)
+	next := newTestECKey(t, "next")
+	next.ActiveFrom = now.Add(time.Minute)
+
+	ks, err := NewKeySet(old, next, current)
+	require.NoError(t, err)
+
+	signing, err := ks.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, "current", signing.ID)
+
+	oldKeys, err := NewKeySet(old)
+	require.NoError(t, err)
+	oldToken, err := oldKeys.Sign(jwt.MapClaims{})
+	require.NoError(t, err)
+
+	// tokens of the old key are accepted until it is retired.
+	_, err = ks.Parse(oldToken, jwt.MapClaims{})
+	assert.NoError(t, err)
+
+	ks.now = func() time.Time { return now.Add(time.Hour) }
+	_, err = ks.Parse(oldToken, jwt.MapClaims{})
+	assert.Equal(t, ErrUnknownKey, validationError(err))
+
+	// the next key takes over once it is active.
+	signing, err = ks.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, "next", signing.ID)
+
+	// retired keys are no longer published.
+	jwks := ks.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "next", jwks.Keys[0].KeyID)
+	assert.Equal(t, "current", jwks.Keys[1].KeyID)
+}
+
+func TestKeySet_StrictAlgorithm(t *testing.T) {
+	rsaKey := newTestRSAKey(t, "rsa")
+	ks, err := NewKeySet(rsaKey)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{"email": "clayton@test.com"}
+
+	// the public key used as an HMAC secret.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "rsa"
+	token, err := forged.SignedString([]byte("public key"))
+	require.NoError(t, err)
+	_, err = ks.Parse(token, jwt.MapClaims{})
+	assert.Error(t, err)
+
+	// unsigned tokens.
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	unsigned.Header["kid"] = "rsa"
+	token, err = unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	_, err = ks.Parse(token, jwt.MapClaims{})
+	assert.Error(t, err)
+
+	// RS512 is not in the set even though the key is an RSA key.
+	other := jwt.NewWithClaims(jwt.SigningMethodRS512, claims)
+	other.Header["kid"] = "rsa"
+	token, err = other.SignedString(rsaKey.SignKey)
+	require.NoError(t, err)
+	_, err = ks.Parse(token, jwt.MapClaims{})
+	assert.Error(t, err)
+
+	// a token for another key of the set must use that key's algorithm.
+	mixed, err := NewKeySet(rsaKey, newTestECKey(t, "ec"))
+	require.NoError(t, err)
+	wrongKid := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	wrongKid.Header["kid"] = "ec"
+	token, err = wrongKid.SignedString(rsaKey.SignKey)
+	require.NoError(t, err)
+	_, err = mixed.Parse(token, jwt.MapClaims{})
+	assert.Equal(t, ErrAlgorithmMismatch, validationError(err))
+
+	// tokens without a kid.
+	noKid := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token, err = noKid.SignedString(rsaKey.SignKey)
+	require.NoError(t, err)
+	_, err = ks.Parse(token, jwt.MapClaims{})
+	assert.Equal(t, ErrUnknownKey, validationError(err))
+}
+
+func TestNewKeySet_Invalid(t *testing.T) {
+	_, err := NewKeySet()
+	assert.Error(t, err)
+
+	_, err = NewKeySet(NewHMACKey("", []byte("secret")))
+	assert.Error(t, err)
+
+	_, err = NewKeySet(NewHMACKey("a", []byte("secret")), NewHMACKey("a", []byte("other")))
+	assert.Error(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, err = NewECKey("p384", ecKey)
+	assert.Error(t, err)
+
+	// no key can sign yet.
+	future := NewHMACKey("future", []byte("secret"))
+	future.ActiveFrom = time.Now().Add(time.Hour)
+	ks, err := NewKeySet(future)
+	require.NoError(t, err)
+	_, err = ks.Sign(jwt.MapClaims{})
+	assert.Equal(t, ErrNoSigningKey, err)
+}
+
+func TestKeySet_JWKS(t *testing.T) {
+	rsaKey := newTestRSAKey(t, "rsa")
+	ecKey := newTestECKey(t, "ec")
+	ks, err := NewKeySet(rsaKey, ecKey, NewHMACKey("hmac", []byte("secret")))
+	require.NoError(t, err)
+
+	jwks := ks.JWKS()
+	require.Len(t, jwks.Keys, 2)
+
+	for _, jwk := range jwks.Keys {
+		assert.Equal(t, "sig", jwk.Use)
+		switch jwk.KeyID {
+		case "rsa":
+			assert.Equal(t, "RSA", jwk.KeyType)
+			assert.Equal(t, "RS256", jwk.Algorithm)
+			assert.Equal(t, "AQAB", jwk.E)
+			assert.Len(t, jwk.N, 342)
+		case "ec":
+			assert.Equal(t, "EC", jwk.KeyType)
+			assert.Equal(t, "ES256", jwk.Algorithm)
+			assert.Equal(t, "P-256", jwk.Curve)
+			assert.Len(t, jwk.X, 43)
+			assert.Len(t, jwk.Y, 43)
+		default:
+			t.Errorf("unexpected key %s", jwk.KeyID)
+		}
+	}
+}
+
+func TestJWK_Key(t *testing.T) {
+	rsaKey := newTestRSAKey(t, "rsa")
+	ecKey := newTestECKey(t, "ec")
+	issuer, err := NewKeySet(rsaKey, ecKey)
+	require.NoError(t, err)
+
+	// a verifier rebuilds the key set from the published keys.
+	var keys []*Key
+	for _, jwk := range issuer.JWKS().Keys {
+		key, err := jwk.Key()
+		require.NoError(t, err)
+		assert.Nil(t, key.SignKey)
+		keys = append(keys, key)
+	}
+	verifier, err := NewKeySet(keys...)
+	require.NoError(t, err)
+
+	for _, key := range []*Key{rsaKey, ecKey} {
+		signer, err := NewKeySet(key)
+		require.NoError(t, err)
+		token, err := signer.Sign(jwt.MapClaims{"sub": "a@test.com"})
+		require.NoError(t, err)
+		claims := jwt.MapClaims{}
+		_, err = verifier.Parse(token, claims)
+		require.NoError(t, err)
+		assert.Equal(t, "a@test.com", claims["sub"])
+	}
+
+	_, err = (&JWK{KeyID: "oct", KeyType: "oct"}).Key()
+	assert.Error(t, err)
+	_, err = (&JWK{KeyID: "ec", KeyType: "EC", Curve: "P-256", X: "AA", Y: "AA"}).Key()
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package signing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"gicicm/config"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// KeyConfig describes a key in the keys file, relative
+// paths are resolved against the directory of the file.
+//
+//	[
+//	  {"kid":"2020-06","alg":"RS256","public_key_file":"2020-06.pub.pem","retire_at":"2021-01-01T00:00:00Z"},
+//	  {"kid":"2020-12","alg":"ES256","private_key_file":"2020-12.pem","active_from":"2020-12-01T00:00:00Z"}
+//	]
+type KeyConfig struct {
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	// PrivateKeyFile is a PEM encoded key used to sign and verify.
+	PrivateKeyFile string `json:"private_key_file"`
+	// PublicKeyFile is a PEM encoded key only used to verify.
+	PublicKeyFile string    `json:"public_key_file"`
+	ActiveFrom    time.Time `json:"active_from"`
+	RetireAt      time.Time `json:"retire_at"`
+}
+
+// NewKeySetFromConfig loads the keys listed in SIGNING_KEYS_FILE,
+// without it SIGNING_KEY is used as a single HS256 key.
+func NewKeySetFromConfig(config *config.Config) (*KeySet, error) {
+	if config.SigningKeysFile != "" {
+		return LoadKeys(config.SigningKeysFile)
+	}
+	if config.SigningKey == "" {
+		return nil, fmt.Errorf("either SIGNING_KEYS_FILE or SIGNING_KEY is required")
+	}
+	return NewKeySet(NewHMACKey("default", []byte(config.SigningKey)))
+}
+
+// LoadKeys reads a keys file.
+func LoadKeys(path string) (*KeySet, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []KeyConfig
+	err = json.Unmarshal(raw, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	keys := make([]*Key, 0, len(configs))
+	for _, kc := range configs {
+		key, err := loadKey(filepath.Dir(path), &kc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %s: %v", path, kc.ID, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(keys...)
+}
+
+// loadKey reads the PEM file of a key.
+func loadKey(dir string, kc *KeyConfig) (*Key, error) {
+	file, private := kc.PrivateKeyFile, true
+	if file == "" {
+		file, private = kc.PublicKeyFile, false
+	}
+	if file == "" {
+		return nil, fmt.Errorf("private_key_file or public_key_file is required")
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var key *Key
+	switch {
+	case kc.Algorithm == jwt.SigningMethodRS256.Alg() && private:
+		rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		key = NewRSAKey(kc.ID, rsaKey)
+	case kc.Algorithm == jwt.SigningMethodRS256.Alg():
+		rsaKey, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		key = &Key{ID: kc.ID, Method: jwt.SigningMethodRS256, VerifyKey: rsaKey}
+	case kc.Algorithm == jwt.SigningMethodES256.Alg() && private:
+		ecKey, err := jwt.ParseECPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		key, err = NewECKey(kc.ID, ecKey)
+		if err != nil {
+			return nil, err
+		}
+	case kc.Algorithm == jwt.SigningMethodES256.Alg():
+		ecKey, err := jwt.ParseECPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		key = &Key{ID: kc.ID, Method: jwt.SigningMethodES256, VerifyKey: ecKey}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q, use RS256 or ES256", kc.Algorithm)
+	}
+
+	key.ActiveFrom = kc.ActiveFrom
+	key.RetireAt = kc.RetireAt
+	return key, nil
+}
@@ -0,0 +1,89 @@
+// +build !integration
+
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gicicm/config"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	require.NoError(t, err)
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "signing")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "old.pub.pem"), "PUBLIC KEY", der)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err = x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "current.pem"), "EC PRIVATE KEY", der)
+
+	keysFile := filepath.Join(dir, "keys.json")
+	err = ioutil.WriteFile(keysFile, []byte(`[
+		{"kid":"old","alg":"RS256","public_key_file":"old.pub.pem","retire_at":"2100-01-01T00:00:00Z"},
+		{"kid":"current","alg":"ES256","private_key_file":"current.pem","active_from":"2020-01-01T00:00:00Z"}
+	]`), 0600)
+	require.NoError(t, err)
+
+	ks, err := NewKeySetFromConfig(&config.Config{SigningKeysFile: keysFile})
+	require.NoError(t, err)
+
+	key, err := ks.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, "current", key.ID)
+	assert.Len(t, ks.JWKS().Keys, 2)
+
+	// the verification only key still verifies tokens.
+	old := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{})
+	old.Header["kid"] = "old"
+	token, err := old.SignedString(rsaKey)
+	require.NoError(t, err)
+	_, err = ks.Parse(token, jwt.MapClaims{})
+	assert.NoError(t, err)
+	assert.True(t, ks.keys["old"].RetireAt.Equal(time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	// unsupported algorithms are rejected.
+	err = ioutil.WriteFile(keysFile, []byte(`[{"kid":"x","alg":"HS256","private_key_file":"current.pem"}]`), 0600)
+	require.NoError(t, err)
+	_, err = LoadKeys(keysFile)
+	assert.Error(t, err)
+}
+
+func TestNewKeySetFromConfig_SigningKey(t *testing.T) {
+	ks, err := NewKeySetFromConfig(&config.Config{SigningKey: "secret"})
+	require.NoError(t, err)
+
+	key, err := ks.SigningKey()
+	require.NoError(t, err)
+	assert.Equal(t, "HS256", key.Method.Alg())
+	assert.Empty(t, ks.JWKS().Keys)
+
+	_, err = NewKeySetFromConfig(&config.Config{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,232 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/logger"
+	"gicicm/metrics"
+	"gicicm/models"
+
+	"go.uber.org/zap"
+)
+
+// APIKeyRepository is a repository layer for the api keys of the users.
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	List(ctx context.Context, email string) ([]*models.APIKey, error)
+	FetchByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	Delete(ctx context.Context, email, prefix string) error
+	Touch(ctx context.Context, prefix string, usedAt time.Time) error
+}
+
+// APIKeyRepo stores the hashed api keys in the api_keys table.
+type APIKeyRepo struct {
+	db *sql.DB
+
+	// rebind rewrites the $n placeholders of a query
+	// to the placeholders of the database driver.
+	rebind func(query string) string
+
+	// stmts holds the prepared statements keyed by query,
+	// they are prepared on first use and reused across calls.
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+const (
+	createAPIKeyQuery = "INSERT INTO api_keys(user_id,name,prefix,key_hash,scopes,created_at,expires_at) " +
+		"SELECT id,$2,$3,$4,$5,$6,$7 from users where email=$1"
+	listAPIKeysQuery = "SELECT u.email,k.name,k.prefix,k.key_hash,k.scopes,k.created_at,k.expires_at,k.last_used_at " +
+		"from api_keys k JOIN users u ON u.id=k.user_id where u.email=$1 ORDER BY k.id"
+	fetchAPIKeyQuery = "SELECT u.email,k.name,k.prefix,k.key_hash,k.scopes,k.created_at,k.expires_at,k.last_used_at " +
+		"from api_keys k JOIN users u ON u.id=k.user_id where k.prefix=$1"
+	deleteAPIKeyQuery = "DELETE FROM api_keys WHERE prefix=$2 AND user_id=(SELECT id from users where email=$1)"
+	touchAPIKeyQuery  = "UPDATE api_keys SET last_used_at=$2 WHERE prefix=$1"
+)
+
+// NewAPIKeyRepository returns a new instance of the api key repository
+// backed by a postgres database.
+func NewAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &APIKeyRepo{
+		db:     db,
+		rebind: func(query string) string { return query },
+		stmts:  make(map[string]*sql.Stmt),
+	}
+}
+
+// NewSQLiteAPIKeyRepository returns a new instance of the api key repository
+// backed by a sqlite database.
+func NewSQLiteAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &APIKeyRepo{
+		db:     db,
+		rebind: rebindSQLite,
+		stmts:  make(map[string]*sql.Stmt),
+	}
+}
+
+// prepare returns a prepared statement for the query,
+// the statement is prepared once and reused on subsequent calls.
+func (kr *APIKeyRepo) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if stmt, ok := kr.stmts[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := kr.db.PrepareContext(ctx, kr.rebind(query))
+	if err != nil {
+		logger.FromContext(ctx).Error("error while preparing query", zap.String("query", query), zap.Error(err))
+		return nil, err
+	}
+
+	kr.stmts[query] = stmt
+	return stmt, nil
+}
+
+// Create stores a new key of the user with the email of the key.
+func (kr *APIKeyRepo) Create(ctx context.Context, key *models.APIKey) error {
+	stmt, err := kr.prepare(ctx, createAPIKeyQuery)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	result, err := stmt.ExecContext(ctx, key.Email, key.Name, key.Prefix, key.Hash,
+		strings.Join(key.Scopes, " "), key.CreatedAt, key.ExpiresAt)
+	metrics.ObserveQuery("create_api_key", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error creating api key", zap.String("email", key.Email), zap.Error(err))
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return apperrors.ErrAccountNotFound
+	}
+	return nil
+}
+
+// List returns the keys of a user, oldest first.
+func (kr *APIKeyRepo) List(ctx context.Context, email string) ([]*models.APIKey, error) {
+	stmt, err := kr.prepare(ctx, listAPIKeysQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	rows, err := stmt.QueryContext(ctx, email)
+	metrics.ObserveQuery("list_api_keys", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error listing api keys", zap.String("email", email), zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			logger.FromContext(ctx).Error("error scanning api key", zap.String("email", email), zap.Error(err))
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// FetchByPrefix returns the key with the prefix, nil if there is none.
+func (kr *APIKeyRepo) FetchByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	stmt, err := kr.prepare(ctx, fetchAPIKeyQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	key, err := scanAPIKey(stmt.QueryRowContext(ctx, prefix))
+	metrics.ObserveQuery("fetch_api_key", start)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("error fetching api key", zap.String("prefix", prefix), zap.Error(err))
+		return nil, err
+	}
+	return key, nil
+}
+
+// Delete deletes a key of a user.
+func (kr *APIKeyRepo) Delete(ctx context.Context, email, prefix string) error {
+	stmt, err := kr.prepare(ctx, deleteAPIKeyQuery)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	result, err := stmt.ExecContext(ctx, email, prefix)
+	metrics.ObserveQuery("delete_api_key", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error deleting api key", zap.String("email", email), zap.Error(err))
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return apperrors.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// Touch records the last use of a key.
+func (kr *APIKeyRepo) Touch(ctx context.Context, prefix string, usedAt time.Time) error {
+	stmt, err := kr.prepare(ctx, touchAPIKeyQuery)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	_, err = stmt.ExecContext(ctx, prefix, usedAt)
+	metrics.ObserveQuery("touch_api_key", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error recording api key use", zap.String("prefix", prefix), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey scans a row of the api key queries.
+func scanAPIKey(row scanner) (*models.APIKey, error) {
+	key := new(models.APIKey)
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+
+	err := row.Scan(&key.Email, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.CreatedAt, &expiresAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return key, nil
+}
@@ -0,0 +1,61 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gicicm/logger"
+	"gicicm/metrics"
+	"gicicm/models"
+
+	"go.uber.org/zap"
+)
+
+// AuditRepository is a repository layer for the audit log.
+type AuditRepository interface {
+	Record(ctx context.Context, entry *models.AuditEntry) error
+}
+
+// AuditRepo appends audit entries to the audit_log table.
+type AuditRepo struct {
+	db *sql.DB
+
+	// rebind rewrites the $n placeholders of a query
+	// to the placeholders of the database driver.
+	rebind func(query string) string
+}
+
+const recordAuditQuery = "INSERT INTO audit_log(action,actor,subject,ip,details) VALUES($1,$2,$3,$4,$5)"
+
+// NewAuditRepository returns a new instance of the audit repository
+// backed by a postgres database.
+func NewAuditRepository(db *sql.DB) AuditRepository {
+	return &AuditRepo{
+		db:     db,
+		rebind: func(query string) string { return query },
+	}
+}
+
+// NewSQLiteAuditRepository returns a new instance of the audit repository
+// backed by a sqlite database.
+func NewSQLiteAuditRepository(db *sql.DB) AuditRepository {
+	return &AuditRepo{
+		db:     db,
+		rebind: rebindSQLite,
+	}
+}
+
+// Record appends an entry to the audit log.
+func (ar *AuditRepo) Record(ctx context.Context, entry *models.AuditEntry) error {
+	start := time.Now()
+	_, err := ar.db.ExecContext(ctx, ar.rebind(recordAuditQuery),
+		entry.Action, entry.Actor, entry.Subject, entry.IP, entry.Details)
+	metrics.ObserveQuery("record_audit", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error recording audit entry",
+			zap.String("action", entry.Action), zap.String("subject", entry.Subject), zap.Error(err))
+		return err
+	}
+	return nil
+}
@@ -2,19 +2,59 @@ package stores
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"gicicm/adapters/cache"
 	"gicicm/logger"
+	"gicicm/models"
 	"go.uber.org/zap"
+	"strconv"
 	"time"
 )
 
 // AuthRepository is a repository layer for all user related operations.
 type AuthRepository interface {
-	RevokeToken(ctx context.Context, token, email string) error
-	IsTokenRevoked(ctx context.Context, token string) bool
+	RevokeToken(ctx context.Context, tokenID string, ttl time.Duration) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+	SaveRefreshToken(ctx context.Context, token string, refreshToken *models.RefreshToken) error
+	FetchRefreshToken(ctx context.Context, token string) (*models.RefreshToken, error)
+	ClaimRefreshToken(ctx context.Context, token string, ttl time.Duration) (int64, error)
+	RevokeTokenFamily(ctx context.Context, familyID string, ttl time.Duration) error
+	IsTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error)
+	SaveResetToken(ctx context.Context, token, email string, ttl time.Duration) error
+	ConsumeResetToken(ctx context.Context, token string, ttl time.Duration) (string, error)
+	IncrementLoginFailures(ctx context.Context, scope, subject string, window time.Duration) (int64, error)
+	ResetLoginFailures(ctx context.Context, scope, subject string) error
+	IncrementLockouts(ctx context.Context, scope, subject string, ttl time.Duration) (int64, error)
+	Lock(ctx context.Context, scope, subject string, until time.Time) error
+	LockedUntil(ctx context.Context, scope, subject string) time.Time
+	Unlock(ctx context.Context, scope, subject string) error
+	SaveMFAChallenge(ctx context.Context, token, email string, ttl time.Duration) error
+	FetchMFAChallenge(ctx context.Context, token string) (string, error)
+	DeleteMFAChallenge(ctx context.Context, token string) error
+	IncrementMFAFailures(ctx context.Context, token string, ttl time.Duration) (int64, error)
+	UseTOTPCounter(ctx context.Context, email string, counter uint64, ttl time.Duration) bool
+	SaveSession(ctx context.Context, email string, session *models.Session) error
+	TouchSession(ctx context.Context, email string, session *models.Session) error
+	FetchSession(ctx context.Context, email, id string) (*models.Session, error)
+	ListSessions(ctx context.Context, email string) ([]*models.Session, error)
+	DeleteSession(ctx context.Context, email, id string) error
+	DeleteSessions(ctx context.Context, email string) error
+	SaveOIDCState(ctx context.Context, state string, oidcState *models.OIDCState, ttl time.Duration) error
+	ConsumeOIDCState(ctx context.Context, state string, ttl time.Duration) (*models.OIDCState, error)
+	SaveAuthorizationCode(ctx context.Context, code string, authCode *models.AuthorizationCode, ttl time.Duration) error
+	ConsumeAuthorizationCode(ctx context.Context, code string, ttl time.Duration) (*models.AuthorizationCode, error)
 }
 
+// Lockout scopes, failed logins are counted and locked per account and per client ip.
+const (
+	LockoutScopeAccount = "account"
+	LockoutScopeIP      = "ip"
+)
+
 // AuthRepo is responsible for communicating with the data stores via the adapter.
 type AuthRepo struct {
 	Cache cache.Cache
@@ -27,22 +67,569 @@ func NewAuthRepository(cache cache.Cache) AuthRepository {
 	}
 }
 
-// RevokeToken adds a token to the cache in a blacklist.
-func (ar *AuthRepo) RevokeToken(ctx context.Context, token, email string) error {
-	key := fmt.Sprintf("token:%s", token)
-	_, err := ar.Cache.Set(key, email, time.Hour*24)
+// RevokeToken adds the id of an access token to a blacklist,
+// ttl should be at least the remaining lifetime of the token.
+func (ar *AuthRepo) RevokeToken(ctx context.Context, tokenID string, ttl time.Duration) error {
+	key := fmt.Sprintf("jti:%s", tokenID)
+	_, err := ar.Cache.Set(ctx, key, "revoked", ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error revoking token", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// IsTokenRevoked checks if the access token with the given id is revoked or not.
+// only a missing key means not revoked, any other error is returned so
+// that a revoked token is not accepted while the cache is unavailable.
+func (ar *AuthRepo) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	key := fmt.Sprintf("jti:%s", tokenID)
+	_, err := ar.Cache.Get(ctx, key)
+	if errors.Is(err, cache.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("error checking token revocation", zap.String("key", key), zap.Error(err))
+		return false, err
+	}
+	return true, nil
+}
+
+// SaveRefreshToken stores the state of a refresh token until it expires.
+// only a hash of the token is used as the key so that
+// the cache never holds a usable refresh token.
+func (ar *AuthRepo) SaveRefreshToken(ctx context.Context, token string, refreshToken *models.RefreshToken) error {
+	key := refreshTokenKey(token)
+
+	bytes, err := json.Marshal(refreshToken)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while marshalling refresh token", zap.String("email", refreshToken.Email), zap.Error(err))
+		return err
+	}
+
+	_, err = ar.Cache.Set(ctx, key, string(bytes), time.Until(refreshToken.ExpiresAt))
+	if err != nil {
+		logger.FromContext(ctx).Error("error saving refresh token", zap.String("email", refreshToken.Email), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// FetchRefreshToken returns the stored state of a refresh token.
+func (ar *AuthRepo) FetchRefreshToken(ctx context.Context, token string) (*models.RefreshToken, error) {
+	val, err := ar.Cache.Get(ctx, refreshTokenKey(token))
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken := new(models.RefreshToken)
+	err = json.Unmarshal([]byte(val), refreshToken)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while unmarshalling refresh token", zap.Error(err))
+		return nil, err
+	}
+	return refreshToken, nil
+}
+
+// ClaimRefreshToken counts a presentation of a refresh token and returns the count,
+// it is counted atomically so that only one of concurrent presentations gets 1.
+// ttl should be at least the remaining lifetime of the token.
+func (ar *AuthRepo) ClaimRefreshToken(ctx context.Context, token string, ttl time.Duration) (int64, error) {
+	uses, err := ar.Cache.Incr(ctx, refreshTokenKey(token)+":uses", ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error counting refresh token use", zap.Error(err))
+		return 0, err
+	}
+	return uses, nil
+}
+
+// RevokeTokenFamily revokes all the refresh tokens rotated from the same login.
+// ttl should be at least the remaining lifetime of the tokens in the family.
+func (ar *AuthRepo) RevokeTokenFamily(ctx context.Context, familyID string, ttl time.Duration) error {
+	key := fmt.Sprintf("family:%s", familyID)
+	_, err := ar.Cache.Set(ctx, key, "revoked", ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error revoking token family", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// IsTokenFamilyRevoked checks if a token family is revoked or not.
+// only a missing key means not revoked, any other error is returned so
+// that a revoked family is not accepted while the cache is unavailable.
+func (ar *AuthRepo) IsTokenFamilyRevoked(ctx context.Context, familyID string) (bool, error) {
+	key := fmt.Sprintf("family:%s", familyID)
+	_, err := ar.Cache.Get(ctx, key)
+	if errors.Is(err, cache.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("error checking token family revocation", zap.String("key", key), zap.Error(err))
+		return false, err
+	}
+	return true, nil
+}
+
+// SaveResetToken stores a password reset token for a user until it expires.
+func (ar *AuthRepo) SaveResetToken(ctx context.Context, token, email string, ttl time.Duration) error {
+	_, err := ar.Cache.Set(ctx, resetTokenKey(token), email, ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error saving reset token", zap.String("email", email), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ConsumeResetToken returns the email a password reset token was issued for
+// and deletes it, an empty email if it does not exist, is expired or was consumed
+// already. Uses are counted atomically so that concurrent resets with a token
+// can not both succeed, ttl should be at least the lifetime of the tokens.
+func (ar *AuthRepo) ConsumeResetToken(ctx context.Context, token string, ttl time.Duration) (string, error) {
+	key := resetTokenKey(token)
+
+	uses, err := ar.Cache.Incr(ctx, key+":uses", ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error counting reset token use", zap.Error(err))
+		return "", err
+	}
+	if uses > 1 {
+		return "", nil
+	}
+
+	email, err := ar.Cache.Get(ctx, key)
+	if errors.Is(err, cache.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = ar.Cache.Del(ctx, key)
+	if err != nil {
+		logger.FromContext(ctx).Error("error deleting reset token", zap.String("email", email), zap.Error(err))
+		return "", err
+	}
+	return email, nil
+}
+
+// IncrementLoginFailures counts a failed login, the count
+// is reset once window has passed since the first failure.
+func (ar *AuthRepo) IncrementLoginFailures(ctx context.Context, scope, subject string, window time.Duration) (int64, error) {
+	failures, err := ar.Cache.Incr(ctx, loginFailuresKey(scope, subject), window)
+	if err != nil {
+		logger.FromContext(ctx).Error("error counting login failure", zap.String(scope, subject), zap.Error(err))
+		return 0, err
+	}
+	return failures, nil
+}
+
+// ResetLoginFailures clears the failed login count.
+func (ar *AuthRepo) ResetLoginFailures(ctx context.Context, scope, subject string) error {
+	err := ar.Cache.Del(ctx, loginFailuresKey(scope, subject))
+	if err != nil {
+		logger.FromContext(ctx).Error("error resetting login failures", zap.String(scope, subject), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// IncrementLockouts counts the consecutive lockouts, the count
+// is forgotten once ttl has passed since the first lockout.
+func (ar *AuthRepo) IncrementLockouts(ctx context.Context, scope, subject string, ttl time.Duration) (int64, error) {
+	lockouts, err := ar.Cache.Incr(ctx, lockoutsKey(scope, subject), ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error counting lockout", zap.String(scope, subject), zap.Error(err))
+		return 0, err
+	}
+	return lockouts, nil
+}
+
+// Lock blocks logins until the given time.
+func (ar *AuthRepo) Lock(ctx context.Context, scope, subject string, until time.Time) error {
+	_, err := ar.Cache.Set(ctx, lockKey(scope, subject), strconv.FormatInt(until.Unix(), 10), time.Until(until))
+	if err != nil {
+		logger.FromContext(ctx).Error("error saving lock", zap.String(scope, subject), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// LockedUntil returns the time logins are blocked until,
+// a zero time is returned when they are not blocked.
+func (ar *AuthRepo) LockedUntil(ctx context.Context, scope, subject string) time.Time {
+	val, err := ar.Cache.Get(ctx, lockKey(scope, subject))
+	if err != nil || val == "" {
+		return time.Time{}
+	}
+
+	until, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		logger.FromContext(ctx).Error("error parsing lock", zap.String(scope, subject), zap.Error(err))
+		return time.Time{}
+	}
+	return time.Unix(until, 0)
+}
+
+// Unlock lifts a lock and clears the failed login and lockout counts.
+func (ar *AuthRepo) Unlock(ctx context.Context, scope, subject string) error {
+	for _, key := range []string{lockKey(scope, subject), loginFailuresKey(scope, subject), lockoutsKey(scope, subject)} {
+		err := ar.Cache.Del(ctx, key)
+		if err != nil {
+			logger.FromContext(ctx).Error("error unlocking", zap.String(scope, subject), zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveMFAChallenge stores the challenge of a login waiting for a second factor.
+func (ar *AuthRepo) SaveMFAChallenge(ctx context.Context, token, email string, ttl time.Duration) error {
+	_, err := ar.Cache.Set(ctx, mfaChallengeKey(token), email, ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error saving mfa challenge", zap.String("email", email), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// FetchMFAChallenge returns the email an mfa challenge was issued for.
+func (ar *AuthRepo) FetchMFAChallenge(ctx context.Context, token string) (string, error) {
+	return ar.Cache.Get(ctx, mfaChallengeKey(token))
+}
+
+// DeleteMFAChallenge deletes an mfa challenge and its failure count.
+func (ar *AuthRepo) DeleteMFAChallenge(ctx context.Context, token string) error {
+	key := mfaChallengeKey(token)
+	for _, k := range []string{key, key + ":failures"} {
+		err := ar.Cache.Del(ctx, k)
+		if err != nil {
+			logger.FromContext(ctx).Error("error deleting mfa challenge", zap.Error(err))
+			return err
+		}
+	}
+	return nil
+}
+
+// IncrementMFAFailures counts a wrong code for an mfa challenge.
+func (ar *AuthRepo) IncrementMFAFailures(ctx context.Context, token string, ttl time.Duration) (int64, error) {
+	failures, err := ar.Cache.Incr(ctx, mfaChallengeKey(token)+":failures", ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error counting mfa failure", zap.Error(err))
+		return 0, err
+	}
+	return failures, nil
+}
+
+// UseTOTPCounter marks the time step of a TOTP code as used,
+// it reports false if the step was used before so that a code can not be replayed.
+// ttl should cover the steps in which the code is accepted.
+func (ar *AuthRepo) UseTOTPCounter(ctx context.Context, email string, counter uint64, ttl time.Duration) bool {
+	uses, err := ar.Cache.Incr(ctx, fmt.Sprintf("totp:%s:%d", email, counter), ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error marking totp code as used", zap.String("email", email), zap.Error(err))
+		return false
+	}
+	return uses == 1
+}
+
+// SaveSession stores a session of a user until it expires
+// and adds it to the sessions of the user.
+func (ar *AuthRepo) SaveSession(ctx context.Context, email string, session *models.Session) error {
+	err := ar.TouchSession(ctx, email, session)
+	if err != nil {
+		return err
+	}
+
+	// the ids are kept in a set, concurrent logins are all added
+	// and the ids of expired sessions are dropped.
+	err = ar.Cache.AddMember(ctx, sessionsKey(email), session.ID, session.ExpiresAt)
+	if err != nil {
+		logger.FromContext(ctx).Error("error saving sessions", zap.String("email", email), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// TouchSession updates a stored session without changing the sessions of the user.
+func (ar *AuthRepo) TouchSession(ctx context.Context, email string, session *models.Session) error {
+	bytes, err := json.Marshal(session)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while marshalling session", zap.String("email", email), zap.Error(err))
+		return err
+	}
+
+	_, err = ar.Cache.Set(ctx, sessionKey(email, session.ID), string(bytes), time.Until(session.ExpiresAt))
+	if err != nil {
+		logger.FromContext(ctx).Error("error saving session", zap.String("email", email), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// FetchSession returns a session of a user, or nil if it does not exist or is expired.
+func (ar *AuthRepo) FetchSession(ctx context.Context, email, id string) (*models.Session, error) {
+	val, err := ar.Cache.Get(ctx, sessionKey(email, id))
+	if errors.Is(err, cache.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("error fetching session", zap.String("email", email), zap.Error(err))
+		return nil, err
+	}
+
+	session := new(models.Session)
+	err = json.Unmarshal([]byte(val), session)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while unmarshalling session", zap.String("email", email), zap.Error(err))
+		return nil, err
+	}
+	return session, nil
+}
+
+// ListSessions returns the sessions of a user that have not expired,
+// the ids of the sessions that no longer exist are dropped.
+func (ar *AuthRepo) ListSessions(ctx context.Context, email string) ([]*models.Session, error) {
+	ids, err := ar.sessionIDs(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*models.Session, 0, len(ids))
+	var missing []string
+	for _, id := range ids {
+		session, err := ar.FetchSession(ctx, email, id)
+		if err != nil {
+			return nil, err
+		}
+		if session == nil {
+			missing = append(missing, id)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	err = ar.removeSessionIDs(ctx, email, missing...)
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// DeleteSession deletes a session of a user.
+func (ar *AuthRepo) DeleteSession(ctx context.Context, email, id string) error {
+	err := ar.Cache.Del(ctx, sessionKey(email, id))
+	if err != nil {
+		logger.FromContext(ctx).Error("error deleting session", zap.String("email", email), zap.Error(err))
+		return err
+	}
+
+	return ar.removeSessionIDs(ctx, email, id)
+}
+
+// DeleteSessions deletes all the sessions of a user, only the ids read are
+// removed so that a session saved concurrently is not lost.
+func (ar *AuthRepo) DeleteSessions(ctx context.Context, email string) error {
+	ids, err := ar.sessionIDs(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = ar.Cache.Del(ctx, sessionKey(email, id))
+		if err != nil {
+			logger.FromContext(ctx).Error("error deleting session", zap.String("email", email), zap.Error(err))
+			return err
+		}
+	}
+	return ar.removeSessionIDs(ctx, email, ids...)
+}
+
+// sessionIDs returns the ids of the unexpired sessions of a user.
+func (ar *AuthRepo) sessionIDs(ctx context.Context, email string) ([]string, error) {
+	ids, err := ar.Cache.Members(ctx, sessionsKey(email))
+	if err != nil {
+		logger.FromContext(ctx).Error("error fetching sessions", zap.String("email", email), zap.Error(err))
+		return nil, err
+	}
+	return ids, nil
+}
+
+// removeSessionIDs removes ids from the sessions of a user.
+func (ar *AuthRepo) removeSessionIDs(ctx context.Context, email string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	err := ar.Cache.RemoveMembers(ctx, sessionsKey(email), ids...)
+	if err != nil {
+		logger.FromContext(ctx).Error("error deleting sessions", zap.String("email", email), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// SaveOIDCState stores the state of an authorization request until its callback.
+func (ar *AuthRepo) SaveOIDCState(ctx context.Context, state string, oidcState *models.OIDCState, ttl time.Duration) error {
+	bytes, err := json.Marshal(oidcState)
 	if err != nil {
-		logger.Log().Error("error revoking token", zap.String("key", key), zap.String("email", email), zap.Error(err))
+		return err
+	}
+
+	_, err = ar.Cache.Set(ctx, oidcStateKey(state), string(bytes), ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error saving oidc state", zap.String("provider", oidcState.Provider), zap.Error(err))
+		return err
 	}
 	return nil
 }
 
-// IsTokenRevoked checks if a token is revoked or not.
-func (ar *AuthRepo) IsTokenRevoked(ctx context.Context, token string) bool {
-	key := fmt.Sprintf("token:%s", token)
-	val, err := ar.Cache.Get(key)
-	if err == nil && val != "" {
-		return true
+// ConsumeOIDCState returns the state of an authorization request and deletes it,
+// nil if it does not exist, is expired or was consumed already. Uses are counted
+// atomically so that concurrent callbacks with a state can not both succeed,
+// ttl should be at least the lifetime of the states.
+func (ar *AuthRepo) ConsumeOIDCState(ctx context.Context, state string, ttl time.Duration) (*models.OIDCState, error) {
+	key := oidcStateKey(state)
+
+	uses, err := ar.Cache.Incr(ctx, key+":uses", ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error counting oidc state use", zap.Error(err))
+		return nil, err
 	}
-	return false
+	if uses > 1 {
+		return nil, nil
+	}
+
+	val, err := ar.Cache.Get(ctx, key)
+	if errors.Is(err, cache.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("error fetching oidc state", zap.Error(err))
+		return nil, err
+	}
+
+	err = ar.Cache.Del(ctx, key)
+	if err != nil {
+		logger.FromContext(ctx).Error("error deleting oidc state", zap.Error(err))
+		return nil, err
+	}
+
+	oidcState := new(models.OIDCState)
+	err = json.Unmarshal([]byte(val), oidcState)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while unmarshalling oidc state", zap.Error(err))
+		return nil, err
+	}
+	return oidcState, nil
+}
+
+// SaveAuthorizationCode stores an authorization code until it is exchanged or expires.
+func (ar *AuthRepo) SaveAuthorizationCode(ctx context.Context, code string, authCode *models.AuthorizationCode, ttl time.Duration) error {
+	bytes, err := json.Marshal(authCode)
+	if err != nil {
+		return err
+	}
+
+	_, err = ar.Cache.Set(ctx, authorizationCodeKey(code), string(bytes), ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error saving authorization code", zap.String("client_id", authCode.ClientID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ConsumeAuthorizationCode returns an authorization code and deletes it, nil if
+// it does not exist, is expired or was consumed already. Uses are counted
+// atomically so that concurrent exchanges of a code can not both succeed,
+// ttl should be at least the lifetime of the codes.
+func (ar *AuthRepo) ConsumeAuthorizationCode(ctx context.Context, code string, ttl time.Duration) (*models.AuthorizationCode, error) {
+	key := authorizationCodeKey(code)
+
+	uses, err := ar.Cache.Incr(ctx, key+":uses", ttl)
+	if err != nil {
+		logger.FromContext(ctx).Error("error counting authorization code use", zap.Error(err))
+		return nil, err
+	}
+	if uses > 1 {
+		return nil, nil
+	}
+
+	val, err := ar.Cache.Get(ctx, key)
+	if errors.Is(err, cache.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("error fetching authorization code", zap.Error(err))
+		return nil, err
+	}
+
+	err = ar.Cache.Del(ctx, key)
+	if err != nil {
+		logger.FromContext(ctx).Error("error deleting authorization code", zap.Error(err))
+		return nil, err
+	}
+
+	authCode := new(models.AuthorizationCode)
+	err = json.Unmarshal([]byte(val), authCode)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while unmarshalling authorization code", zap.Error(err))
+		return nil, err
+	}
+	return authCode, nil
+}
+
+// refreshTokenKey returns the cache key for a refresh token.
+func refreshTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("refresh:%s", hex.EncodeToString(sum[:]))
+}
+
+// resetTokenKey returns the cache key for a password reset token.
+func resetTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("reset:%s", hex.EncodeToString(sum[:]))
+}
+
+// mfaChallengeKey returns the cache key for an mfa challenge token.
+func mfaChallengeKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("mfa:%s", hex.EncodeToString(sum[:]))
+}
+
+// oidcStateKey returns the cache key for the state of an authorization request.
+func oidcStateKey(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return fmt.Sprintf("oidc:%s", hex.EncodeToString(sum[:]))
+}
+
+// authorizationCodeKey returns the cache key for an OAuth authorization code.
+func authorizationCodeKey(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return fmt.Sprintf("code:%s", hex.EncodeToString(sum[:]))
+}
+
+// sessionKey returns the cache key for a session of a user.
+func sessionKey(email, id string) string {
+	return fmt.Sprintf("session:%s:%s", email, id)
+}
+
+// sessionsKey returns the cache key for the list of session ids of a user.
+func sessionsKey(email string) string {
+	return fmt.Sprintf("sessions:%s", email)
+}
+
+// loginFailuresKey returns the cache key for the failed login count of an account or ip.
+func loginFailuresKey(scope, subject string) string {
+	return fmt.Sprintf("failures:%s:%s", scope, subject)
+}
+
+// lockoutsKey returns the cache key for the consecutive lockouts of an account or ip.
+func lockoutsKey(scope, subject string) string {
+	return fmt.Sprintf("lockouts:%s:%s", scope, subject)
+}
+
+// lockKey returns the cache key for the lock of an account or ip.
+func lockKey(scope, subject string) string {
+	return fmt.Sprintf("lock:%s:%s", scope, subject)
 }
@@ -0,0 +1,262 @@
+// +build !integration
+
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gicicm/adapters/cache"
+	cacheMock "gicicm/adapters/cache/mocks"
+	"gicicm/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuthStore_ConsumeResetToken(t *testing.T) {
+	mockCache := new(cacheMock.Cache)
+	key := resetTokenKey("token")
+
+	mockCache.On("Incr", mock.Anything, key+":uses", time.Hour).Return(int64(1), nil)
+	mockCache.On("Get", mock.Anything, key).Return("test@test.com", nil)
+	mockCache.On("Del", mock.Anything, key).Return(nil)
+
+	authRepo := NewAuthRepository(mockCache)
+	email, err := authRepo.ConsumeResetToken(context.TODO(), "token", time.Hour)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test@test.com", email)
+	mockCache.AssertExpectations(t)
+}
+
+func TestAuthStore_ConsumeResetToken_Unknown(t *testing.T) {
+	mockCache := new(cacheMock.Cache)
+	key := resetTokenKey("token")
+
+	mockCache.On("Incr", mock.Anything, key+":uses", time.Hour).Return(int64(1), nil)
+	mockCache.On("Get", mock.Anything, key).Return("", cache.ErrNotFound)
+
+	authRepo := NewAuthRepository(mockCache)
+	email, err := authRepo.ConsumeResetToken(context.TODO(), "token", time.Hour)
+
+	assert.NoError(t, err)
+	assert.Empty(t, email)
+	mockCache.AssertNotCalled(t, "Del", mock.Anything)
+}
+
+func TestAuthStore_ConsumeResetToken_Concurrent(t *testing.T) {
+	authRepo := NewAuthRepository(cache.NewMemoryCache(0, 0))
+	ctx := context.TODO()
+	require.NoError(t, authRepo.SaveResetToken(ctx, "token", "test@test.com", time.Hour))
+
+	// of concurrent resets with the same token only one gets the email.
+	const resets = 16
+	var wg sync.WaitGroup
+	emails := make(chan string, resets)
+	for i := 0; i < resets; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			email, err := authRepo.ConsumeResetToken(ctx, "token", time.Hour)
+			assert.NoError(t, err)
+			emails <- email
+		}()
+	}
+	wg.Wait()
+	close(emails)
+
+	var consumed []string
+	for email := range emails {
+		if email != "" {
+			consumed = append(consumed, email)
+		}
+	}
+	assert.Equal(t, []string{"test@test.com"}, consumed)
+}
+
+func TestAuthStore_LockedUntil(t *testing.T) {
+	mockCache := new(cacheMock.Cache)
+	until := time.Now().Add(time.Minute).Truncate(time.Second)
+
+	mockCache.On("Get", mock.Anything, lockKey(LockoutScopeAccount, "test@test.com")).
+		Return(strconv.FormatInt(until.Unix(), 10), nil)
+	mockCache.On("Get", mock.Anything, lockKey(LockoutScopeIP, "192.0.2.1")).Return("", errors.New("redis: nil"))
+
+	authRepo := NewAuthRepository(mockCache)
+
+	assert.True(t, until.Equal(authRepo.LockedUntil(context.TODO(), LockoutScopeAccount, "test@test.com")))
+	assert.True(t, authRepo.LockedUntil(context.TODO(), LockoutScopeIP, "192.0.2.1").IsZero())
+}
+
+func TestAuthStore_Unlock(t *testing.T) {
+	mockCache := new(cacheMock.Cache)
+	mockCache.On("Del", mock.Anything, "lock:account:test@test.com").Return(nil)
+	mockCache.On("Del", mock.Anything, "failures:account:test@test.com").Return(nil)
+	mockCache.On("Del", mock.Anything, "lockouts:account:test@test.com").Return(nil)
+
+	authRepo := NewAuthRepository(mockCache)
+	err := authRepo.Unlock(context.TODO(), LockoutScopeAccount, "test@test.com")
+
+	assert.NoError(t, err)
+	mockCache.AssertExpectations(t)
+}
+
+func TestAuthStore_RevokeToken(t *testing.T) {
+	mockCache := new(cacheMock.Cache)
+
+	mockCache.On("Set", mock.Anything, "jti:id", "revoked", time.Minute).Return("OK", nil)
+	mockCache.On("Get", mock.Anything, "jti:id").Return("revoked", nil)
+	mockCache.On("Get", mock.Anything, "jti:other").Return("", cache.ErrNotFound)
+	mockCache.On("Get", mock.Anything, "jti:unavailable").Return("", errors.New("dial tcp: connection refused"))
+
+	authRepo := NewAuthRepository(mockCache)
+
+	assert.NoError(t, authRepo.RevokeToken(context.TODO(), "id", time.Minute))
+	revoked, err := authRepo.IsTokenRevoked(context.TODO(), "id")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = authRepo.IsTokenRevoked(context.TODO(), "other")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	// the token is not accepted when the revocation can not be checked.
+	_, err = authRepo.IsTokenRevoked(context.TODO(), "unavailable")
+	assert.Error(t, err)
+	mockCache.AssertExpectations(t)
+}
+
+func TestAuthStore_RevokeTokenFamily(t *testing.T) {
+	mockCache := new(cacheMock.Cache)
+
+	mockCache.On("Set", mock.Anything, "family:id", "revoked", time.Minute).Return("OK", nil)
+	mockCache.On("Get", mock.Anything, "family:id").Return("revoked", nil)
+	mockCache.On("Get", mock.Anything, "family:other").Return("", cache.ErrNotFound)
+	mockCache.On("Get", mock.Anything, "family:unavailable").Return("", errors.New("dial tcp: connection refused"))
+
+	authRepo := NewAuthRepository(mockCache)
+
+	assert.NoError(t, authRepo.RevokeTokenFamily(context.TODO(), "id", time.Minute))
+	revoked, err := authRepo.IsTokenFamilyRevoked(context.TODO(), "id")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+	revoked, err = authRepo.IsTokenFamilyRevoked(context.TODO(), "other")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	// the family is not accepted when the revocation can not be checked.
+	_, err = authRepo.IsTokenFamilyRevoked(context.TODO(), "unavailable")
+	assert.Error(t, err)
+	mockCache.AssertExpectations(t)
+}
+
+func TestAuthStore_Sessions(t *testing.T) {
+	authRepo := NewAuthRepository(cache.NewMemoryCache(0, 0))
+	ctx := context.TODO()
+	now := time.Now().Truncate(time.Second)
+
+	first := &models.Session{ID: "first", CreatedAt: now, LastSeen: now, ExpiresAt: now.Add(time.Hour), IP: "192.0.2.1"}
+	second := &models.Session{ID: "second", CreatedAt: now, LastSeen: now, ExpiresAt: now.Add(time.Hour)}
+	require.NoError(t, authRepo.SaveSession(ctx, "a@test.com", first))
+	require.NoError(t, authRepo.SaveSession(ctx, "a@test.com", second))
+	require.NoError(t, authRepo.SaveSession(ctx, "a@test.com", second))
+	require.NoError(t, authRepo.SaveSession(ctx, "b@test.com", &models.Session{ID: "other", ExpiresAt: now.Add(time.Hour)}))
+
+	sessions, err := authRepo.ListSessions(ctx, "a@test.com")
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "first", sessions[0].ID)
+	assert.Equal(t, "192.0.2.1", sessions[0].IP)
+	assert.True(t, now.Equal(sessions[0].CreatedAt))
+
+	// touching a session updates it in place.
+	first.LastSeen = now.Add(time.Minute)
+	require.NoError(t, authRepo.TouchSession(ctx, "a@test.com", first))
+	session, err := authRepo.FetchSession(ctx, "a@test.com", "first")
+	require.NoError(t, err)
+	assert.True(t, first.LastSeen.Equal(session.LastSeen))
+
+	// sessions are only found for their user.
+	session, err = authRepo.FetchSession(ctx, "b@test.com", "first")
+	require.NoError(t, err)
+	assert.Nil(t, session)
+
+	require.NoError(t, authRepo.DeleteSession(ctx, "a@test.com", "first"))
+	sessions, err = authRepo.ListSessions(ctx, "a@test.com")
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "second", sessions[0].ID)
+
+	require.NoError(t, authRepo.DeleteSessions(ctx, "a@test.com"))
+	sessions, err = authRepo.ListSessions(ctx, "a@test.com")
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	sessions, err = authRepo.ListSessions(ctx, "b@test.com")
+	require.NoError(t, err)
+	assert.Len(t, sessions, 1)
+}
+
+func TestAuthStore_Sessions_Concurrent(t *testing.T) {
+	authRepo := NewAuthRepository(cache.NewMemoryCache(0, 0))
+	ctx := context.TODO()
+	now := time.Now()
+
+	// concurrent logins are all listed.
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session := &models.Session{ID: fmt.Sprintf("session-%d", i), ExpiresAt: now.Add(time.Hour)}
+			assert.NoError(t, authRepo.SaveSession(ctx, "a@test.com", session))
+		}(i)
+	}
+	wg.Wait()
+
+	sessions, err := authRepo.ListSessions(ctx, "a@test.com")
+	require.NoError(t, err)
+	assert.Len(t, sessions, 16)
+}
+
+func TestAuthStore_OIDCState(t *testing.T) {
+	authRepo := NewAuthRepository(cache.NewMemoryCache(0, 0))
+	ctx := context.TODO()
+
+	state := &models.OIDCState{Provider: "stub", Verifier: "verifier", Nonce: "nonce"}
+	require.NoError(t, authRepo.SaveOIDCState(ctx, "state", state, time.Minute))
+
+	// a state can only be consumed once.
+	consumed, err := authRepo.ConsumeOIDCState(ctx, "state", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, state, consumed)
+
+	consumed, err = authRepo.ConsumeOIDCState(ctx, "state", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, consumed)
+}
+
+func TestAuthStore_AuthorizationCode(t *testing.T) {
+	authRepo := NewAuthRepository(cache.NewMemoryCache(0, 0))
+	ctx := context.TODO()
+
+	code := &models.AuthorizationCode{ClientID: "client", Email: "a@test.com", Scopes: []string{"users:list"}}
+	require.NoError(t, authRepo.SaveAuthorizationCode(ctx, "code", code, time.Minute))
+
+	// a code can only be exchanged once.
+	consumed, err := authRepo.ConsumeAuthorizationCode(ctx, "code", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, code, consumed)
+
+	consumed, err = authRepo.ConsumeAuthorizationCode(ctx, "code", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, consumed)
+
+	consumed, err = authRepo.ConsumeAuthorizationCode(ctx, "unknown", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, consumed)
+}
@@ -0,0 +1,205 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/logger"
+	"gicicm/metrics"
+	"gicicm/models"
+
+	"go.uber.org/zap"
+)
+
+// ClientRepository is a repository layer for the registered OAuth clients
+// and the consents the users gave them.
+type ClientRepository interface {
+	Create(ctx context.Context, client *models.Client) error
+	List(ctx context.Context) ([]*models.Client, error)
+	Fetch(ctx context.Context, clientID string) (*models.Client, error)
+	Delete(ctx context.Context, clientID string) error
+	FetchConsent(ctx context.Context, email, clientID string) (*models.Consent, error)
+	SaveConsent(ctx context.Context, consent *models.Consent) error
+}
+
+// ClientRepo stores the clients in the oauth_clients table
+// and the consents in the oauth_consents table.
+type ClientRepo struct {
+	db *sql.DB
+
+	// rebind rewrites the $n placeholders of a query
+	// to the placeholders of the database driver.
+	rebind func(query string) string
+}
+
+const (
+	createClientQuery = "INSERT INTO oauth_clients(client_id,name,secret_hash,redirect_uris,grant_types,scopes,created_at) " +
+		"VALUES($1,$2,$3,$4,$5,$6,$7)"
+	listClientsQuery = "SELECT client_id,name,secret_hash,redirect_uris,grant_types,scopes,created_at " +
+		"from oauth_clients ORDER BY id"
+	fetchClientQuery = "SELECT client_id,name,secret_hash,redirect_uris,grant_types,scopes,created_at " +
+		"from oauth_clients where client_id=$1"
+	deleteClientQuery = "DELETE FROM oauth_clients WHERE client_id=$1"
+
+	fetchConsentQuery = "SELECT u.email,c.client_id,o.scopes,o.created_at from oauth_consents o " +
+		"JOIN users u ON u.id=o.user_id JOIN oauth_clients c ON c.id=o.client_id where u.email=$1 AND c.client_id=$2"
+	// a new consent replaces the previous one of the user for the client.
+	saveConsentQuery = "INSERT INTO oauth_consents(user_id,client_id,scopes,created_at) " +
+		"SELECT u.id,c.id,$3,$4 from users u, oauth_clients c where u.email=$1 AND c.client_id=$2 " +
+		"ON CONFLICT (user_id, client_id) DO UPDATE SET scopes=excluded.scopes, created_at=excluded.created_at"
+)
+
+// NewClientRepository returns a new instance of the client repository
+// backed by a postgres database.
+func NewClientRepository(db *sql.DB) ClientRepository {
+	return &ClientRepo{
+		db:     db,
+		rebind: func(query string) string { return query },
+	}
+}
+
+// NewSQLiteClientRepository returns a new instance of the client repository
+// backed by a sqlite database.
+func NewSQLiteClientRepository(db *sql.DB) ClientRepository {
+	return &ClientRepo{
+		db:     db,
+		rebind: rebindSQLite,
+	}
+}
+
+// Create registers a new client.
+func (cr *ClientRepo) Create(ctx context.Context, client *models.Client) error {
+	start := time.Now()
+	_, err := cr.db.ExecContext(ctx, cr.rebind(createClientQuery),
+		client.ClientID, client.Name, client.SecretHash, strings.Join(client.RedirectURIs, " "),
+		strings.Join(client.GrantTypes, " "), strings.Join(client.Scopes, " "), client.CreatedAt)
+	metrics.ObserveQuery("create_client", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error creating client", zap.String("client_id", client.ClientID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// List returns all the clients, oldest first.
+func (cr *ClientRepo) List(ctx context.Context) ([]*models.Client, error) {
+	start := time.Now()
+	rows, err := cr.db.QueryContext(ctx, cr.rebind(listClientsQuery))
+	metrics.ObserveQuery("list_clients", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error listing clients", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*models.Client{}
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			logger.FromContext(ctx).Error("error scanning client", zap.Error(err))
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// Fetch returns the client with the id, nil if there is none.
+func (cr *ClientRepo) Fetch(ctx context.Context, clientID string) (*models.Client, error) {
+	start := time.Now()
+	client, err := scanClient(cr.db.QueryRowContext(ctx, cr.rebind(fetchClientQuery), clientID))
+	metrics.ObserveQuery("fetch_client", start)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("error fetching client", zap.String("client_id", clientID), zap.Error(err))
+		return nil, err
+	}
+	return client, nil
+}
+
+// Delete deletes a client along with the consents given to it.
+func (cr *ClientRepo) Delete(ctx context.Context, clientID string) error {
+	start := time.Now()
+	result, err := cr.db.ExecContext(ctx, cr.rebind(deleteClientQuery), clientID)
+	metrics.ObserveQuery("delete_client", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error deleting client", zap.String("client_id", clientID), zap.Error(err))
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return apperrors.ErrClientNotFound
+	}
+	return nil
+}
+
+// FetchConsent returns the consent of a user for a client, nil if the user never consented.
+func (cr *ClientRepo) FetchConsent(ctx context.Context, email, clientID string) (*models.Consent, error) {
+	consent := new(models.Consent)
+	var scopes string
+
+	start := time.Now()
+	err := cr.db.QueryRowContext(ctx, cr.rebind(fetchConsentQuery), email, clientID).
+		Scan(&consent.Email, &consent.ClientID, &scopes, &consent.CreatedAt)
+	metrics.ObserveQuery("fetch_consent", start)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("error fetching consent",
+			zap.String("email", email), zap.String("client_id", clientID), zap.Error(err))
+		return nil, err
+	}
+
+	consent.Scopes = strings.Fields(scopes)
+	return consent, nil
+}
+
+// SaveConsent stores the consent of a user for a client, replacing the previous one.
+func (cr *ClientRepo) SaveConsent(ctx context.Context, consent *models.Consent) error {
+	start := time.Now()
+	result, err := cr.db.ExecContext(ctx, cr.rebind(saveConsentQuery),
+		consent.Email, consent.ClientID, strings.Join(consent.Scopes, " "), consent.CreatedAt)
+	metrics.ObserveQuery("save_consent", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error saving consent",
+			zap.String("email", consent.Email), zap.String("client_id", consent.ClientID), zap.Error(err))
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		// either the user or the client does not exist.
+		return apperrors.ErrClientNotFound
+	}
+	return nil
+}
+
+// scanClient scans a row of the client queries.
+func scanClient(row scanner) (*models.Client, error) {
+	client := new(models.Client)
+	var redirectURIs, grantTypes, scopes string
+
+	err := row.Scan(&client.ClientID, &client.Name, &client.SecretHash,
+		&redirectURIs, &grantTypes, &scopes, &client.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	client.GrantTypes = strings.Fields(grantTypes)
+	client.Scopes = strings.Fields(scopes)
+	return client, nil
+}
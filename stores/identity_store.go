@@ -0,0 +1,104 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/logger"
+	"gicicm/metrics"
+	"gicicm/models"
+
+	"go.uber.org/zap"
+)
+
+// IdentityRepository is a repository layer for the identities
+// of the users at OpenID providers.
+type IdentityRepository interface {
+	Fetch(ctx context.Context, issuer, subject string) (*models.Identity, error)
+	Link(ctx context.Context, identity *models.Identity) error
+}
+
+// IdentityRepo stores the identities in the user_identities table.
+type IdentityRepo struct {
+	db *sql.DB
+
+	// rebind rewrites the $n placeholders of a query
+	// to the placeholders of the database driver.
+	rebind func(query string) string
+}
+
+const (
+	fetchIdentityQuery = "SELECT u.email,i.issuer,i.subject,i.created_at " +
+		"from user_identities i JOIN users u ON u.id=i.user_id where i.issuer=$1 AND i.subject=$2"
+	// an identity is linked to a single user.
+	linkIdentityQuery = "INSERT INTO user_identities(user_id,issuer,subject,created_at) " +
+		"SELECT id,$2,$3,$4 from users where email=$1 ON CONFLICT (issuer, subject) DO NOTHING"
+)
+
+// NewIdentityRepository returns a new instance of the identity repository
+// backed by a postgres database.
+func NewIdentityRepository(db *sql.DB) IdentityRepository {
+	return &IdentityRepo{
+		db:     db,
+		rebind: func(query string) string { return query },
+	}
+}
+
+// NewSQLiteIdentityRepository returns a new instance of the identity repository
+// backed by a sqlite database.
+func NewSQLiteIdentityRepository(db *sql.DB) IdentityRepository {
+	return &IdentityRepo{
+		db:     db,
+		rebind: rebindSQLite,
+	}
+}
+
+// Fetch returns the identity with the email of its user, nil if it is not linked.
+func (ir *IdentityRepo) Fetch(ctx context.Context, issuer, subject string) (*models.Identity, error) {
+	identity := new(models.Identity)
+
+	start := time.Now()
+	err := ir.db.QueryRowContext(ctx, ir.rebind(fetchIdentityQuery), issuer, subject).
+		Scan(&identity.Email, &identity.Issuer, &identity.Subject, &identity.CreatedAt)
+	metrics.ObserveQuery("fetch_identity", start)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("error fetching identity",
+			zap.String("issuer", issuer), zap.String("subject", subject), zap.Error(err))
+		return nil, err
+	}
+	return identity, nil
+}
+
+// Link links an identity to the user with the email of the identity.
+func (ir *IdentityRepo) Link(ctx context.Context, identity *models.Identity) error {
+	start := time.Now()
+	result, err := ir.db.ExecContext(ctx, ir.rebind(linkIdentityQuery),
+		identity.Email, identity.Issuer, identity.Subject, identity.CreatedAt)
+	metrics.ObserveQuery("link_identity", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error linking identity", zap.String("email", identity.Email), zap.Error(err))
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		// either the user does not exist or the identity is linked already.
+		existing, err := ir.Fetch(ctx, identity.Issuer, identity.Subject)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return apperrors.ErrAccountNotFound
+		}
+		return apperrors.ErrIdentityAlreadyLinked
+	}
+	return nil
+}
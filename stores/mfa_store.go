@@ -0,0 +1,162 @@
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gicicm/apperrors"
+	"gicicm/logger"
+	"gicicm/metrics"
+	"gicicm/models"
+
+	"go.uber.org/zap"
+)
+
+// MFARepository is a repository layer for the TOTP enrolments and recovery codes.
+type MFARepository interface {
+	Fetch(ctx context.Context, email string) (*models.MFA, error)
+	SaveSecret(ctx context.Context, email, secret string) error
+	Enable(ctx context.Context, email string, recoveryCodeHashes []string) error
+	ConsumeRecoveryCode(ctx context.Context, email, codeHash string) (bool, error)
+}
+
+// MFARepo stores the enrolments in the user_mfa table and
+// the hashed recovery codes in the mfa_recovery_codes table.
+type MFARepo struct {
+	db *sql.DB
+
+	// rebind rewrites the $n placeholders of a query
+	// to the placeholders of the database driver.
+	rebind func(query string) string
+}
+
+const (
+	fetchMFAQuery = "SELECT m.secret,m.enabled from user_mfa m JOIN users u ON u.id=m.user_id where u.email=$1"
+	// a pending enrolment is replaced, an enabled one is never overwritten.
+	saveMFASecretQuery = "INSERT INTO user_mfa(user_id,secret,enabled) SELECT id,$2,false from users where email=$1 " +
+		"ON CONFLICT (user_id) DO UPDATE SET secret=excluded.secret WHERE NOT user_mfa.enabled"
+	enableMFAQuery = "UPDATE user_mfa SET enabled=true WHERE user_id=(SELECT id from users where email=$1)"
+
+	deleteRecoveryCodesQuery = "DELETE FROM mfa_recovery_codes WHERE user_id=(SELECT id from users where email=$1)"
+	createRecoveryCodeQuery  = "INSERT INTO mfa_recovery_codes(user_id,code_hash) SELECT id,$2 from users where email=$1"
+	consumeRecoveryCodeQuery = "DELETE FROM mfa_recovery_codes WHERE code_hash=$2 AND user_id=(SELECT id from users where email=$1)"
+)
+
+// NewMFARepository returns a new instance of the mfa repository
+// backed by a postgres database.
+func NewMFARepository(db *sql.DB) MFARepository {
+	return &MFARepo{
+		db:     db,
+		rebind: func(query string) string { return query },
+	}
+}
+
+// NewSQLiteMFARepository returns a new instance of the mfa repository
+// backed by a sqlite database.
+func NewSQLiteMFARepository(db *sql.DB) MFARepository {
+	return &MFARepo{
+		db:     db,
+		rebind: rebindSQLite,
+	}
+}
+
+// Fetch returns the enrolment of a user, nil if the user never enrolled.
+func (mr *MFARepo) Fetch(ctx context.Context, email string) (*models.MFA, error) {
+	mfa := new(models.MFA)
+
+	start := time.Now()
+	err := mr.db.QueryRowContext(ctx, mr.rebind(fetchMFAQuery), email).Scan(&mfa.Secret, &mfa.Enabled)
+	metrics.ObserveQuery("fetch_mfa", start)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("error fetching mfa", zap.String("email", email), zap.Error(err))
+		return nil, err
+	}
+	return mfa, nil
+}
+
+// SaveSecret starts or restarts the enrolment of a user.
+func (mr *MFARepo) SaveSecret(ctx context.Context, email, secret string) error {
+	start := time.Now()
+	result, err := mr.db.ExecContext(ctx, mr.rebind(saveMFASecretQuery), email, secret)
+	metrics.ObserveQuery("save_mfa_secret", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error saving mfa secret", zap.String("email", email), zap.Error(err))
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return apperrors.ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+// Enable enables the enrolment of a user and replaces
+// the recovery codes in a single transaction.
+func (mr *MFARepo) Enable(ctx context.Context, email string, recoveryCodeHashes []string) error {
+	start := time.Now()
+	defer metrics.ObserveQuery("enable_mfa", start)
+
+	tx, err := mr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = mr.enable(ctx, tx, email, recoveryCodeHashes)
+	if err != nil {
+		logger.FromContext(ctx).Error("error enabling mfa", zap.String("email", email), zap.Error(err))
+		rollBackErr := tx.Rollback()
+		if rollBackErr != nil {
+			logger.FromContext(ctx).Error("Error while rolling back transaction", zap.Error(rollBackErr))
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// enable runs the statements of Enable in tx.
+func (mr *MFARepo) enable(ctx context.Context, tx *sql.Tx, email string, recoveryCodeHashes []string) error {
+	_, err := tx.ExecContext(ctx, mr.rebind(enableMFAQuery), email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, mr.rebind(deleteRecoveryCodesQuery), email)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, mr.rebind(createRecoveryCodeQuery), email, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConsumeRecoveryCode deletes a recovery code of a user, it
+// reports whether the code existed so that it can only be used once.
+func (mr *MFARepo) ConsumeRecoveryCode(ctx context.Context, email, codeHash string) (bool, error) {
+	start := time.Now()
+	result, err := mr.db.ExecContext(ctx, mr.rebind(consumeRecoveryCodeQuery), email, codeHash)
+	metrics.ObserveQuery("consume_recovery_code", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error consuming recovery code", zap.String("email", email), zap.Error(err))
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
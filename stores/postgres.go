@@ -0,0 +1,15 @@
+package stores
+
+import (
+	"github.com/lib/pq"
+)
+
+// postgres error code for unique_violation.
+const postgresUniqueViolation = "23505"
+
+// isPostgresUniqueViolation checks whether an error
+// is a violation of the unique email constraint.
+func isPostgresUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == postgresUniqueViolation && pqErr.Constraint == "users_email_key"
+}
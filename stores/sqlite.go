@@ -0,0 +1,37 @@
+package stores
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"gicicm/adapters/cache"
+)
+
+// NewSQLiteUserRepository returns a new instance of the user repository
+// backed by a sqlite database.
+func NewSQLiteUserRepository(db *sql.DB, cache cache.Cache) UserRepository {
+	return &UserRepo{
+		cache:             cache,
+		db:                db,
+		isUniqueViolation: isSQLiteUniqueViolation,
+		rebind:            rebindSQLite,
+		stmts:             make(map[string]*sql.Stmt),
+	}
+}
+
+// isSQLiteUniqueViolation checks whether an error
+// is a violation of the unique email constraint.
+// the message is matched so that the stores do not depend on cgo.
+func isSQLiteUniqueViolation(err error) bool {
+	return strings.HasPrefix(err.Error(), "UNIQUE constraint failed: users.email")
+}
+
+// placeholder matches the $n placeholders of a query.
+var placeholder = regexp.MustCompile(`\$(\d+)`)
+
+// rebindSQLite rewrites the $n placeholders to ?n,
+// sqlite binds $n by order of appearance instead of by n.
+func rebindSQLite(query string) string {
+	return placeholder.ReplaceAllString(query, "?$1")
+}
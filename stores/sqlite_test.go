@@ -0,0 +1,397 @@
+// +build !integration
+
+package stores
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gicicm/adapters/cache"
+	"gicicm/apperrors"
+	"gicicm/migrations"
+	"gicicm/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/mattn/go-sqlite3" //dialect to be used
+)
+
+// newSQLiteTestRepository returns a user repository backed
+// by a migrated in memory sqlite database.
+func newSQLiteTestRepository(t *testing.T) (UserRepository, *sql.DB) {
+	db, err := sql.Open("sqlite3", "file::memory:?_foreign_keys=on")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+
+	migrator, err := migrations.NewMigrator(db, "sqlite3")
+	require.NoError(t, err)
+	_, err = migrator.Up(context.TODO())
+	require.NoError(t, err)
+
+	funcGenerate = func(pass string) ([]byte, error) {
+		return []byte("hashed:" + pass), nil
+	}
+
+	return NewSQLiteUserRepository(db, cache.NewMemoryCache(0, 0)), db
+}
+
+func TestSQLiteUserStore(t *testing.T) {
+	userRepo, db := newSQLiteTestRepository(t)
+	defer db.Close()
+	defer func() {
+		funcGenerate = generateHash
+	}()
+
+	ctx := context.TODO()
+
+	for _, email := range []string{"b@test.com", "a@test.com", "o'brien@test.com"} {
+		err := userRepo.Create(ctx, &models.User{Name: email, Email: email, Password: "pass"})
+		require.NoError(t, err)
+	}
+
+	// duplicates are detected.
+	err := userRepo.Create(ctx, &models.User{Name: "dup", Email: "a@test.com", Password: "pass"})
+	assert.True(t, errors.Is(err, apperrors.ErrAccountAlreadyExists))
+
+	user, err := userRepo.Fetch(ctx, "o'brien@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, "hashed:pass", user.Password)
+
+	_, err = userRepo.Fetch(ctx, "missing@test.com")
+	assert.True(t, errors.Is(err, apperrors.ErrAccountNotFound))
+
+	// roles
+	require.NoError(t, userRepo.GrantRole(ctx, "a@test.com", models.RoleAdmin))
+	require.NoError(t, userRepo.GrantRole(ctx, "a@test.com", models.RoleAdmin))
+	user, err = userRepo.Fetch(ctx, "a@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{models.RoleAdmin}, user.Roles)
+
+	require.NoError(t, userRepo.RevokeRole(ctx, "a@test.com", models.RoleAdmin))
+	user, err = userRepo.Fetch(ctx, "a@test.com")
+	require.NoError(t, err)
+	assert.Empty(t, user.Roles)
+
+	// update
+	name := "new name"
+	require.NoError(t, userRepo.Update(ctx, "a@test.com", &models.UserUpdate{Name: &name}))
+	user, err = userRepo.Fetch(ctx, "a@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, name, user.Name)
+	assert.Equal(t, "hashed:pass", user.Password)
+
+	// list sorted by email over two pages.
+	page, err := userRepo.List(ctx, &models.ListUsersRequest{Limit: 2, Sort: "email"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), page.TotalEstimate)
+	assert.Len(t, page.Users, 2)
+	assert.Equal(t, "a@test.com", page.Users[0].Email)
+	assert.NotEmpty(t, page.NextCursor)
+
+	page, err = userRepo.List(ctx, &models.ListUsersRequest{Limit: 2, Sort: "email", Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Len(t, page.Users, 1)
+	assert.Equal(t, "o'brien@test.com", page.Users[0].Email)
+	assert.Empty(t, page.NextCursor)
+
+	page, err = userRepo.List(ctx, &models.ListUsersRequest{Limit: 10, Sort: "id", NameContains: "NEW"})
+	require.NoError(t, err)
+	assert.Len(t, page.Users, 1)
+
+	// delete cascades to the roles.
+	require.NoError(t, userRepo.GrantRole(ctx, "b@test.com", models.RoleAdmin))
+	require.NoError(t, userRepo.Delete(ctx, "b@test.com"))
+	assert.True(t, errors.Is(userRepo.Delete(ctx, "b@test.com"), apperrors.ErrAccountNotFound))
+
+	var roles int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) from user_roles").Scan(&roles))
+	assert.Equal(t, 0, roles)
+}
+
+func TestSQLiteSessionGenerations(t *testing.T) {
+	userRepo, db := newSQLiteTestRepository(t)
+	defer db.Close()
+
+	ctx := context.TODO()
+	require.NoError(t, userRepo.Create(ctx, &models.User{Name: "a", Email: "a@test.com", Password: "pass"}))
+
+	generation, err := userRepo.SessionGeneration(ctx, "a@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), generation)
+
+	// concurrent revocations are all counted.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, userRepo.IncrementSessionGeneration(ctx, "a@test.com"))
+		}()
+	}
+	wg.Wait()
+
+	generation, err = userRepo.SessionGeneration(ctx, "a@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), generation)
+
+	generation, err = userRepo.SessionGeneration(ctx, "b@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), generation)
+
+	// the generation is deleted with the user.
+	require.NoError(t, userRepo.Delete(ctx, "a@test.com"))
+	var rows int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM session_generations").Scan(&rows))
+	assert.Equal(t, 0, rows)
+
+	require.NoError(t, userRepo.Create(ctx, &models.User{Name: "a", Email: "a@test.com", Password: "pass"}))
+	generation, err = userRepo.SessionGeneration(ctx, "a@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), generation)
+}
+
+func TestSQLiteAuditStore(t *testing.T) {
+	_, db := newSQLiteTestRepository(t)
+	defer db.Close()
+
+	auditRepo := NewSQLiteAuditRepository(db)
+	err := auditRepo.Record(context.TODO(), &models.AuditEntry{
+		Action:  models.AuditAccountLocked,
+		Subject: "a@test.com",
+		IP:      "192.0.2.1",
+		Details: "failures=5",
+	})
+	require.NoError(t, err)
+
+	var entry models.AuditEntry
+	err = db.QueryRow("SELECT action,actor,subject,ip,details,created_at from audit_log").
+		Scan(&entry.Action, &entry.Actor, &entry.Subject, &entry.IP, &entry.Details, &entry.CreatedAt)
+	require.NoError(t, err)
+	assert.Equal(t, models.AuditAccountLocked, entry.Action)
+	assert.Empty(t, entry.Actor)
+	assert.Equal(t, "a@test.com", entry.Subject)
+	assert.Equal(t, "192.0.2.1", entry.IP)
+	assert.False(t, entry.CreatedAt.IsZero())
+}
+
+func TestSQLiteMFAStore(t *testing.T) {
+	userRepo, db := newSQLiteTestRepository(t)
+	defer db.Close()
+	defer func() {
+		funcGenerate = generateHash
+	}()
+
+	ctx := context.TODO()
+	require.NoError(t, userRepo.Create(ctx, &models.User{Name: "mfa", Email: "a@test.com", Password: "pass"}))
+
+	mfaRepo := NewSQLiteMFARepository(db)
+
+	mfa, err := mfaRepo.Fetch(ctx, "a@test.com")
+	require.NoError(t, err)
+	assert.Nil(t, mfa)
+
+	// a pending enrolment can be restarted.
+	require.NoError(t, mfaRepo.SaveSecret(ctx, "a@test.com", "first"))
+	require.NoError(t, mfaRepo.SaveSecret(ctx, "a@test.com", "second"))
+	mfa, err = mfaRepo.Fetch(ctx, "a@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, &models.MFA{Secret: "second"}, mfa)
+
+	require.NoError(t, mfaRepo.Enable(ctx, "a@test.com", []string{"hash1", "hash2"}))
+	mfa, err = mfaRepo.Fetch(ctx, "a@test.com")
+	require.NoError(t, err)
+	assert.True(t, mfa.Enabled)
+
+	// an enabled enrolment is never overwritten.
+	err = mfaRepo.SaveSecret(ctx, "a@test.com", "third")
+	assert.True(t, errors.Is(err, apperrors.ErrMFAAlreadyEnabled))
+
+	ok, err := mfaRepo.ConsumeRecoveryCode(ctx, "a@test.com", "hash1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = mfaRepo.ConsumeRecoveryCode(ctx, "a@test.com", "hash1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// deleting the user removes the enrolment and the codes.
+	require.NoError(t, userRepo.Delete(ctx, "a@test.com"))
+	var rows int
+	require.NoError(t, db.QueryRow("SELECT (SELECT COUNT(*) from user_mfa) + (SELECT COUNT(*) from mfa_recovery_codes)").Scan(&rows))
+	assert.Equal(t, 0, rows)
+}
+
+func TestSQLiteAPIKeyStore(t *testing.T) {
+	userRepo, db := newSQLiteTestRepository(t)
+	defer db.Close()
+	defer func() {
+		funcGenerate = generateHash
+	}()
+
+	ctx := context.TODO()
+	require.NoError(t, userRepo.Create(ctx, &models.User{Name: "keys", Email: "a@test.com", Password: "pass"}))
+
+	keyRepo := NewSQLiteAPIKeyRepository(db)
+	now := time.Now().UTC().Truncate(time.Second)
+	expiresAt := now.Add(time.Hour)
+
+	require.NoError(t, keyRepo.Create(ctx, &models.APIKey{
+		Email: "a@test.com", Name: "ci", Prefix: "first", Hash: "hash1",
+		Scopes: []string{"users:list", "users:delete"}, CreatedAt: now, ExpiresAt: &expiresAt,
+	}))
+	require.NoError(t, keyRepo.Create(ctx, &models.APIKey{
+		Email: "a@test.com", Name: "cron", Prefix: "second", Hash: "hash2",
+		Scopes: []string{"users:list"}, CreatedAt: now,
+	}))
+
+	// keys are only created for existing users.
+	err := keyRepo.Create(ctx, &models.APIKey{Email: "missing@test.com", Prefix: "third", CreatedAt: now})
+	assert.True(t, errors.Is(err, apperrors.ErrAccountNotFound))
+
+	keys, err := keyRepo.List(ctx, "a@test.com")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "ci", keys[0].Name)
+	assert.Equal(t, []string{"users:list", "users:delete"}, keys[0].Scopes)
+	assert.True(t, expiresAt.Equal(*keys[0].ExpiresAt))
+	assert.Nil(t, keys[1].ExpiresAt)
+	assert.Nil(t, keys[1].LastUsedAt)
+
+	require.NoError(t, keyRepo.Touch(ctx, "second", now))
+	key, err := keyRepo.FetchByPrefix(ctx, "second")
+	require.NoError(t, err)
+	assert.Equal(t, "a@test.com", key.Email)
+	assert.Equal(t, "hash2", key.Hash)
+	assert.True(t, now.Equal(*key.LastUsedAt))
+
+	key, err = keyRepo.FetchByPrefix(ctx, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, key)
+
+	// keys are only deleted by their owner.
+	assert.True(t, errors.Is(keyRepo.Delete(ctx, "b@test.com", "first"), apperrors.ErrAPIKeyNotFound))
+	require.NoError(t, keyRepo.Delete(ctx, "a@test.com", "first"))
+	assert.True(t, errors.Is(keyRepo.Delete(ctx, "a@test.com", "first"), apperrors.ErrAPIKeyNotFound))
+
+	// deleting the user removes the keys.
+	require.NoError(t, userRepo.Delete(ctx, "a@test.com"))
+	var rows int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) from api_keys").Scan(&rows))
+	assert.Equal(t, 0, rows)
+}
+
+func TestSQLiteIdentityStore(t *testing.T) {
+	userRepo, db := newSQLiteTestRepository(t)
+	defer db.Close()
+	defer func() {
+		funcGenerate = generateHash
+	}()
+
+	ctx := context.TODO()
+	require.NoError(t, userRepo.Create(ctx, &models.User{Name: "a", Email: "a@test.com", Password: "pass"}))
+	require.NoError(t, userRepo.Create(ctx, &models.User{Name: "b", Email: "b@test.com", Password: "pass"}))
+
+	identityRepo := NewSQLiteIdentityRepository(db)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	identity, err := identityRepo.Fetch(ctx, "https://idp.test", "42")
+	require.NoError(t, err)
+	assert.Nil(t, identity)
+
+	require.NoError(t, identityRepo.Link(ctx, &models.Identity{
+		Email: "a@test.com", Issuer: "https://idp.test", Subject: "42", CreatedAt: now,
+	}))
+	identity, err = identityRepo.Fetch(ctx, "https://idp.test", "42")
+	require.NoError(t, err)
+	assert.Equal(t, "a@test.com", identity.Email)
+	assert.True(t, now.Equal(identity.CreatedAt))
+
+	// an identity is linked to a single user.
+	err = identityRepo.Link(ctx, &models.Identity{Email: "b@test.com", Issuer: "https://idp.test", Subject: "42", CreatedAt: now})
+	assert.True(t, errors.Is(err, apperrors.ErrIdentityAlreadyLinked))
+	err = identityRepo.Link(ctx, &models.Identity{Email: "missing@test.com", Issuer: "https://idp.test", Subject: "43", CreatedAt: now})
+	assert.True(t, errors.Is(err, apperrors.ErrAccountNotFound))
+
+	// the same subject of another issuer is another identity.
+	require.NoError(t, identityRepo.Link(ctx, &models.Identity{
+		Email: "b@test.com", Issuer: "https://other.test", Subject: "42", CreatedAt: now,
+	}))
+
+	// deleting the user removes the identities.
+	require.NoError(t, userRepo.Delete(ctx, "a@test.com"))
+	identity, err = identityRepo.Fetch(ctx, "https://idp.test", "42")
+	require.NoError(t, err)
+	assert.Nil(t, identity)
+}
+
+func TestSQLiteClientStore(t *testing.T) {
+	userRepo, db := newSQLiteTestRepository(t)
+	defer db.Close()
+	defer func() {
+		funcGenerate = generateHash
+	}()
+
+	ctx := context.TODO()
+	require.NoError(t, userRepo.Create(ctx, &models.User{Name: "a", Email: "a@test.com", Password: "pass"}))
+
+	clientRepo := NewSQLiteClientRepository(db)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	require.NoError(t, clientRepo.Create(ctx, &models.Client{
+		ClientID: "web", Name: "web app", SecretHash: "hash",
+		RedirectURIs: []string{"https://web.test/callback", "https://web.test/other"},
+		GrantTypes:   []string{models.GrantAuthorizationCode, models.GrantRefreshToken},
+		Scopes:       []string{"users:list"}, CreatedAt: now,
+	}))
+	require.NoError(t, clientRepo.Create(ctx, &models.Client{
+		ClientID: "spa", Name: "single page app", RedirectURIs: []string{"https://spa.test/"},
+		GrantTypes: []string{models.GrantAuthorizationCode}, CreatedAt: now,
+	}))
+
+	clients, err := clientRepo.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, clients, 2)
+	assert.Equal(t, "web", clients[0].ClientID)
+	assert.Equal(t, []string{"https://web.test/callback", "https://web.test/other"}, clients[0].RedirectURIs)
+	assert.True(t, clients[0].Confidential())
+	assert.False(t, clients[1].Confidential())
+	assert.Empty(t, clients[1].Scopes)
+
+	client, err := clientRepo.Fetch(ctx, "web")
+	require.NoError(t, err)
+	assert.Equal(t, "hash", client.SecretHash)
+	assert.True(t, now.Equal(client.CreatedAt))
+
+	client, err = clientRepo.Fetch(ctx, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, client)
+
+	// consents are replaced and only saved for existing users and clients.
+	consent, err := clientRepo.FetchConsent(ctx, "a@test.com", "web")
+	require.NoError(t, err)
+	assert.Nil(t, consent)
+
+	require.NoError(t, clientRepo.SaveConsent(ctx, &models.Consent{
+		Email: "a@test.com", ClientID: "web", Scopes: []string{"users:list"}, CreatedAt: now,
+	}))
+	require.NoError(t, clientRepo.SaveConsent(ctx, &models.Consent{
+		Email: "a@test.com", ClientID: "web", Scopes: []string{"users:list", "users:delete"}, CreatedAt: now,
+	}))
+	consent, err = clientRepo.FetchConsent(ctx, "a@test.com", "web")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"users:list", "users:delete"}, consent.Scopes)
+
+	err = clientRepo.SaveConsent(ctx, &models.Consent{Email: "a@test.com", ClientID: "missing", CreatedAt: now})
+	assert.True(t, errors.Is(err, apperrors.ErrClientNotFound))
+
+	// deleting the client removes the consents.
+	require.NoError(t, clientRepo.Delete(ctx, "web"))
+	assert.True(t, errors.Is(clientRepo.Delete(ctx, "web"), apperrors.ErrClientNotFound))
+	var rows int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) from oauth_consents").Scan(&rows))
+	assert.Equal(t, 0, rows)
+}
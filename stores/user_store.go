@@ -3,14 +3,20 @@ package stores
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"gicicm/common"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"gicicm/adapters/cache"
+	"gicicm/apperrors"
 	"gicicm/logger"
+	"gicicm/metrics"
 	"gicicm/models"
 
 	"go.uber.org/zap"
@@ -20,81 +26,118 @@ import (
 // UserRepository is a repository layer for all user related operations.
 type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
-	List(ctx context.Context) ([]models.User, error)
+	List(ctx context.Context, request *models.ListUsersRequest) (*models.UserPage, error)
 	Fetch(ctx context.Context, emailID string) (*models.User, error)
 	Delete(ctx context.Context, email string) error
+	Update(ctx context.Context, email string, update *models.UserUpdate) error
+	GrantRole(ctx context.Context, email, role string) error
+	RevokeRole(ctx context.Context, email, role string) error
+	SessionGeneration(ctx context.Context, email string) (int64, error)
+	IncrementSessionGeneration(ctx context.Context, email string) error
 }
 
 // userRepo is responsible for communicating with the data stores via the adapter.
 type UserRepo struct {
 	cache cache.Cache
 	db    *sql.DB
+
+	// isUniqueViolation checks whether an error returned by the
+	// database driver is a violation of the unique email constraint.
+	isUniqueViolation func(err error) bool
+
+	// rebind rewrites the $n placeholders of a query
+	// to the placeholders of the database driver.
+	rebind func(query string) string
+
+	// stmts holds the prepared statements keyed by query,
+	// they are prepared on first use and reused across calls.
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
 }
 
 const (
 	listUsersQuery  = "SELECT id,name,email from users"
-	fetchUserQuery  = "SELECT id,name,email,password from users where email='%s'"
-	createUserQuery = "INSERT INTO users(name,email,password) VALUES('%s','%s','%s')"
-	deleteUserQuery = "DELETE FROM users WHERE email='%s'"
+	countUsersQuery = "SELECT COUNT(*) from users"
+	fetchUserQuery  = "SELECT id,name,email,password from users where email=$1"
+	createUserQuery = "INSERT INTO users(name,email,password) VALUES($1,$2,$3)"
+	deleteUserQuery = "DELETE FROM users WHERE email=$1"
+	updateUserQuery = "UPDATE users SET name=COALESCE($2,name),password=COALESCE($3,password) WHERE email=$1"
+
+	fetchUserRolesQuery = "SELECT role from user_roles where user_id=$1 ORDER BY role"
+	grantRoleQuery      = "INSERT INTO user_roles(user_id,role) SELECT id,$2 from users where email=$1 ON CONFLICT DO NOTHING"
+	revokeRoleQuery     = "DELETE FROM user_roles WHERE role=$2 AND user_id=(SELECT id from users where email=$1)"
+
+	// the generations are deleted with their users, the provider revokes the
+	// token families of a deleted user so that an account created again with
+	// the email does not accept the tokens of generation 0.
+	fetchSessionGenerationQuery     = "SELECT generation from session_generations where email=$1"
+	incrementSessionGenerationQuery = "INSERT INTO session_generations(email,generation) VALUES($1,1) " +
+		"ON CONFLICT (email) DO UPDATE SET generation=session_generations.generation+1"
 )
 
+// sessionGenerationTTL bounds how long a cached generation is used. the cached
+// generation is deleted on every increment, a lookup racing with an increment
+// can still cache the previous generation until it expires.
+const sessionGenerationTTL = time.Minute
+
 var funcGenerate = generateHash
 
-// NewUserRepository returns a new instance of the user repository.
+// NewUserRepository returns a new instance of the user repository
+// backed by a postgres database.
 func NewUserRepository(db *sql.DB, cache cache.Cache) UserRepository {
 	return &UserRepo{
-		cache: cache,
-		db:    db,
+		cache:             cache,
+		db:                db,
+		isUniqueViolation: isPostgresUniqueViolation,
+		rebind:            func(query string) string { return query },
+		stmts:             make(map[string]*sql.Stmt),
 	}
 }
 
-// Create a new user.
-func (ur *UserRepo) Create(ctx context.Context, user *models.User) error {
+// prepare returns a prepared statement for the query,
+// the statement is prepared once and reused on subsequent calls.
+func (ur *UserRepo) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	ur.mu.Lock()
+	defer ur.mu.Unlock()
 
-	tx, err := ur.db.BeginTx(ctx, nil)
+	if stmt, ok := ur.stmts[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := ur.db.PrepareContext(ctx, ur.rebind(query))
 	if err != nil {
-		logger.Log().Error("error while starting transaction", zap.Error(err))
-		return err
+		logger.FromContext(ctx).Error("error while preparing query", zap.String("query", query), zap.Error(err))
+		return nil, err
 	}
 
+	ur.stmts[query] = stmt
+	return stmt, nil
+}
+
+// Create a new user.
+func (ur *UserRepo) Create(ctx context.Context, user *models.User) error {
+
 	hashedPassword, err := funcGenerate(user.Password)
 	if err != nil {
-		logger.Log().Error("error while hashing password", zap.Error(err))
+		logger.FromContext(ctx).Error("error while hashing password", zap.Error(err))
 		return err
 	}
 
-	query := fmt.Sprintf(createUserQuery, user.Name, user.Email, hashedPassword)
-	stmt, err := tx.Prepare(query)
-
+	stmt, err := ur.prepare(ctx, createUserQuery)
 	if err != nil {
-		logger.Log().Error("error while preparing query", zap.String("query", query), zap.Error(err))
-		rollBackErr := tx.Rollback()
-		if rollBackErr != nil {
-			logger.Log().Error("Error while rolling back transaction", zap.String("query", query), zap.Error(err))
-		}
 		return err
 	}
 
-	_, err = stmt.Exec()
-
+	start := time.Now()
+	_, err = stmt.ExecContext(ctx, user.Name, user.Email, string(hashedPassword))
+	metrics.ObserveQuery("create_user", start)
 	if err != nil {
 		// check for duplicate key error.
-		if err.Error() == "pq: duplicate key value violates unique constraint \"users_email_key\"" {
-			err = errors.New(common.AccountAlreadyExistsError)
-		}
-
-		logger.Log().Error("error while executing query", zap.String("query", query), zap.Error(err))
-		rollBackErr := tx.Rollback()
-
-		if rollBackErr != nil {
-			logger.Log().Error("Error while rolling back transaction", zap.String("query", query), zap.Error(err))
+		if ur.isUniqueViolation(err) {
+			err = apperrors.ErrAccountAlreadyExists.Wrap(err)
 		}
-		return err
-	}
 
-	err = tx.Commit()
-	if err != nil {
-		logger.Log().Error("Error while committing transaction", zap.String("query", query), zap.Error(err))
+		logger.FromContext(ctx).Error("error while executing query", zap.String("query", createUserQuery), zap.Error(err))
 		return err
 	}
 
@@ -105,63 +148,159 @@ func (ur *UserRepo) Create(ctx context.Context, user *models.User) error {
 func (ur *UserRepo) Fetch(ctx context.Context, emailID string) (*models.User, error) {
 	user := new(models.User)
 
-	val, err := ur.cache.Get(fmt.Sprintf("user:%s", emailID))
+	val, err := ur.cache.Get(ctx, fmt.Sprintf("user:%s", emailID))
 
 	if err != nil || val == "" {
-		logger.Log().Error("Error while fetching user from cache", zap.String("key", emailID), zap.Error(err))
+		logger.FromContext(ctx).Error("Error while fetching user from cache", zap.String("key", emailID), zap.Error(err))
 	} else {
 		bytes := []byte(val)
 		err = json.Unmarshal(bytes, &user)
 		if err != nil {
-			logger.Log().Error("error while unmarshalling user", zap.String("key", emailID), zap.Error(err))
+			logger.FromContext(ctx).Error("error while unmarshalling user", zap.String("key", emailID), zap.Error(err))
 			return nil, err
 		}
 		return user, nil
 	}
 
-	query := fmt.Sprintf(fetchUserQuery, emailID)
-	rows, err := ur.db.QueryContext(ctx, query)
+	stmt, err := ur.prepare(ctx, fetchUserQuery)
 	if err != nil {
-		logger.Log().Error("error while querying user", zap.String("query", query), zap.Error(err))
 		return nil, err
 	}
 
-	for rows.Next() {
-		err = rows.Scan(&user.ID, &user.Name, &user.Email, &user.Password)
-		if err != nil {
-			logger.Log().Error("error while scanning rows", zap.String("query", query), zap.Error(err))
-			return nil, err
-		}
+	start := time.Now()
+	err = stmt.QueryRowContext(ctx, emailID).Scan(&user.ID, &user.Name, &user.Email, &user.Password)
+	metrics.ObserveQuery("fetch_user", start)
+	if err == sql.ErrNoRows {
+		logger.FromContext(ctx).Info(common.AccountNotFoundError, zap.String("email", emailID))
+		return nil, apperrors.ErrAccountNotFound
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("error while querying user", zap.String("query", fetchUserQuery), zap.Error(err))
+		return nil, err
+	}
+
+	user.Roles, err = ur.fetchRoles(ctx, user.ID)
+	if err != nil {
+		return nil, err
 	}
 
 	bytes, err := json.Marshal(user)
 	if err != nil {
-		logger.Log().Error("error while unmarshaling data from cache", zap.String("key", emailID), zap.Error(err))
+		logger.FromContext(ctx).Error("error while unmarshaling data from cache", zap.String("key", emailID), zap.Error(err))
 	}
-	_, err = ur.cache.Set(fmt.Sprintf("user:%s", emailID), string(bytes), time.Duration(0))
+	// the user is only a copy of the database, it can be evicted.
+	_, err = ur.cache.SetEvictable(ctx, fmt.Sprintf("user:%s", emailID), string(bytes), time.Duration(0))
 	if err != nil {
-		logger.Log().Error("error while setting cache", zap.String("key", emailID), zap.Error(err))
+		logger.FromContext(ctx).Error("error while setting cache", zap.String("key", emailID), zap.Error(err))
 	}
 
 	return user, nil
 }
 
-// List users.
-func (ur *UserRepo) List(ctx context.Context) ([]models.User, error) {
+// sortColumns maps the sort options to the columns of the users table.
+var sortColumns = map[string]string{
+	"id":    "id",
+	"name":  "name",
+	"email": "email",
+}
+
+// userCursor is the position after which the next page starts.
+// it is handed out base64 encoded and is opaque to clients.
+type userCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    string `json:"i"`
+}
+
+// List a page of users, users are paged using a cursor
+// over the sort column with the id breaking ties.
+func (ur *UserRepo) List(ctx context.Context, request *models.ListUsersRequest) (*models.UserPage, error) {
+
+	var response = &models.UserPage{Users: []models.User{}}
+
+	column, ok := sortColumns[request.Sort]
+	if !ok {
+		return nil, apperrors.ErrBadRequest
+	}
+
+	// filters
+	var filters []string
+	var args []interface{}
+
+	if request.EmailPrefix != "" {
+		args = append(args, escapeLike(request.EmailPrefix)+"%")
+		filters = append(filters, fmt.Sprintf("email LIKE $%d ESCAPE '\\'", len(args)))
+	}
+
+	if request.NameContains != "" {
+		args = append(args, "%"+escapeLike(strings.ToLower(request.NameContains))+"%")
+		filters = append(filters, fmt.Sprintf("LOWER(name) LIKE $%d ESCAPE '\\'", len(args)))
+	}
+
+	// counting is a scan of the matching rows, it is only
+	// done for the first page rather than for every page.
+	if request.Cursor == "" {
+		total, err := ur.count(ctx, filters, args)
+		if err != nil {
+			return nil, err
+		}
+		response.TotalEstimate = total
+	}
+
+	// position
+	comparison := ">"
+	direction := "ASC"
+	if request.Descending {
+		comparison = "<"
+		direction = "DESC"
+	}
 
-	var response = []models.User{}
+	if request.Cursor != "" {
+		cursor, err := decodeUserCursor(request.Cursor)
+		if err != nil || cursor.Sort != request.Sort || cursor.Desc != request.Descending {
+			logger.FromContext(ctx).Info(common.InvalidCursorError, zap.String("cursor", request.Cursor))
+			return nil, apperrors.ErrInvalidCursor
+		}
 
-	rows, err := ur.db.QueryContext(ctx, listUsersQuery)
+		if column == "id" {
+			args = append(args, cursor.ID)
+			filters = append(filters, fmt.Sprintf("id %s $%d", comparison, len(args)))
+		} else {
+			args = append(args, cursor.Value, cursor.ID)
+			filters = append(filters, fmt.Sprintf("(%s %s $%d OR (%s = $%d AND id %s $%d))",
+				column, comparison, len(args)-1, column, len(args)-1, comparison, len(args)))
+		}
+	}
+
+	// the id breaks ties of the other columns.
+	order := fmt.Sprintf("%s %s", column, direction)
+	if column != "id" {
+		order += fmt.Sprintf(", id %s", direction)
+	}
 
+	// fetch one extra row to know whether there is a next page.
+	args = append(args, request.Limit+1)
+	query := listUsersQuery + whereClause(filters) + fmt.Sprintf(" ORDER BY %s LIMIT $%d", order, len(args))
+
+	stmt, err := ur.prepare(ctx, query)
 	if err != nil {
-		logger.Log().Error("error while querying data", zap.String("query", listUsersQuery), zap.Error(err))
+		return nil, err
+	}
+
+	start := time.Now()
+	rows, err := stmt.QueryContext(ctx, args...)
+	metrics.ObserveQuery("list_users", start)
+
+	if err != nil {
+		logger.FromContext(ctx).Error("error while querying data", zap.String("query", query), zap.Error(err))
 		return nil, err
 	}
 
 	defer func() {
 		err = rows.Close()
 		if err != nil {
-			logger.Log().Error("error while closing rows", zap.Error(err))
+			logger.FromContext(ctx).Error("error while closing rows", zap.Error(err))
 		}
 	}()
 
@@ -169,67 +308,340 @@ func (ur *UserRepo) List(ctx context.Context) ([]models.User, error) {
 		user := new(models.User)
 		err = rows.Scan(&user.ID, &user.Name, &user.Email)
 		if err != nil {
-			logger.Log().Error("error while scanning row data into user", zap.String("query", listUsersQuery), zap.Error(err))
+			logger.FromContext(ctx).Error("error while scanning row data into user", zap.String("query", query), zap.Error(err))
 			return nil, err
 		}
 
-		response = append(response, *user)
+		response.Users = append(response.Users, *user)
+	}
+
+	if len(response.Users) > request.Limit {
+		response.Users = response.Users[:request.Limit]
+		last := response.Users[len(response.Users)-1]
+
+		response.NextCursor = encodeUserCursor(&userCursor{
+			Sort:  request.Sort,
+			Desc:  request.Descending,
+			Value: sortValue(&last, column),
+			ID:    last.ID,
+		})
 	}
 
 	return response, nil
 }
 
+// count returns the number of users matching the filters.
+func (ur *UserRepo) count(ctx context.Context, filters []string, args []interface{}) (int64, error) {
+	var total int64
+
+	query := countUsersQuery + whereClause(filters)
+
+	stmt, err := ur.prepare(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	err = stmt.QueryRowContext(ctx, args...).Scan(&total)
+	metrics.ObserveQuery("count_users", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while counting users", zap.String("query", query), zap.Error(err))
+		return 0, err
+	}
+
+	return total, nil
+}
+
 // Delete user based on id.
 func (ur *UserRepo) Delete(ctx context.Context, email string) error {
 
-	key := fmt.Sprintf("user:%s", email)
-	err := ur.cache.Del(key)
+	for _, key := range []string{fmt.Sprintf("user:%s", email), sessionGenerationKey(email)} {
+		err := ur.cache.Del(ctx, key)
+		if err != nil {
+			logger.FromContext(ctx).Error("error while deleting from cache", zap.String("key", key), zap.Error(err))
+			return err
+		}
+	}
+
+	stmt, err := ur.prepare(ctx, deleteUserQuery)
 	if err != nil {
-		logger.Log().Error("error while deleting from cache", zap.String("key", key), zap.Error(err))
 		return err
 	}
 
-	tx, err := ur.db.BeginTx(ctx, nil)
+	start := time.Now()
+	result, err := stmt.ExecContext(ctx, email)
+	metrics.ObserveQuery("delete_user", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while executing query", zap.String("query", deleteUserQuery), zap.Error(err))
+		return err
+	}
+	rows, err := result.RowsAffected()
 	if err != nil {
-		logger.Log().Error("error while starting transaction", zap.Error(err))
+		logger.FromContext(ctx).Error("error while fetching rows", zap.String("query", deleteUserQuery), zap.Error(err))
 		return err
 	}
 
-	query := fmt.Sprintf(deleteUserQuery, email)
+	if rows == 0 {
+		logger.FromContext(ctx).Info(common.AccountNotFoundError, zap.String("email", email))
+		return apperrors.ErrAccountNotFound
+	}
+
+	logger.FromContext(ctx).Info("successfully deleted user", zap.String("email", email), zap.Int64("rows affected", rows))
+
+	return nil
+}
+
+// Update applies a partial update to a user.
+func (ur *UserRepo) Update(ctx context.Context, email string, update *models.UserUpdate) error {
+	var name, password sql.NullString
+
+	if update.Name != nil {
+		name = sql.NullString{String: *update.Name, Valid: true}
+	}
 
-	stmt, err := tx.PrepareContext(ctx, query)
+	if update.Password != nil {
+		hashedPassword, err := funcGenerate(*update.Password)
+		if err != nil {
+			logger.FromContext(ctx).Error("error while hashing password", zap.Error(err))
+			return err
+		}
+		password = sql.NullString{String: string(hashedPassword), Valid: true}
+	}
+
+	stmt, err := ur.prepare(ctx, updateUserQuery)
 	if err != nil {
-		logger.Log().Error("error while preparing query", zap.String("query", query), zap.Error(err))
 		return err
 	}
 
-	result, err := stmt.ExecContext(ctx)
+	start := time.Now()
+	result, err := stmt.ExecContext(ctx, email, name, password)
+	metrics.ObserveQuery("update_user", start)
 	if err != nil {
-		logger.Log().Error("error while executing query", zap.String("query", query), zap.Error(err))
+		logger.FromContext(ctx).Error("error while executing query", zap.String("query", updateUserQuery), zap.Error(err))
 		return err
 	}
 	rows, err := result.RowsAffected()
 	if err != nil {
-		logger.Log().Error("error while fetching rows", zap.String("query", query), zap.Error(err))
+		logger.FromContext(ctx).Error("error while fetching rows", zap.String("query", updateUserQuery), zap.Error(err))
 		return err
 	}
 
 	if rows == 0 {
-		logger.Log().Info(common.AccountNotFoundError, zap.String("email", email))
-		return errors.New(common.AccountNotFoundError)
+		logger.FromContext(ctx).Info(common.AccountNotFoundError, zap.String("email", email))
+		return apperrors.ErrAccountNotFound
 	}
 
-	err = tx.Commit()
+	// the cached user is stale now.
+	key := fmt.Sprintf("user:%s", email)
+	err = ur.cache.Del(ctx, key)
 	if err != nil {
-		logger.Log().Error("Error while committing transaction", zap.String("query", query), zap.Error(err))
+		logger.FromContext(ctx).Error("error while deleting from cache", zap.String("key", key), zap.Error(err))
 		return err
 	}
 
-	logger.Log().Info("successfully deleted user", zap.String("email", email), zap.Int64("rows affected", rows))
+	logger.FromContext(ctx).Info("successfully updated user", zap.String("email", email))
 
 	return nil
 }
 
+// GrantRole grants a role to a user, granting a role
+// the user already has is a no-op.
+func (ur *UserRepo) GrantRole(ctx context.Context, email, role string) error {
+	return ur.execRoleQuery(ctx, "grant_role", grantRoleQuery, email, role)
+}
+
+// RevokeRole revokes a role from a user, revoking a role
+// the user does not have is a no-op.
+func (ur *UserRepo) RevokeRole(ctx context.Context, email, role string) error {
+	return ur.execRoleQuery(ctx, "revoke_role", revokeRoleQuery, email, role)
+}
+
+// execRoleQuery executes a role query and invalidates
+// the cached user so the next Fetch returns the new roles.
+func (ur *UserRepo) execRoleQuery(ctx context.Context, name, query, email, role string) error {
+	stmt, err := ur.prepare(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	_, err = stmt.ExecContext(ctx, email, role)
+	metrics.ObserveQuery(name, start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while executing query", zap.String("query", query), zap.Error(err))
+		return err
+	}
+
+	key := fmt.Sprintf("user:%s", email)
+	err = ur.cache.Del(ctx, key)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while deleting from cache", zap.String("key", key), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// SessionGeneration returns the current session generation of a user,
+// tokens issued for an older generation are no longer valid. It is checked
+// on every authenticated request, so it is cached in front of the database.
+func (ur *UserRepo) SessionGeneration(ctx context.Context, email string) (int64, error) {
+	key := sessionGenerationKey(email)
+
+	val, err := ur.cache.Get(ctx, key)
+	if err == nil {
+		generation, err := strconv.ParseInt(val, 10, 64)
+		if err == nil {
+			return generation, nil
+		}
+		logger.FromContext(ctx).Error("error while parsing cached generation", zap.String("key", key), zap.Error(err))
+	} else if !errors.Is(err, cache.ErrNotFound) {
+		logger.FromContext(ctx).Error("error while fetching generation from cache", zap.String("key", key), zap.Error(err))
+	}
+
+	stmt, err := ur.prepare(ctx, fetchSessionGenerationQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	var generation int64
+	start := time.Now()
+	err = stmt.QueryRowContext(ctx, email).Scan(&generation)
+	metrics.ObserveQuery("fetch_session_generation", start)
+	if err != nil && err != sql.ErrNoRows {
+		logger.FromContext(ctx).Error("error while executing query", zap.String("query", fetchSessionGenerationQuery), zap.Error(err))
+		return 0, err
+	}
+	// without a row the sessions of the user were never revoked, generation 0.
+
+	// the generation is only a copy of the database, it can be evicted.
+	_, err = ur.cache.SetEvictable(ctx, key, strconv.FormatInt(generation, 10), sessionGenerationTTL)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while setting cache", zap.String("key", key), zap.Error(err))
+	}
+	return generation, nil
+}
+
+// IncrementSessionGeneration invalidates all the tokens issued for a user so far,
+// the increment is a single statement so concurrent revocations are all counted.
+// The cached generation is deleted so that the new one is used right away.
+func (ur *UserRepo) IncrementSessionGeneration(ctx context.Context, email string) error {
+	stmt, err := ur.prepare(ctx, incrementSessionGenerationQuery)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	_, err = stmt.ExecContext(ctx, email)
+	metrics.ObserveQuery("increment_session_generation", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while executing query", zap.String("query", incrementSessionGenerationQuery), zap.Error(err))
+		return err
+	}
+
+	key := sessionGenerationKey(email)
+	err = ur.cache.Del(ctx, key)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while deleting from cache", zap.String("key", key), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// sessionGenerationKey returns the cache key of the session generation of a user.
+func sessionGenerationKey(email string) string {
+	return fmt.Sprintf("generation:%s", email)
+}
+
+// fetchRoles returns the roles explicitly granted to a user.
+func (ur *UserRepo) fetchRoles(ctx context.Context, userID string) ([]string, error) {
+	var roles []string
+
+	stmt, err := ur.prepare(ctx, fetchUserRolesQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	rows, err := stmt.QueryContext(ctx, userID)
+	metrics.ObserveQuery("fetch_user_roles", start)
+	if err != nil {
+		logger.FromContext(ctx).Error("error while querying roles", zap.String("query", fetchUserRolesQuery), zap.Error(err))
+		return nil, err
+	}
+
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			logger.FromContext(ctx).Error("error while closing rows", zap.Error(err))
+		}
+	}()
+
+	for rows.Next() {
+		var role string
+		err = rows.Scan(&role)
+		if err != nil {
+			logger.FromContext(ctx).Error("error while scanning row data into role", zap.String("query", fetchUserRolesQuery), zap.Error(err))
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// whereClause joins the filters into a where clause.
+func whereClause(filters []string) string {
+	if len(filters) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(filters, " AND ")
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(value)
+}
+
+// sortValue returns the value of the sort column of a user.
+func sortValue(user *models.User, column string) string {
+	switch column {
+	case "name":
+		return user.Name
+	case "email":
+		return user.Email
+	default:
+		return user.ID
+	}
+}
+
+// encodeUserCursor encodes a cursor to an opaque string.
+func encodeUserCursor(cursor *userCursor) string {
+	bytes, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// decodeUserCursor decodes an opaque cursor string,
+// the id of the cursor must be the id of a user.
+func decodeUserCursor(value string) (*userCursor, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := new(userCursor)
+	err = json.Unmarshal(bytes, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = strconv.ParseInt(cursor.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
 // generateHash generates a hash for a given password
 func generateHash(pass string) ([]byte, error) {
 	return bcrypt.GenerateFromPassword([]byte(pass), 10)
@@ -4,13 +4,18 @@ package stores
 
 import (
 	"context"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"gicicm/adapters/cache"
 	cacheMock "gicicm/adapters/cache/mocks"
+	"gicicm/apperrors"
 	"gicicm/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"regexp"
 	"testing"
 )
 
@@ -22,8 +27,8 @@ func TestUserStore_Fetch_CacheMiss(t *testing.T) {
 	}
 	mockCache := new(cacheMock.Cache)
 	key := fmt.Sprintf("user:%s", emailID)
-	mockCache.On("Get", key).Return("", nil)
-	mockCache.On("Set", mock.Anything, mock.Anything, mock.Anything).Return("", nil)
+	mockCache.On("Get", mock.Anything, key).Return("", nil)
+	mockCache.On("SetEvictable", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return("", nil)
 
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -34,9 +39,10 @@ func TestUserStore_Fetch_CacheMiss(t *testing.T) {
 	rows := sqlmock.NewRows([]string{"id", "name", "email", "password"}).
 		AddRow(1, "testuser", "test@test.com", "asda9sdu9as8hda9sgca86sdtfas68")
 
-	query := fmt.Sprintf(fetchUserQuery, emailID)
-
-	mockSQL.ExpectQuery(query).WillReturnRows(rows)
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(fetchUserQuery)).
+		ExpectQuery().WithArgs(emailID).WillReturnRows(rows)
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(fetchUserRolesQuery)).
+		ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("admin"))
 
 	c := NewUserRepository(db, mockCache)
 
@@ -44,8 +50,9 @@ func TestUserStore_Fetch_CacheMiss(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Equal(t, emailID, user.Email)
+	assert.Equal(t, []string{"admin"}, user.Roles)
 	mockCache.AssertExpectations(t)
-	_ = mockSQL.ExpectationsWereMet()
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
 
 }
 
@@ -63,7 +70,7 @@ func TestUserStore_Fetch_CacheHit(t *testing.T) {
 	}
 
 	key := fmt.Sprintf("user:%s", mockUser.Email)
-	mockCache.On("Get", key).Return(string(b), nil)
+	mockCache.On("Get", mock.Anything, key).Return(string(b), nil)
 
 	userRepo := NewUserRepository(nil, mockCache)
 	user, err := userRepo.Fetch(context.TODO(), mockUser.Email)
@@ -73,6 +80,30 @@ func TestUserStore_Fetch_CacheHit(t *testing.T) {
 	mockCache.AssertExpectations(t)
 }
 
+func TestUserStore_Fetch_NotFound(t *testing.T) {
+	emailID := "missing@test.com"
+	db, mockSQL, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+	}
+	defer db.Close()
+
+	mockCache := new(cacheMock.Cache)
+	mockCache.On("Get", mock.Anything, fmt.Sprintf("user:%s", emailID)).Return("", nil)
+
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(fetchUserQuery)).
+		ExpectQuery().WithArgs(emailID).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "password"}))
+
+	userRepo := NewUserRepository(db, mockCache)
+	user, err := userRepo.Fetch(context.TODO(), emailID)
+
+	// a missing user must not be cached.
+	assert.Nil(t, user)
+	assert.True(t, errors.Is(err, apperrors.ErrAccountNotFound))
+	mockCache.AssertExpectations(t)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
 func TestUserStore_CreateUser(t *testing.T) {
 	db, mockSQL, err := sqlmock.New()
 	if err != nil {
@@ -94,16 +125,14 @@ func TestUserStore_CreateUser(t *testing.T) {
 		funcGenerate = generateHash
 	}()
 
-	query := fmt.Sprintf("['INSERT INTO users(name,email,password) VALUES('%s','%s','%s')']", mockUser.Name, mockUser.Email, "asdasd")
-
-	mockSQL.ExpectBegin()
-	mockSQL.ExpectPrepare(query).ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1)).WillReturnError(nil)
-	mockSQL.ExpectCommit()
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(createUserQuery)).
+		ExpectExec().WithArgs(mockUser.Name, mockUser.Email, "asdsad").WillReturnResult(sqlmock.NewResult(1, 1))
 
 	userRepo := NewUserRepository(db, nil)
 
 	err = userRepo.Create(context.TODO(), mockUser)
 	assert.NoError(t, err)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
 }
 
 func TestUserStore_ListUsers(t *testing.T) {
@@ -136,16 +165,75 @@ func TestUserStore_ListUsers(t *testing.T) {
 		AddRow("2", "test1User", "test1@test.com").
 		AddRow("3", "test3User", "test3@test.com")
 
-	query := listUsersQuery
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(countUsersQuery)).
+		ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(listUsersQuery + " ORDER BY id ASC LIMIT $1")).
+		ExpectQuery().WithArgs(51).WillReturnRows(rows)
+
+	userRepo := NewUserRepository(db, nil)
+	page, err := userRepo.List(context.TODO(), &models.ListUsersRequest{Limit: 50, Sort: "id"})
 
-	mockSQL.ExpectQuery(query).WillReturnRows(rows)
+	assert.NoError(t, err)
+	assert.Equal(t, len(mockUsers), len(page.Users))
+	assert.Equal(t, int64(3), page.TotalEstimate)
+	assert.Empty(t, page.NextCursor)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+func TestUserStore_ListUsers_Pagination(t *testing.T) {
+	db, mockSQL, _ := sqlmock.New()
+	defer db.Close()
+
+	request := &models.ListUsersRequest{Limit: 2, Sort: "name", EmailPrefix: "te%st_"}
+
+	// first page, the extra row signals a next page.
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(countUsersQuery + ` WHERE email LIKE $1 ESCAPE '\'`)).
+		ExpectQuery().WithArgs(`te\%st\_%`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(listUsersQuery+` WHERE email LIKE $1 ESCAPE '\' ORDER BY name ASC, id ASC LIMIT $2`)).
+		ExpectQuery().WithArgs(`te\%st\_%`, 3).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).
+		AddRow("1", "a", "te%st_a@test.com").
+		AddRow("2", "b", "te%st_b@test.com").
+		AddRow("3", "c", "te%st_c@test.com"))
 
 	userRepo := NewUserRepository(db, nil)
-	users, err := userRepo.List(context.TODO())
+	page, err := userRepo.List(context.TODO(), request)
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 2)
+	assert.NotEmpty(t, page.NextCursor)
+
+	// second page continues after the last user of the first page, without counting again.
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(listUsersQuery+` WHERE email LIKE $1 ESCAPE '\' AND (name > $2 OR (name = $2 AND id > $3)) ORDER BY name ASC, id ASC LIMIT $4`)).
+		ExpectQuery().WithArgs(`te\%st\_%`, "b", "2", 3).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email"}).
+		AddRow("3", "c", "te%st_c@test.com"))
+
+	request.Cursor = page.NextCursor
+	page, err = userRepo.List(context.TODO(), request)
 
 	assert.NoError(t, err)
-	assert.ObjectsAreEqualValues(len(mockUsers), len(users))
-	assert.ObjectsAreEqualValues(mockUsers, users)
+	assert.Len(t, page.Users, 1)
+	assert.Empty(t, page.NextCursor)
+	assert.Zero(t, page.TotalEstimate)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+func TestUserStore_ListUsers_InvalidCursor(t *testing.T) {
+	db, mockSQL, _ := sqlmock.New()
+	defer db.Close()
+
+	userRepo := NewUserRepository(db, nil)
+
+	// a cursor handed out for a different sort order is rejected as well.
+	cursor := encodeUserCursor(&userCursor{Sort: "email", Value: "a@test.com", ID: "1"})
+	_, err := userRepo.List(context.TODO(), &models.ListUsersRequest{Limit: 2, Sort: "name", Cursor: cursor})
+	assert.True(t, errors.Is(err, apperrors.ErrInvalidCursor))
+
+	// so is a cursor with an id that is not the id of a user.
+	cursor = encodeUserCursor(&userCursor{Sort: "id", Value: "x", ID: "x"})
+	_, err = userRepo.List(context.TODO(), &models.ListUsersRequest{Limit: 2, Sort: "id", Cursor: cursor})
+	assert.True(t, errors.Is(err, apperrors.ErrInvalidCursor))
+
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
 }
 
 func TestUserStore_DeleteUser(t *testing.T) {
@@ -164,19 +252,343 @@ func TestUserStore_DeleteUser(t *testing.T) {
 
 	key := fmt.Sprintf("user:%s", mockUser.Email)
 
-	mockCache.On("Del", key).Return(nil)
+	mockCache.On("Del", mock.Anything, key).Return(nil)
+	mockCache.On("Del", mock.Anything, sessionGenerationKey(mockUser.Email)).Return(nil)
 
 	defer db.Close()
 
-	query := fmt.Sprintf(deleteUserQuery, mockUser.Email)
-
-	mockSQL.ExpectBegin()
-	mockSQL.ExpectPrepare(query).ExpectExec().WillReturnResult(sqlmock.NewResult(1, 1)).WillReturnError(nil)
-	mockSQL.ExpectCommit()
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(deleteUserQuery)).
+		ExpectExec().WithArgs(mockUser.Email).WillReturnResult(sqlmock.NewResult(1, 1))
 
 	userRepo := NewUserRepository(db, mockCache)
 	err = userRepo.Delete(context.TODO(), mockUser.Email)
 
 	assert.NoError(t, err)
 	mockCache.AssertExpectations(t)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+// hostileInputs are values that would break or inject into
+// a query built with string interpolation.
+var hostileInputs = []string{
+	"o'brien@test.com",
+	"x@test.com' OR '1'='1",
+	"x@test.com'; DROP TABLE users; --",
+	`quote"s\\back@test.com`,
+	"robert'); DELETE FROM users WHERE ('1'='1",
+}
+
+func TestUserStore_Create_HostileInput(t *testing.T) {
+	for _, input := range hostileInputs {
+		t.Run(input, func(t *testing.T) {
+			db, mockSQL, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+			}
+			defer db.Close()
+
+			funcGenerate = func(pass string) ([]byte, error) {
+				return []byte("hashed"), nil
+			}
+			defer func() {
+				funcGenerate = generateHash
+			}()
+
+			mockSQL.ExpectPrepare(regexp.QuoteMeta(createUserQuery)).
+				ExpectExec().WithArgs(input, input, "hashed").WillReturnResult(sqlmock.NewResult(1, 1))
+
+			userRepo := NewUserRepository(db, nil)
+			err = userRepo.Create(context.TODO(), &models.User{Name: input, Email: input, Password: "Hello@123123"})
+
+			assert.NoError(t, err)
+			assert.NoError(t, mockSQL.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUserStore_Fetch_HostileInput(t *testing.T) {
+	for _, input := range hostileInputs {
+		t.Run(input, func(t *testing.T) {
+			db, mockSQL, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+			}
+			defer db.Close()
+
+			mockCache := new(cacheMock.Cache)
+			mockCache.On("Get", mock.Anything, fmt.Sprintf("user:%s", input)).Return("", nil)
+			mockCache.On("SetEvictable", mock.Anything, fmt.Sprintf("user:%s", input), mock.Anything, mock.Anything).Return("", nil)
+
+			rows := sqlmock.NewRows([]string{"id", "name", "email", "password"}).
+				AddRow(1, input, input, "hashed")
+			mockSQL.ExpectPrepare(regexp.QuoteMeta(fetchUserQuery)).
+				ExpectQuery().WithArgs(input).WillReturnRows(rows)
+			mockSQL.ExpectPrepare(regexp.QuoteMeta(fetchUserRolesQuery)).
+				ExpectQuery().WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+			userRepo := NewUserRepository(db, mockCache)
+			user, err := userRepo.Fetch(context.TODO(), input)
+
+			assert.NoError(t, err)
+			assert.Equal(t, input, user.Email)
+			assert.Equal(t, input, user.Name)
+			mockCache.AssertExpectations(t)
+			assert.NoError(t, mockSQL.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUserStore_Delete_HostileInput(t *testing.T) {
+	for _, input := range hostileInputs {
+		t.Run(input, func(t *testing.T) {
+			db, mockSQL, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+			}
+			defer db.Close()
+
+			mockCache := new(cacheMock.Cache)
+			mockCache.On("Del", mock.Anything, fmt.Sprintf("user:%s", input)).Return(nil)
+			mockCache.On("Del", mock.Anything, sessionGenerationKey(input)).Return(nil)
+
+			// no rows match the hostile email, so nothing may be deleted.
+			mockSQL.ExpectPrepare(regexp.QuoteMeta(deleteUserQuery)).
+				ExpectExec().WithArgs(input).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			userRepo := NewUserRepository(db, mockCache)
+			err = userRepo.Delete(context.TODO(), input)
+
+			assert.True(t, errors.Is(err, apperrors.ErrAccountNotFound))
+			mockCache.AssertExpectations(t)
+			assert.NoError(t, mockSQL.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUserStore_PreparedStatementsAreReused(t *testing.T) {
+	db, mockSQL, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+	}
+	defer db.Close()
+
+	mockCache := new(cacheMock.Cache)
+	mockCache.On("Del", mock.Anything, mock.Anything).Return(nil)
+
+	// the statement is prepared only once for both calls.
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(deleteUserQuery))
+	mockSQL.ExpectExec(regexp.QuoteMeta(deleteUserQuery)).WithArgs("one@test.com").WillReturnResult(sqlmock.NewResult(0, 1))
+	mockSQL.ExpectExec(regexp.QuoteMeta(deleteUserQuery)).WithArgs("two@test.com").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	userRepo := NewUserRepository(db, mockCache)
+	assert.NoError(t, userRepo.Delete(context.TODO(), "one@test.com"))
+	assert.NoError(t, userRepo.Delete(context.TODO(), "two@test.com"))
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+func TestUserStore_UpdateUser(t *testing.T) {
+	name := "new name"
+	password := "Hello@123123"
+
+	tests := []struct {
+		name             string
+		update           *models.UserUpdate
+		expectedName     driver.Value
+		expectedPassword driver.Value
+	}{
+		{
+			name:             "name only",
+			update:           &models.UserUpdate{Name: &name},
+			expectedName:     name,
+			expectedPassword: nil,
+		},
+		{
+			name:             "password only",
+			update:           &models.UserUpdate{Password: &password},
+			expectedName:     nil,
+			expectedPassword: "hashed",
+		},
+		{
+			name:             "name and password",
+			update:           &models.UserUpdate{Name: &name, Password: &password},
+			expectedName:     name,
+			expectedPassword: "hashed",
+		},
+	}
+
+	funcGenerate = func(pass string) ([]byte, error) {
+		return []byte("hashed"), nil
+	}
+	defer func() {
+		funcGenerate = generateHash
+	}()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mockSQL, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+			}
+			defer db.Close()
+
+			email := "test@test.com"
+			mockCache := new(cacheMock.Cache)
+			mockCache.On("Del", mock.Anything, fmt.Sprintf("user:%s", email)).Return(nil)
+
+			mockSQL.ExpectPrepare(regexp.QuoteMeta(updateUserQuery)).
+				ExpectExec().WithArgs(email, tt.expectedName, tt.expectedPassword).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			userRepo := NewUserRepository(db, mockCache)
+			err = userRepo.Update(context.TODO(), email, tt.update)
+
+			assert.NoError(t, err)
+			mockCache.AssertExpectations(t)
+			assert.NoError(t, mockSQL.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUserStore_UpdateUser_NotFound(t *testing.T) {
+	db, mockSQL, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+	}
+	defer db.Close()
+
+	name := "new name"
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(updateUserQuery)).
+		ExpectExec().WithArgs("missing@test.com", name, nil).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	userRepo := NewUserRepository(db, nil)
+	err = userRepo.Update(context.TODO(), "missing@test.com", &models.UserUpdate{Name: &name})
+
+	assert.True(t, errors.Is(err, apperrors.ErrAccountNotFound))
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+func TestUserStore_GrantRole(t *testing.T) {
+	db, mockSQL, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+	}
+	defer db.Close()
+
+	email := "test@test.com"
+	mockCache := new(cacheMock.Cache)
+	mockCache.On("Del", mock.Anything, fmt.Sprintf("user:%s", email)).Return(nil)
+
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(grantRoleQuery)).
+		ExpectExec().WithArgs(email, "admin").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	userRepo := NewUserRepository(db, mockCache)
+	err = userRepo.GrantRole(context.TODO(), email, "admin")
+
+	assert.NoError(t, err)
+	mockCache.AssertExpectations(t)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+func TestUserStore_RevokeRole(t *testing.T) {
+	db, mockSQL, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+	}
+	defer db.Close()
+
+	email := "test@test.com"
+	mockCache := new(cacheMock.Cache)
+	mockCache.On("Del", mock.Anything, fmt.Sprintf("user:%s", email)).Return(nil)
+
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(revokeRoleQuery)).
+		ExpectExec().WithArgs(email, "admin").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	userRepo := NewUserRepository(db, mockCache)
+	err = userRepo.RevokeRole(context.TODO(), email, "admin")
+
+	assert.NoError(t, err)
+	mockCache.AssertExpectations(t)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+func TestUserStore_SessionGeneration(t *testing.T) {
+	db, mockSQL, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+	}
+	defer db.Close()
+
+	email := "test@test.com"
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(fetchSessionGenerationQuery)).
+		ExpectQuery().WithArgs(email).WillReturnRows(sqlmock.NewRows([]string{"generation"}).AddRow(42))
+
+	mockCache := new(cacheMock.Cache)
+	mockCache.On("Get", mock.Anything, "generation:test@test.com").Return("", cache.ErrNotFound)
+	mockCache.On("SetEvictable", mock.Anything, "generation:test@test.com", "42", sessionGenerationTTL).Return("OK", nil)
+
+	userRepo := NewUserRepository(db, mockCache)
+	generation, err := userRepo.SessionGeneration(context.TODO(), email)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), generation)
+	mockCache.AssertExpectations(t)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+func TestUserStore_SessionGeneration_CacheHit(t *testing.T) {
+	mockCache := new(cacheMock.Cache)
+	mockCache.On("Get", mock.Anything, "generation:test@test.com").Return("42", nil)
+
+	// the database is not queried.
+	userRepo := NewUserRepository(nil, mockCache)
+	generation, err := userRepo.SessionGeneration(context.TODO(), "test@test.com")
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), generation)
+	mockCache.AssertExpectations(t)
+}
+
+func TestUserStore_SessionGeneration_Error(t *testing.T) {
+	db, mockSQL, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+	}
+	defer db.Close()
+
+	email := "test@test.com"
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(fetchSessionGenerationQuery)).
+		ExpectQuery().WithArgs(email).WillReturnError(errors.New("connection refused"))
+
+	mockCache := new(cacheMock.Cache)
+	mockCache.On("Get", mock.Anything, "generation:test@test.com").Return("", cache.ErrNotFound)
+
+	// an unknown generation is an error, not generation 0.
+	userRepo := NewUserRepository(db, mockCache)
+	_, err = userRepo.SessionGeneration(context.TODO(), email)
+
+	assert.Error(t, err)
+	mockCache.AssertExpectations(t)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
+}
+
+func TestUserStore_IncrementSessionGeneration(t *testing.T) {
+	db, mockSQL, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected while setting up the mock db", err)
+	}
+	defer db.Close()
+
+	email := "test@test.com"
+	mockSQL.ExpectPrepare(regexp.QuoteMeta(incrementSessionGenerationQuery)).
+		ExpectExec().WithArgs(email).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// the cached generation is dropped so that the new one is used right away.
+	mockCache := new(cacheMock.Cache)
+	mockCache.On("Del", mock.Anything, "generation:test@test.com").Return(nil)
+
+	userRepo := NewUserRepository(db, mockCache)
+	err = userRepo.IncrementSessionGeneration(context.TODO(), email)
+
+	assert.NoError(t, err)
+	mockCache.AssertExpectations(t)
+	assert.NoError(t, mockSQL.ExpectationsWereMet())
 }
@@ -0,0 +1,5 @@
+INSERT into users(name, email, password) VALUES ('user to be deleted','delete@me.com','$2a$10easdasd$21hx81mFFbdlAn4Q9iEw5eYg86MPugTrd5HSxbw0s.PtlUB4XQlLu');
+INSERT into users(name, email, password) VALUES ('superadmin','clayton@test.com','$2a$10$21hx81mFFbdlAn4Q9iEw5eYg86MPugTrd5HSxbw0s.PtlUB4XQlLu');
+INSERT into users(name, email, password) VALUES ('test user 2','testtwo@mail.com','123123');
+INSERT into users(name, email, password) VALUES ('test user 1','test@mail.com','123123');
+INSERT into user_roles(user_id, role) SELECT id, 'admin' FROM users WHERE email = 'clayton@test.com';